@@ -0,0 +1,612 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"strings"
+	"time"
+
+	"github.com/frizinak/gomtg/mtgjson"
+)
+
+// LogEntry is a single commit in the audit log.
+type LogEntry struct {
+	ID       int          `json:"id"`
+	Time     time.Time    `json:"time"`
+	User     string       `json:"user"`
+	Added    []LogCard    `json:"added,omitempty"`
+	Removed  []LogCard    `json:"removed,omitempty"`
+	Tagged   []LogTagging `json:"tagged,omitempty"`
+	Remapped []LogRemap   `json:"remapped,omitempty"`
+	Owners   []LogOwner   `json:"owners,omitempty"`
+	Placed   []LogPlace   `json:"placed,omitempty"`
+	Loans    []LogLoan    `json:"loans,omitempty"`
+	Moved    []LogMove    `json:"moved,omitempty"`
+}
+
+// LogCard is a card that was added (index after the commit) or removed
+// (index before the commit).
+type LogCard struct {
+	ID    CopyID        `json:"id"`
+	Index int           `json:"index"`
+	UUID  mtgjson.UUID  `json:"uuid"`
+	Name  string        `json:"name"`
+	SetID mtgjson.SetID `json:"set_id"`
+	Owner string        `json:"owner,omitempty"`
+	// Location is <container>:<slot>, see ParseLocation.
+	Location string   `json:"location,omitempty"`
+	Tags     []string `json:"tags,omitempty"`
+	// Added is nil in entries from before it was recorded.
+	Added *time.Time `json:"added,omitempty"`
+}
+
+func newLogCard(c *DBCard, ix int, tags []string) LogCard {
+	l := LogCard{c.ID(), ix, c.UUID(), c.Name(), c.SetID(), c.Owner(), logLocation(c.Location()), tags, nil}
+	if t := c.Added(); !t.IsZero() {
+		l.Added = &t
+	}
+	return l
+}
+
+// LogTagging is a card whose tags changed, Index is after the commit.
+type LogTagging struct {
+	ID     CopyID       `json:"id"`
+	Index  int          `json:"index"`
+	UUID   mtgjson.UUID `json:"uuid"`
+	Name   string       `json:"name"`
+	Add    []string     `json:"add,omitempty"`
+	Remove []string     `json:"remove,omitempty"`
+}
+
+// LogRemap is a card that was pointed to another mtgjson card (or had its
+// name or set updated), Index is after the commit.
+type LogRemap struct {
+	ID    CopyID        `json:"id"`
+	Index int           `json:"index"`
+	From  mtgjson.UUID  `json:"from"`
+	To    mtgjson.UUID  `json:"to"`
+	Name  string        `json:"name"`
+	SetID mtgjson.SetID `json:"set_id"`
+}
+
+// LogOwner is a card that changed owners, Index is after the commit.
+type LogOwner struct {
+	ID    CopyID       `json:"id"`
+	Index int          `json:"index"`
+	UUID  mtgjson.UUID `json:"uuid"`
+	Name  string       `json:"name"`
+	From  string       `json:"from"`
+	To    string       `json:"to"`
+}
+
+// LogPlace is a card that was put in another container or slot (or taken
+// out of one), From and To are <container>:<slot> or empty, Index is after
+// the commit.
+type LogPlace struct {
+	ID    CopyID       `json:"id"`
+	Index int          `json:"index"`
+	UUID  mtgjson.UUID `json:"uuid"`
+	Name  string       `json:"name"`
+	From  string       `json:"from,omitempty"`
+	To    string       `json:"to,omitempty"`
+}
+
+// LogLoan is a card that was lent or returned, From and To are nil if it
+// was not lent, Index is after the commit.
+type LogLoan struct {
+	ID    CopyID       `json:"id"`
+	Index int          `json:"index"`
+	UUID  mtgjson.UUID `json:"uuid"`
+	Name  string       `json:"name"`
+	From  *Loan        `json:"from,omitempty"`
+	To    *Loan        `json:"to,omitempty"`
+}
+
+// logLocation formats l for the audit log, see LogPlace.
+func logLocation(l Location) string {
+	if l.Empty() {
+		return ""
+	}
+	return l.String()
+}
+
+// LogMove is a run of N cards that moved from index From to index To, e.g.:
+// because a card before them was removed.
+type LogMove struct {
+	From int `json:"from"`
+	To   int `json:"to"`
+	N    int `json:"n"`
+}
+
+func (e LogEntry) Empty() bool {
+	return len(e.Added) == 0 &&
+		len(e.Removed) == 0 &&
+		len(e.Tagged) == 0 &&
+		len(e.Remapped) == 0 &&
+		len(e.Owners) == 0 &&
+		len(e.Placed) == 0 &&
+		len(e.Loans) == 0
+}
+
+func (e LogEntry) String() string {
+	tags := 0
+	for _, t := range e.Tagged {
+		tags += len(t.Add) + len(t.Remove)
+	}
+	s := fmt.Sprintf(
+		"#%-4d %s %-10s +%d -%d ~%d tags",
+		e.ID,
+		e.Time.Local().Format("2006-01-02 15:04:05"),
+		e.User,
+		len(e.Added),
+		len(e.Removed),
+		tags,
+	)
+	if len(e.Remapped) != 0 {
+		s += fmt.Sprintf(" =%d remapped", len(e.Remapped))
+	}
+	if len(e.Owners) != 0 {
+		s += fmt.Sprintf(" ^%d new owners", len(e.Owners))
+	}
+	if len(e.Placed) != 0 {
+		s += fmt.Sprintf(" @%d placed", len(e.Placed))
+	}
+	if len(e.Loans) != 0 {
+		s += fmt.Sprintf(" &%d lent/returned", len(e.Loans))
+	}
+	return s
+}
+
+// Details returns the summary of e followed by its Changes.
+func (e LogEntry) Details() []string {
+	return append([]string{e.String()}, e.Changes()...)
+}
+
+// Changes returns a line for every change in e.
+func (e LogEntry) Changes() []string {
+	var l []string
+	card := func(c LogCard) string {
+		s := fmt.Sprintf("%6d %s %s %-5s %s", c.Index+1, c.ID, c.UUID, c.SetID, c.Name)
+		if c.Owner != "" {
+			s += " (" + c.Owner + ")"
+		}
+		if c.Location != "" {
+			s += " in " + c.Location
+		}
+		if len(c.Tags) != 0 {
+			s += " [" + strings.Join(c.Tags, ",") + "]"
+		}
+		return s
+	}
+	for _, c := range e.Added {
+		l = append(l, " + "+card(c))
+	}
+	for _, c := range e.Removed {
+		l = append(l, " - "+card(c))
+	}
+	for _, t := range e.Tagged {
+		tags := make([]string, 0, len(t.Add)+len(t.Remove))
+		for _, tag := range t.Add {
+			tags = append(tags, "+"+tag)
+		}
+		for _, tag := range t.Remove {
+			tags = append(tags, "-"+tag)
+		}
+		l = append(l, fmt.Sprintf(" ~ %6d %s %s %s %s", t.Index+1, t.ID, t.UUID, t.Name, strings.Join(tags, " ")))
+	}
+	for _, r := range e.Remapped {
+		l = append(l, fmt.Sprintf(" = %6d %s %s -> %s %-5s %s", r.Index+1, r.ID, r.From, r.To, r.SetID, r.Name))
+	}
+	for _, o := range e.Owners {
+		l = append(l, fmt.Sprintf(" ^ %6d %s %s %s '%s' -> '%s'", o.Index+1, o.ID, o.UUID, o.Name, o.From, o.To))
+	}
+	for _, p := range e.Placed {
+		from, to := p.From, p.To
+		if from == "" {
+			from = "-"
+		}
+		if to == "" {
+			to = "-"
+		}
+		l = append(l, fmt.Sprintf(" @ %6d %s %s %s %s -> %s", p.Index+1, p.ID, p.UUID, p.Name, from, to))
+	}
+	for _, n := range e.Loans {
+		l = append(l, fmt.Sprintf(" & %6d %s %s %s %s -> %s", n.Index+1, n.ID, n.UUID, n.Name, loanOf(n.From), loanOf(n.To)))
+	}
+	for _, m := range e.Moved {
+		l = append(l, fmt.Sprintf(" > %d cards moved from %d to %d", m.N, m.From+1, m.To+1))
+	}
+	return l
+}
+
+func auditFile(dbFile string) string { return dbFile + ".log" }
+
+func currentUser() string {
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return u.Username
+	}
+	if u := os.Getenv("USER"); u != "" {
+		return u
+	}
+	return os.Getenv("USERNAME")
+}
+
+// ReadLog returns all entries in the audit log, oldest first.
+func ReadLog(file string) ([]LogEntry, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var list []LogEntry
+	dec := json.NewDecoder(bufio.NewReader(f))
+	for dec.More() {
+		var e LogEntry
+		if err := dec.Decode(&e); err != nil {
+			return list, fmt.Errorf("invalid audit log '%s': %w", file, err)
+		}
+		list = append(list, e)
+	}
+	return list, nil
+}
+
+// AppendLog assigns the next id to e and appends it to the audit log.
+func AppendLog(file string, e LogEntry) (LogEntry, error) {
+	list, err := ReadLog(file)
+	if err != nil {
+		return e, err
+	}
+	e.ID = 1
+	if len(list) != 0 {
+		e.ID = list[len(list)-1].ID + 1
+	}
+
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return e, err
+	}
+	err = json.NewEncoder(f).Encode(e)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return e, err
+}
+
+// logSnapshot records the index, uuid, name, set, owner, location, loan and
+// tags of every card before a commit.
+type logSnapshot struct {
+	index map[*DBCard]int
+	cards map[*DBCard]LogCard
+	loans map[*DBCard]Loan
+	tags  map[*DBCard]Tags
+}
+
+func newLogSnapshot(db *DB) logSnapshot {
+	s := logSnapshot{
+		make(map[*DBCard]int),
+		make(map[*DBCard]LogCard),
+		make(map[*DBCard]Loan),
+		make(map[*DBCard]Tags),
+	}
+	for i, c := range db.Cards() {
+		s.index[c] = i
+		s.loans[c] = c.Loan()
+		s.cards[c] = LogCard{
+			UUID:     c.UUID(),
+			Name:     c.Name(),
+			SetID:    c.SetID(),
+			Owner:    c.Owner(),
+			Location: logLocation(c.Location()),
+		}
+		tags := make(Tags, len(c.tags))
+		tags.Add(c.Tags())
+		s.tags[c] = tags
+	}
+	return s
+}
+
+// entry compares the snapshot with the state of db after committing s.
+func (snap logSnapshot) entry(db *DB, s State) LogEntry {
+	e := LogEntry{Time: time.Now(), User: currentUser()}
+	cards := db.Cards()
+	var move *LogMove
+	for i, c := range cards {
+		old, ok := snap.index[c]
+		if !ok {
+			e.Added = append(e.Added, newLogCard(c, i, c.Tags()))
+			move = nil
+			continue
+		}
+		if old == i {
+			move = nil
+			continue
+		}
+		if move != nil && move.From+move.N == old && move.To+move.N == i {
+			move.N++
+			continue
+		}
+		e.Moved = append(e.Moved, LogMove{old, i, 1})
+		move = &e.Moved[len(e.Moved)-1]
+	}
+
+	removed := s.Delete
+	for _, sp := range s.Split {
+		removed = append(removed[:len(removed):len(removed)], sp.LocalCard)
+	}
+	for _, t := range s.Trading {
+		removed = append(removed[:len(removed):len(removed)], t.LocalCard)
+	}
+	for _, d := range removed {
+		if old, ok := snap.index[d.DBCard]; ok && db.IndexOf(d.DBCard) < 0 {
+			e.Removed = append(e.Removed, newLogCard(d.DBCard, old, snap.tags[d.DBCard].Slice()))
+		}
+	}
+
+	for _, r := range s.Remap {
+		before, ok := snap.cards[r.DBCard]
+		ix := db.IndexOf(r.DBCard)
+		if !ok || ix < 0 || (before.UUID == r.UUID() && before.Name == r.Name() && before.SetID == r.SetID()) {
+			continue
+		}
+		e.Remapped = append(e.Remapped, LogRemap{r.ID(), ix, before.UUID, r.UUID(), r.Name(), r.SetID()})
+	}
+
+	for _, o := range s.Ownership {
+		before, ok := snap.cards[o.DBCard]
+		ix := db.IndexOf(o.DBCard)
+		if !ok || ix < 0 || before.Owner == o.Owner() {
+			continue
+		}
+		e.Owners = append(e.Owners, LogOwner{o.ID(), ix, o.UUID(), o.Name(), before.Owner, o.Owner()})
+	}
+
+	placed := make(map[*DBCard]struct{})
+	for _, p := range s.Placement {
+		before, ok := snap.cards[p.DBCard]
+		ix := db.IndexOf(p.DBCard)
+		if _, dup := placed[p.DBCard]; !ok || dup || ix < 0 || before.Location == logLocation(p.Location()) {
+			continue
+		}
+		placed[p.DBCard] = struct{}{}
+		e.Placed = append(e.Placed, LogPlace{p.ID(), ix, p.UUID(), p.Name(), before.Location, logLocation(p.Location())})
+	}
+
+	lent := make(map[*DBCard]struct{})
+	for _, n := range s.Lending {
+		before, ok := snap.loans[n.DBCard]
+		ix := db.IndexOf(n.DBCard)
+		if _, dup := lent[n.DBCard]; !ok || dup || ix < 0 || before.Equal(n.DBCard.Loan()) {
+			continue
+		}
+		lent[n.DBCard] = struct{}{}
+		e.Loans = append(e.Loans, LogLoan{n.ID(), ix, n.UUID(), n.Name(), before.ref(), n.DBCard.Loan().ref()})
+	}
+
+	seen := make(map[*DBCard]struct{})
+	for _, t := range s.Tagging {
+		before, ok := snap.tags[t.DBCard]
+		ix := db.IndexOf(t.DBCard)
+		if _, dup := seen[t.DBCard]; !ok || dup || ix < 0 {
+			continue
+		}
+		seen[t.DBCard] = struct{}{}
+
+		lt := LogTagging{ID: t.ID(), Index: ix, UUID: t.UUID(), Name: t.Name()}
+		for _, tag := range t.Tags() {
+			if !before.Contains(tag) {
+				lt.Add = append(lt.Add, tag)
+			}
+		}
+		for _, tag := range before.Slice() {
+			if !t.HasTag(tag) {
+				lt.Remove = append(lt.Remove, tag)
+			}
+		}
+		if len(lt.Add) != 0 || len(lt.Remove) != 0 {
+			e.Tagged = append(e.Tagged, lt)
+		}
+	}
+
+	return e
+}
+
+// without returns e without the changes that were discarded because they
+// conflicted with another session.
+func (e LogEntry) without(r SyncReport) LogEntry {
+	if len(r.Conflicts) == 0 {
+		return e
+	}
+	n := LogEntry{ID: e.ID, Time: e.Time, User: e.User, Moved: e.Moved}
+	for _, c := range e.Added {
+		if !r.Conflicted(c.ID, conflictCard) {
+			n.Added = append(n.Added, c)
+		}
+	}
+	for _, c := range e.Removed {
+		if !r.Conflicted(c.ID, conflictCard) {
+			n.Removed = append(n.Removed, c)
+		}
+	}
+	for _, t := range e.Tagged {
+		if !r.Conflicted(t.ID, conflictCard) {
+			n.Tagged = append(n.Tagged, t)
+		}
+	}
+	for _, m := range e.Remapped {
+		if !r.Conflicted(m.ID, conflictRemap) {
+			n.Remapped = append(n.Remapped, m)
+		}
+	}
+	for _, o := range e.Owners {
+		if !r.Conflicted(o.ID, conflictOwner) {
+			n.Owners = append(n.Owners, o)
+		}
+	}
+	for _, p := range e.Placed {
+		if !r.Conflicted(p.ID, conflictLoc) {
+			n.Placed = append(n.Placed, p)
+		}
+	}
+	for _, l := range e.Loans {
+		if !r.Conflicted(l.ID, conflictLoan) {
+			n.Loans = append(n.Loans, l)
+		}
+	}
+	return n
+}
+
+// Forward returns the index after e of the card at index ix before e, ok is
+// false if e removed it.
+func (e LogEntry) Forward(ix int) (int, bool) {
+	for _, r := range e.Removed {
+		if r.Index == ix {
+			return ix, false
+		}
+	}
+	for _, m := range e.Moved {
+		if ix >= m.From && ix < m.From+m.N {
+			return m.To + ix - m.From, true
+		}
+	}
+	return ix, true
+}
+
+// Revert stages the inverse of e in s: added cards are deleted, removed
+// cards are added again and tag changes, remaps, new owners, placements and
+// loans are undone. Cards are looked up by their copy id, entries from before copy
+// ids existed by their index carried forward through the later commits or if
+// that fails (e.g.: the log is incomplete) the last copy with the same uuid.
+func (e LogEntry) Revert(app *App, s State, later []LogEntry) (State, int) {
+	skipped := 0
+	staged := make(map[*DBCard]struct{})
+	for _, d := range s.Delete {
+		staged[d.DBCard] = struct{}{}
+	}
+	find := func(id CopyID, ix int, uuid mtgjson.UUID) (*DBCard, int, bool) {
+		if id != "" {
+			c, ok := app.DB.ByID(id)
+			if _, del := staged[c]; !ok || del {
+				skipped++
+				return nil, 0, false
+			}
+			return c, app.DB.IndexOf(c), true
+		}
+
+		ok := true
+		for _, l := range later {
+			if ix, ok = l.Forward(ix); !ok {
+				break
+			}
+		}
+		if !ok {
+			skipped++
+			return nil, 0, false
+		}
+		if c, ok := app.DB.CardAt(ix); ok && c.UUID() == uuid {
+			if _, ok := staged[c]; !ok {
+				return c, ix, true
+			}
+		}
+		cards := app.DB.Cards()
+		for i := len(cards) - 1; i >= 0; i-- {
+			if _, ok := staged[cards[i]]; !ok && cards[i].UUID() == uuid {
+				return cards[i], i, true
+			}
+		}
+		skipped++
+		return nil, 0, false
+	}
+
+	for _, a := range e.Added {
+		c, ix, ok := find(a.ID, a.Index, a.UUID)
+		if !ok {
+			continue
+		}
+		staged[c] = struct{}{}
+		s.Delete = append(s.Delete, NewLocalCard(c, ix))
+	}
+
+	for _, r := range e.Removed {
+		c, ok := app.Cards.ByUUID(r.UUID)
+		if !ok {
+			skipped++
+			continue
+		}
+		sel := NewSelect(c)
+		sel.Tags.Add(r.Tags...)
+		// the same copy, unless its id was taken in the meantime.
+		sel.ID = r.ID
+		if r.Added != nil {
+			sel.Added = *r.Added
+		}
+		sel.Owner = r.Owner
+		if r.Location != "" {
+			sel.Location, _ = ParseLocation(r.Location)
+		}
+		s.Selection = append(s.Selection, sel)
+	}
+
+	for _, t := range e.Tagged {
+		c, _, ok := find(t.ID, t.Index, t.UUID)
+		if !ok {
+			continue
+		}
+		tagging := NewTagging(c)
+		for _, tag := range t.Add {
+			tagging.Add(false, tag)
+		}
+		for _, tag := range t.Remove {
+			tagging.Add(true, tag)
+		}
+		s.Tagging = append(s.Tagging, tagging)
+	}
+
+	for _, r := range e.Remapped {
+		c, _, ok := find(r.ID, r.Index, r.To)
+		if !ok {
+			continue
+		}
+		if _, ok := app.Cards.ByUUID(r.From); !ok {
+			skipped++
+			continue
+		}
+		s.Remap = append(s.Remap, Remap{c, r.From})
+	}
+
+	for _, o := range e.Owners {
+		c, ix, ok := find(o.ID, o.Index, o.UUID)
+		if !ok {
+			continue
+		}
+		s.Ownership = append(s.Ownership, Ownership{NewLocalCard(c, ix), o.From})
+	}
+
+	for _, p := range e.Placed {
+		c, ix, ok := find(p.ID, p.Index, p.UUID)
+		if !ok {
+			continue
+		}
+		var from Location
+		if p.From != "" {
+			from, _ = ParseLocation(p.From)
+		}
+		s.Placement = append(s.Placement, Placement{NewLocalCard(c, ix), from})
+	}
+
+	for _, l := range e.Loans {
+		c, ix, ok := find(l.ID, l.Index, l.UUID)
+		if !ok {
+			continue
+		}
+		s.Lending = append(s.Lending, Lending{NewLocalCard(c, ix), loanOf(l.From)})
+	}
+
+	return s, skipped
+}
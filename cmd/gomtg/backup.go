@@ -0,0 +1,276 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Backups are copies of the database file taken before each commit (the
+// last Keep), the first commit of each day (the last Daily) and week (the
+// last Weekly) and named snapshots which are never removed.
+type Backups struct {
+	Dir    string
+	Keep   int
+	Daily  int
+	Weekly int
+}
+
+const (
+	BackupCommit   = "commit"
+	BackupDaily    = "daily"
+	BackupWeekly   = "weekly"
+	BackupSnapshot = "snapshot"
+)
+
+var snapshotNameRE = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+func backupDir(dbFile string) string { return dbFile + ".backups" }
+
+// BackupFile is a single backup or snapshot.
+type BackupFile struct {
+	Name string
+	Kind string
+	File string
+	Time time.Time
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp := dst + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, dst)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// Commit backs up file before a commit and removes the oldest backups.
+func (b Backups) Commit(file string, now time.Time) error {
+	if b.Dir == "" {
+		return nil
+	}
+	if _, err := os.Stat(file); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if err := os.MkdirAll(b.Dir, 0700); err != nil {
+		return err
+	}
+
+	year, week := now.ISOWeek()
+	kinds := []struct {
+		kind, name string
+		keep       int
+		replace    bool
+	}{
+		{BackupCommit, now.Format("2006-01-02_15-04-05.000"), b.Keep, true},
+		{BackupDaily, now.Format("2006-01-02"), b.Daily, false},
+		{BackupWeekly, fmt.Sprintf("%d-W%02d", year, week), b.Weekly, false},
+	}
+	for _, k := range kinds {
+		if k.keep <= 0 {
+			continue
+		}
+		dst := filepath.Join(b.Dir, k.kind+"-"+k.name)
+		if _, err := os.Stat(dst); err == nil && !k.replace {
+			continue
+		}
+		if err := copyFile(file, dst); err != nil {
+			return fmt.Errorf("failed to back up database: %w", err)
+		}
+		if err := b.rotate(k.kind, k.keep); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b Backups) rotate(kind string, keep int) error {
+	list, err := b.List()
+	if err != nil {
+		return err
+	}
+	files := make([]string, 0, len(list))
+	for _, f := range list {
+		if f.Kind == kind {
+			files = append(files, f.File)
+		}
+	}
+	sort.Strings(files)
+	for i := 0; i < len(files)-keep; i++ {
+		if err := os.Remove(files[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Snapshot copies file to a snapshot with the given name.
+func (b Backups) Snapshot(file, name string) (BackupFile, error) {
+	if !snapshotNameRE.MatchString(name) {
+		return BackupFile{}, fmt.Errorf("invalid snapshot name '%s', only letters, digits, _, . and - are allowed", name)
+	}
+	f := BackupFile{
+		Name: BackupSnapshot + "-" + name,
+		Kind: BackupSnapshot,
+		File: filepath.Join(b.Dir, BackupSnapshot+"-"+name),
+		Time: time.Now(),
+	}
+	if _, err := os.Stat(f.File); err == nil {
+		return f, fmt.Errorf("snapshot '%s' already exists", name)
+	}
+	if _, err := os.Stat(file); err != nil {
+		return f, errors.New("nothing to snapshot, the database has not been saved yet")
+	}
+	if err := os.MkdirAll(b.Dir, 0700); err != nil {
+		return f, err
+	}
+	return f, copyFile(file, f.File)
+}
+
+// List returns all backups and snapshots, oldest first.
+func (b Backups) List() ([]BackupFile, error) {
+	entries, err := ioutil.ReadDir(b.Dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	list := make([]BackupFile, 0, len(entries))
+	for _, e := range entries {
+		p := strings.SplitN(e.Name(), "-", 2)
+		if e.IsDir() || len(p) != 2 || strings.HasSuffix(e.Name(), ".tmp") {
+			continue
+		}
+		switch p[0] {
+		case BackupCommit, BackupDaily, BackupWeekly, BackupSnapshot:
+		default:
+			continue
+		}
+		list = append(list, BackupFile{
+			Name: e.Name(),
+			Kind: p[0],
+			File: filepath.Join(b.Dir, e.Name()),
+			Time: e.ModTime(),
+		})
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		if list[i].Time.Equal(list[j].Time) {
+			return list[i].Name < list[j].Name
+		}
+		return list[i].Time.Before(list[j].Time)
+	})
+	return list, nil
+}
+
+// Find returns the backup with the given name, snapshots can be given
+// without their snapshot- prefix.
+func (b Backups) Find(name string) (BackupFile, error) {
+	list, err := b.List()
+	if err != nil {
+		return BackupFile{}, err
+	}
+	for _, f := range list {
+		if f.Name == name || (f.Kind == BackupSnapshot && f.Name == BackupSnapshot+"-"+name) {
+			return f, nil
+		}
+	}
+	return BackupFile{}, fmt.Errorf("no such backup or snapshot '%s', see /restore", name)
+}
+
+// ReadDBFile loads the database in file without modifying it (e.g.: by
+// upgrading its schema).
+func ReadDBFile(file string) (*DB, error) {
+	if _, err := os.Stat(file); err != nil {
+		return nil, err
+	}
+	dir, err := ioutil.TempDir("", "gomtg-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	tmp := filepath.Join(dir, "db")
+	if err := copyFile(file, tmp); err != nil {
+		return nil, err
+	}
+	store, err := OpenStorage(tmp, FormatJSON)
+	if err != nil {
+		return nil, err
+	}
+	defer store.Close()
+	return LoadDB(store)
+}
+
+// DiffDB returns the changes that turn from into to as a log entry without
+// id, time or user. Cards are matched by copy id, added and tagged cards have
+// their index in to, removed cards in from.
+func DiffDB(from, to *DB) LogEntry {
+	var e LogEntry
+	tagDiff := func(a, b *DBCard) []string {
+		var d []string
+		for _, t := range a.Tags() {
+			if !b.HasTag(t) {
+				d = append(d, t)
+			}
+		}
+		return d
+	}
+
+	for i, c := range to.Cards() {
+		o, ok := from.ByID(c.ID())
+		if !ok {
+			e.Added = append(e.Added, newLogCard(c, i, c.Tags()))
+			continue
+		}
+		add, del := tagDiff(c, o), tagDiff(o, c)
+		if len(add) != 0 || len(del) != 0 {
+			e.Tagged = append(e.Tagged, LogTagging{c.ID(), i, c.UUID(), c.Name(), add, del})
+		}
+		if o.UUID() != c.UUID() || o.Name() != c.Name() || o.SetID() != c.SetID() {
+			e.Remapped = append(e.Remapped, LogRemap{c.ID(), i, o.UUID(), c.UUID(), c.Name(), c.SetID()})
+		}
+		if o.Owner() != c.Owner() {
+			e.Owners = append(e.Owners, LogOwner{c.ID(), i, c.UUID(), c.Name(), o.Owner(), c.Owner()})
+		}
+		if o.Location() != c.Location() {
+			e.Placed = append(e.Placed, LogPlace{c.ID(), i, c.UUID(), c.Name(), logLocation(o.Location()), logLocation(c.Location())})
+		}
+		if !o.Loan().Equal(c.Loan()) {
+			e.Loans = append(e.Loans, LogLoan{c.ID(), i, c.UUID(), c.Name(), o.Loan().ref(), c.Loan().ref()})
+		}
+	}
+	for i, c := range from.Cards() {
+		if _, ok := to.ByID(c.ID()); !ok {
+			e.Removed = append(e.Removed, newLogCard(c, i, c.Tags()))
+		}
+	}
+	return e
+}
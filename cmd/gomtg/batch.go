@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/frizinak/gomtg/mtgjson"
+)
+
+type Output string
+
+const (
+	OutputText Output = "text"
+	OutputJSON Output = "json"
+)
+
+func (o Output) Valid() bool {
+	return o == OutputText || o == OutputJSON
+}
+
+// batchView records what a command wants shown besides its messages.
+type batchView struct {
+	Options bool
+	Queue   bool
+	Cards   []Card
+}
+
+type ResultCard struct {
+	ID        CopyID        `json:"id,omitempty"`
+	Added     *time.Time    `json:"added,omitempty"`
+	Index     int           `json:"index,omitempty"`
+	UUID      mtgjson.UUID  `json:"uuid"`
+	Name      string        `json:"name"`
+	SetID     mtgjson.SetID `json:"set_id"`
+	Count     int           `json:"count"`
+	Available int           `json:"available"`
+	Price     float64       `json:"price"`
+	PriceOK   bool          `json:"price_ok"`
+	Currency  string        `json:"currency"`
+	Foil      bool          `json:"foil,omitempty"`
+	Owner     string        `json:"owner,omitempty"`
+	Location  string        `json:"location,omitempty"`
+	LentTo    string        `json:"lent_to,omitempty"`
+	Tags      []string      `json:"tags,omitempty"`
+}
+
+type ResultTagging struct {
+	ID     CopyID       `json:"id"`
+	UUID   mtgjson.UUID `json:"uuid"`
+	Name   string       `json:"name"`
+	Add    []string     `json:"add,omitempty"`
+	Remove []string     `json:"remove,omitempty"`
+}
+
+type ResultQueue struct {
+	Add    []ResultCard    `json:"add"`
+	Delete []ResultCard    `json:"delete"`
+	Tags   []ResultTagging `json:"tags"`
+}
+
+type Result struct {
+	Input  string       `json:"input"`
+	Mode   Mode         `json:"mode"`
+	Output []string     `json:"output,omitempty"`
+	Errors []string     `json:"errors,omitempty"`
+	Cards  []ResultCard `json:"cards,omitempty"`
+	Queue  *ResultQueue `json:"queue,omitempty"`
+}
+
+func (a *App) ResultCards(cards []Card) []ResultCard {
+	l := make([]ResultCard, len(cards))
+	for i, c := range cards {
+		price, ok := a.GetPricing(c.UUID, false, false)
+		l[i] = ResultCard{
+			UUID:      c.UUID,
+			Name:      c.Name,
+			SetID:     c.SetCode,
+			Count:     a.DB.Count(c.UUID),
+			Available: a.DB.Available(c.UUID),
+			Price:     price,
+			PriceOK:   ok,
+			Currency:  a.pricing.currency,
+		}
+	}
+	return l
+}
+
+func (a *App) ResultLocalCards(cards []LocalCard) []ResultCard {
+	l := make([]ResultCard, len(cards))
+	for i, c := range cards {
+		price, ok := a.GetPricing(c.UUID(), c.Foil(), false)
+		var added *time.Time
+		if t := c.Added(); !t.IsZero() {
+			added = &t
+		}
+		l[i] = ResultCard{
+			ID:        c.ID(),
+			Added:     added,
+			Index:     c.Index + 1,
+			UUID:      c.UUID(),
+			Name:      c.Name(),
+			SetID:     c.SetID(),
+			Count:     a.DB.Count(c.UUID()),
+			Available: a.DB.Available(c.UUID()),
+			Price:     price,
+			PriceOK:   ok,
+			Currency:  a.pricing.currency,
+			Foil:      c.Foil(),
+			Owner:     c.Owner(),
+			LentTo:    c.Loan().To,
+			Tags:      c.Tags(),
+		}
+		if loc := c.Location(); !loc.Empty() {
+			l[i].Location = loc.String()
+		}
+	}
+	return l
+}
+
+func (a *App) ResultQueue(s State) *ResultQueue {
+	q := &ResultQueue{
+		Add:    a.ResultCards(s.Selection.Cards()),
+		Delete: a.ResultLocalCards(s.Delete),
+		Tags:   make([]ResultTagging, 0, len(s.Tagging)),
+	}
+	for i, sel := range s.Selection {
+		q.Add[i].Tags = sel.Tags.Slice()
+	}
+	for _, t := range s.Tagging {
+		add, rem := t.NewTags()
+		q.Tags = append(q.Tags, ResultTagging{t.ID(), t.UUID(), t.Name(), add, rem})
+	}
+	return q
+}
+
+// readBatch reads commands from file, or stdin if file is empty or -.
+func readBatch(file string) ([]string, error) {
+	var r io.Reader = os.Stdin
+	if file != "" && file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	lines := make([]string, 0)
+	scan := bufio.NewScanner(r)
+	for scan.Scan() {
+		lines = append(lines, strings.TrimRight(scan.Text(), "\r"))
+	}
+
+	return lines, scan.Err()
+}
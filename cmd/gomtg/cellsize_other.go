@@ -0,0 +1,26 @@
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// cellSize returns the size in pixels of a single terminal cell.
+func cellSize(f *os.File) (int, int) {
+	var ws struct {
+		Row, Col, X, Y uint16
+	}
+	_, _, errno := syscall.Syscall(
+		syscall.SYS_IOCTL,
+		f.Fd(),
+		uintptr(syscall.TIOCGWINSZ),
+		uintptr(unsafe.Pointer(&ws)),
+	)
+	if errno != 0 || ws.Row == 0 || ws.Col == 0 || ws.X == 0 || ws.Y == 0 {
+		return 10, 20
+	}
+	return int(ws.X / ws.Col), int(ws.Y / ws.Row)
+}
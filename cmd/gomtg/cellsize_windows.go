@@ -0,0 +1,10 @@
+// +build windows
+
+package main
+
+import "os"
+
+// cellSize returns the size in pixels of a single terminal cell.
+func cellSize(f *os.File) (int, int) {
+	return 10, 20
+}
@@ -2,17 +2,178 @@ package main
 
 import (
 	"fmt"
+	"os"
 	"sort"
 	"strconv"
 	"strings"
 )
 
-type Colors map[string][3]int
+// Color is a terminal color. Either empty or 0 for the default color,
+// 1-8 or a name for the 8 basic colors (black, red, green, yellow, blue,
+// magenta, cyan, white), @0-@255 for the 256 color palette or #rrggbb.
+type Color string
+
+var colorNames = map[string]int{
+	"black":   1,
+	"red":     2,
+	"green":   3,
+	"yellow":  4,
+	"blue":    5,
+	"magenta": 6,
+	"cyan":    7,
+	"white":   8,
+}
+
+// sgr returns the SGR parameters that select c as foreground or background.
+func (c Color) sgr(bg bool) (string, error) {
+	s := string(c)
+	base, ext := 29, "38"
+	if bg {
+		base, ext = 39, "48"
+	}
+
+	switch {
+	case s == "" || s == "0":
+		return "", nil
+	case strings.HasPrefix(s, "@"):
+		n, err := strconv.Atoi(s[1:])
+		if err != nil || n < 0 || n > 255 {
+			return "", fmt.Errorf("'%s' is not a valid 256 color palette index", s)
+		}
+		return fmt.Sprintf("%s;5;%d", ext, n), nil
+	case strings.HasPrefix(s, "#"):
+		v, err := strconv.ParseUint(s[1:], 16, 32)
+		if err != nil || len(s) != 7 {
+			return "", fmt.Errorf("'%s' is not a valid #rrggbb color", s)
+		}
+		return fmt.Sprintf("%s;2;%d;%d;%d", ext, v>>16, (v>>8)&0xff, v&0xff), nil
+	}
+
+	n, ok := colorNames[s]
+	if !ok {
+		var err error
+		n, err = strconv.Atoi(s)
+		if err != nil || n < 1 || n > 8 {
+			return "", fmt.Errorf("'%s' is not a valid color", s)
+		}
+	}
+	return strconv.Itoa(base + n), nil
+}
+
+// Style is the background, foreground and weight of a piece of text.
+type Style struct {
+	BG   Color
+	FG   Color
+	Bold bool
+}
+
+func (s Style) String() string {
+	p := make([]string, 0, 3)
+	if bg, _ := s.BG.sgr(true); bg != "" {
+		p = append(p, bg)
+	}
+	if fg, _ := s.FG.sgr(false); fg != "" {
+		p = append(p, fg)
+	}
+	if s.Bold {
+		p = append(p, "1")
+	}
+	if len(p) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("\033[%sm", strings.Join(p, ";"))
+}
+
+type Colors map[string]Style
+
+// Themes are the builtin color sets selectable with -theme, -c overrides
+// individual keys.
+// Keys:
+//   - bad, good, high, low, status: messages, uuids and the status bar
+//   - set, tags: table columns
+//   - rarity-<common|uncommon|rare|mythic|special|bonus>: card names
+//   - mana-<w|u|b|r|g|c>: mana symbols, c for generic and colorless mana
+var Themes = map[string]Colors{
+	"default": {
+		"bad":    {FG: "red", Bold: true},
+		"good":   {BG: "black", FG: "green", Bold: true},
+		"high":   {BG: "white", FG: "black", Bold: true},
+		"low":    {FG: "black"},
+		"status": {BG: "green", FG: "black"},
+
+		"set":  {FG: "cyan"},
+		"tags": {FG: "magenta"},
+
+		"rarity-rare":    {FG: "yellow"},
+		"rarity-mythic":  {FG: "red"},
+		"rarity-special": {FG: "magenta"},
+
+		"mana-w": {FG: "white", Bold: true},
+		"mana-u": {FG: "blue", Bold: true},
+		"mana-b": {Bold: true},
+		"mana-r": {FG: "red", Bold: true},
+		"mana-g": {FG: "green", Bold: true},
+	},
+	"256": {
+		"bad":    {FG: "@196", Bold: true},
+		"good":   {BG: "@235", FG: "@113", Bold: true},
+		"high":   {BG: "@254", FG: "@232", Bold: true},
+		"low":    {FG: "@242"},
+		"status": {BG: "@29", FG: "@232"},
+
+		"set":  {FG: "@73"},
+		"tags": {FG: "@176"},
+
+		"rarity-uncommon": {FG: "@249"},
+		"rarity-rare":     {FG: "@178"},
+		"rarity-mythic":   {FG: "@202"},
+		"rarity-special":  {FG: "@135"},
+
+		"mana-w": {FG: "@230", Bold: true},
+		"mana-u": {FG: "@39", Bold: true},
+		"mana-b": {FG: "@139", Bold: true},
+		"mana-r": {FG: "@203", Bold: true},
+		"mana-g": {FG: "@71", Bold: true},
+		"mana-c": {FG: "@250"},
+	},
+	"truecolor": {
+		"bad":    {FG: "#e06c75", Bold: true},
+		"good":   {BG: "#282c34", FG: "#98c379", Bold: true},
+		"high":   {BG: "#e5e5e5", FG: "#1e1e1e", Bold: true},
+		"low":    {FG: "#6b7280"},
+		"status": {BG: "#3f7f5f", FG: "#101010"},
+
+		"set":  {FG: "#56b6c2"},
+		"tags": {FG: "#c678dd"},
+
+		"rarity-uncommon": {FG: "#a8b8c8"},
+		"rarity-rare":     {FG: "#d4af37"},
+		"rarity-mythic":   {FG: "#e8632b"},
+		"rarity-special":  {FG: "#a06cd5"},
+
+		"mana-w": {FG: "#f8e7b9", Bold: true},
+		"mana-u": {FG: "#3c9ee6", Bold: true},
+		"mana-b": {FG: "#a69f9d", Bold: true},
+		"mana-r": {FG: "#f3654d", Bold: true},
+		"mana-g": {FG: "#3fa96b", Bold: true},
+		"mana-c": {FG: "#c5c5c5"},
+	},
+}
 
 func (c Colors) Encode() string {
 	kv := make([]string, 0, len(c))
-	for i := range c {
-		kv = append(kv, fmt.Sprintf("%s:%d:%d:%d", i, c[i][0], c[i][1], c[i][2]))
+	for i, s := range c {
+		bg, fg, bold := s.BG, s.FG, 0
+		if bg == "" {
+			bg = "0"
+		}
+		if fg == "" {
+			fg = "0"
+		}
+		if s.Bold {
+			bold = 1
+		}
+		kv = append(kv, fmt.Sprintf("%s:%s:%s:%d", i, bg, fg, bold))
 	}
 	sort.Strings(kv)
 	return strings.Join(kv, ",")
@@ -29,22 +190,66 @@ func (c Colors) Merge(nc Colors) Colors {
 }
 
 func (c Colors) Get(n string) string {
-	clrs := c[n]
-	s := make([]string, 0, 2)
-	if clrs[0] != 0 {
-		s = append(s, strconv.Itoa(clrs[0]+39))
+	return c[n].String()
+}
+
+// Wrap styles s with the color n.
+func (c Colors) Wrap(n, s string) string {
+	clr := c.Get(n)
+	if clr == "" || s == "" {
+		return s
 	}
-	if clrs[1] != 0 {
-		s = append(s, strconv.Itoa(clrs[1]+29))
+	return clr + s + "\033[0m"
+}
+
+// Mana styles each symbol of a mana cost (e.g.: {2}{U}{U/B}) in the color
+// of the first color it contains.
+func (c Colors) Mana(cost string) string {
+	var b strings.Builder
+	for len(cost) != 0 {
+		end := strings.IndexByte(cost, '}')
+		if cost[0] != '{' || end < 0 {
+			b.WriteString(cost)
+			break
+		}
+		sym := cost[:end+1]
+		cost = cost[end+1:]
+
+		key := "mana-c"
+		if i := strings.IndexAny(sym, "WUBRG"); i >= 0 {
+			key = "mana-" + strings.ToLower(sym[i:i+1])
+		}
+		b.WriteString(c.Wrap(key, sym))
 	}
-	if clrs[2] == 1 {
-		s = append(s, "1")
+	return b.String()
+}
+
+// ColorMode controls when colors are used.
+type ColorMode string
+
+const (
+	ColorAuto   ColorMode = "auto"
+	ColorAlways ColorMode = "always"
+	ColorNever  ColorMode = "never"
+)
+
+func (m ColorMode) Valid() bool {
+	return m == ColorAuto || m == ColorAlways || m == ColorNever
+}
+
+// Enabled reports whether colors should be used, in auto mode they are
+// disabled if NO_COLOR is set or output is not a terminal.
+func (m ColorMode) Enabled(tty bool) bool {
+	switch m {
+	case ColorAlways:
+		return true
+	case ColorNever:
+		return false
 	}
-	n = strings.Join(s, ";")
-	if n == "" {
-		return ""
+	if os.Getenv("NO_COLOR") != "" {
+		return false
 	}
-	return fmt.Sprintf("\033[%sm", n)
+	return tty
 }
 
 func DecodeColors(s string) (Colors, error) {
@@ -52,6 +257,9 @@ func DecodeColors(s string) (Colors, error) {
 
 	items := strings.Split(s, ",")
 	for _, item := range items {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
 		p := strings.SplitN(item, ":", 4)
 		if len(p) < 2 {
 			return c, fmt.Errorf("'%s' is not a valid key:value", item)
@@ -60,15 +268,29 @@ func DecodeColors(s string) (Colors, error) {
 			p[i] = strings.TrimSpace(p[i])
 		}
 
-		var list [3]int
+		var style Style
 		for i, v := range p[1:] {
-			n, err := strconv.Atoi(v)
-			if err != nil {
-				return c, fmt.Errorf("'%s' contains an invalid integer", item)
+			if i == 2 {
+				switch v {
+				case "0", "":
+				case "1", "bold":
+					style.Bold = true
+				default:
+					return c, fmt.Errorf("'%s' has an invalid bold value", item)
+				}
+				continue
+			}
+			clr := Color(strings.ToLower(v))
+			if _, err := clr.sgr(i == 0); err != nil {
+				return c, fmt.Errorf("'%s': %w", item, err)
+			}
+			if i == 0 {
+				style.BG = clr
+				continue
 			}
-			list[i] = n
+			style.FG = clr
 		}
-		c[p[0]] = list
+		c[p[0]] = style
 	}
 
 	return c, nil
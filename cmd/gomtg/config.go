@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ConfigSection holds flag values, command aliases and macros.
+// Aliases map a name to a command (and arguments), e.g.: "n": "/images next".
+// Macros map a name to lines that are run as if they were typed.
+type ConfigSection struct {
+	Flags   map[string]interface{} `json:"flags"`
+	Aliases map[string]string      `json:"aliases"`
+	Macros  map[string][]string    `json:"macros"`
+}
+
+// Config is the top level section of the config file, a profile selected
+// with -profile is merged on top of it.
+type Config struct {
+	ConfigSection
+	Profiles map[string]ConfigSection `json:"profiles"`
+}
+
+// LoadConfig reads the json config in file. A missing file is only an error
+// if required is true.
+func LoadConfig(file string, required bool) (Config, error) {
+	var c Config
+	f, err := os.Open(file)
+	if err != nil {
+		if !required && errors.Is(err, os.ErrNotExist) {
+			return c, nil
+		}
+		return c, err
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&c); err != nil {
+		return c, fmt.Errorf("invalid config '%s': %w", file, err)
+	}
+	return c, nil
+}
+
+// Profile returns the top level section with the given profile merged in.
+func (c Config) Profile(name string) (ConfigSection, error) {
+	s := ConfigSection{
+		Flags:   make(map[string]interface{}, len(c.Flags)),
+		Aliases: make(map[string]string, len(c.Aliases)),
+		Macros:  make(map[string][]string, len(c.Macros)),
+	}
+	merge := func(n ConfigSection) {
+		for k, v := range n.Flags {
+			s.Flags[k] = v
+		}
+		for k, v := range n.Aliases {
+			s.Aliases[k] = v
+		}
+		for k, v := range n.Macros {
+			s.Macros[k] = v
+		}
+	}
+
+	merge(c.ConfigSection)
+	if name == "" {
+		return s, nil
+	}
+	p, ok := c.Profiles[name]
+	if !ok {
+		names := make([]string, 0, len(c.Profiles))
+		for n := range c.Profiles {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return s, fmt.Errorf("no such profile '%s', available: %s", name, strings.Join(names, ", "))
+	}
+	merge(p)
+	return s, nil
+}
+
+// ApplyFlags sets the flags in s on fs, except those in skip (i.e.: the ones
+// given on the command line).
+func (s ConfigSection) ApplyFlags(fs *flag.FlagSet, skip map[string]struct{}) error {
+	names := make([]string, 0, len(s.Flags))
+	for name := range s.Flags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if _, ok := skip[name]; ok {
+			continue
+		}
+		if fs.Lookup(name) == nil {
+			return fmt.Errorf("config: no such flag '%s'", name)
+		}
+
+		var value string
+		switch v := s.Flags[name].(type) {
+		case string:
+			value = v
+		case bool:
+			value = strconv.FormatBool(v)
+		case float64:
+			value = strconv.FormatFloat(v, 'f', -1, 64)
+		default:
+			return fmt.Errorf("config: invalid value for flag '%s'", name)
+		}
+		if err := fs.Set(name, value); err != nil {
+			return fmt.Errorf("config: flag '%s': %w", name, err)
+		}
+	}
+	return nil
+}
+
+// commandLine returns the fields of an alias, it has to start with a
+// /command.
+func commandLine(alias string) []string {
+	return strings.Fields(alias)
+}
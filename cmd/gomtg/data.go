@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 
 	"github.com/frizinak/gomtg/mtgjson"
 )
@@ -14,19 +15,44 @@ func init() {
 	gob.Register(All{})
 }
 
+// dataVersion is bumped when Card or All change in a way that requires
+// all.gob to be regenerated.
+const dataVersion = 3
+
 type Card struct {
 	UUID          mtgjson.UUID
 	Identifiers   mtgjson.ID
 	Name          string
 	SetCode       mtgjson.SetID
+	Number        string
 	Availability  mtgjson.Availability
 	ColorIdentity mtgjson.Colors
 	ManaCost      string
 	Keywords      mtgjson.Keywords
 	Types         []string
+	Rarity        mtgjson.Rarity
+	Layout        mtgjson.Layout
+	Side          string
+	FaceName      string
+	OtherFaceIDs  []mtgjson.UUID
 	dir           string
 }
 
+// DoubleFaced reports whether the faces of c are printed on both sides of
+// the physical card (as opposed to e.g. split, flip or adventure cards).
+func (c Card) DoubleFaced() bool {
+	switch c.Layout {
+	case "transform", "modal_dfc", "double_faced_token", "reversible_card", "art_series":
+		return true
+	}
+	return false
+}
+
+// Front reports whether c is the first (or only) face of a physical card.
+func (c Card) Front() bool {
+	return c.Side == "" || c.Side == "a"
+}
+
 func (c Card) Full() (mtgjson.Card, error) {
 	return mtgjson.ReadCardGOB(c.dir, c.UUID)
 }
@@ -68,11 +94,27 @@ func (c Card) ImageURL() (string, error) {
 	return c.ImageURLGatherer()
 }
 
+// ImageURLs returns the image of each side of the physical card in the
+// given scryfall size (small, normal or large).
+func (c Card) ImageURLs(size string) ([]string, error) {
+	front, err := c.ImageURLScryfall(false, size)
+	if err != nil {
+		u, err := c.ImageURLGatherer()
+		return []string{u}, err
+	}
+	if c.DoubleFaced() {
+		back, err := c.ImageURLScryfall(true, size)
+		return []string{front, back}, err
+	}
+	return []string{front}, nil
+}
+
 type Sets map[mtgjson.SetID]string
 
 type All struct {
-	Cards []Card
-	Sets  Sets
+	Version int
+	Cards   []Card
+	Sets    Sets
 
 	uuid map[mtgjson.UUID]int
 }
@@ -92,7 +134,35 @@ func (a *All) ByUUID(uuid mtgjson.UUID) (Card, bool) {
 	return a.Cards[v], true
 }
 
-func loadData(dir string, refresh bool) (*All, error) {
+// FrontFace returns the first face of the physical card c belongs to.
+func (a *All) FrontFace(c Card) Card {
+	if c.Front() {
+		return c
+	}
+	for _, uuid := range c.OtherFaceIDs {
+		if f, ok := a.ByUUID(uuid); ok && f.Front() {
+			return f
+		}
+	}
+	return c
+}
+
+// Faces returns all faces of the physical card c belongs to, ordered by side.
+func (a *All) Faces(c Card) []Card {
+	c = a.FrontFace(c)
+	faces := []Card{c}
+	for _, uuid := range c.OtherFaceIDs {
+		if f, ok := a.ByUUID(uuid); ok {
+			faces = append(faces, f)
+		}
+	}
+	sort.SliceStable(faces, func(i, j int) bool { return faces[i].Side < faces[j].Side })
+	return faces
+}
+
+// loadData loads the card data in dir, downloading it if refresh is true,
+// it does not exist or is outdated (unless offline is true).
+func loadData(dir string, refresh, offline bool) (*All, error) {
 	file := filepath.Join(dir, "all.gob")
 	cardDir := filepath.Join(dir, "cards")
 	if !refresh {
@@ -101,6 +171,9 @@ func loadData(dir string, refresh bool) (*All, error) {
 			refresh = true
 		}
 	}
+	if refresh && offline {
+		return nil, errors.New("no card data available in offline mode")
+	}
 	if refresh {
 		_ = os.MkdirAll(cardDir, 0700)
 		destJSON := file + ".json"
@@ -121,7 +194,7 @@ func loadData(dir string, refresh bool) (*All, error) {
 			return nil, err
 		}
 
-		all := &All{Cards: make([]Card, 0), Sets: make(Sets)}
+		all := &All{Version: dataVersion, Cards: make([]Card, 0), Sets: make(Sets)}
 		var allCards []mtgjson.Card
 		err = progress("Prepare data", func() error {
 			in, err := os.Open(destJSON)
@@ -148,11 +221,17 @@ func loadData(dir string, refresh bool) (*All, error) {
 						Identifiers:   c.Identifiers,
 						Name:          c.Name,
 						SetCode:       c.SetCode,
+						Number:        c.Number,
 						Availability:  c.Availability,
 						ColorIdentity: c.ColorIdentity,
 						ManaCost:      c.ManaCost,
 						Keywords:      c.Keywords,
 						Types:         c.Types,
+						Rarity:        c.Rarity,
+						Layout:        c.Layout,
+						Side:          c.Side,
+						FaceName:      c.FaceName,
+						OtherFaceIDs:  c.OtherFaceIds,
 					},
 				)
 			}
@@ -208,6 +287,10 @@ func loadData(dir string, refresh bool) (*All, error) {
 		return nil, err
 	}
 
+	if all.Version != dataVersion && !refresh && !offline {
+		return loadData(dir, true, offline)
+	}
+
 	for i := range all.Cards {
 		all.Cards[i].dir = cardDir
 	}
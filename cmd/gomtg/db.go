@@ -1,8 +1,8 @@
 package main
 
 import (
-	"encoding/json"
-	"os"
+	"crypto/rand"
+	"encoding/hex"
 	"sort"
 	"time"
 
@@ -48,11 +48,29 @@ func (t Tags) Contains(tag string) bool {
 	return ok
 }
 
+// CopyID uniquely identifies a single copy of a card in the database, unlike
+// its index it does not change when other cards are added or deleted.
+type CopyID string
+
+func newCopyID() CopyID {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return CopyID(hex.EncodeToString(b))
+}
+
 type DBCard struct {
 	db      *DB
+	id      CopyID
+	added   time.Time
 	name    string
 	uuid    mtgjson.UUID
 	setID   mtgjson.SetID
+	number  string
+	owner   string
+	loc     Location
+	loan    Loan
 	tags    Tags
 	del     bool
 	pricing Pricing
@@ -67,34 +85,70 @@ type Pricing struct {
 }
 
 type jsonCard struct {
-	Name    string        `json:"name"`
-	UUID    mtgjson.UUID  `json:"uuid"`
-	SetID   mtgjson.SetID `json:"set_id"`
-	Tags    []string      `json:"tags"`
-	Pricing Pricing       `json:"price"`
+	ID        CopyID        `json:"id"`
+	Added     time.Time     `json:"added"`
+	Name      string        `json:"name"`
+	UUID      mtgjson.UUID  `json:"uuid"`
+	SetID     mtgjson.SetID `json:"set_id"`
+	Number    string        `json:"number,omitempty"`
+	Owner     string        `json:"owner,omitempty"`
+	Container string        `json:"container,omitempty"`
+	Slot      int           `json:"slot,omitempty"`
+	Loan      *Loan         `json:"loan,omitempty"`
+	Tags      []string      `json:"tags"`
+	Pricing   Pricing       `json:"price"`
 }
 
+func (c *DBCard) ID() CopyID           { return c.id }
+func (c *DBCard) Added() time.Time     { return c.added }
 func (c *DBCard) Name() string         { return c.name }
 func (c *DBCard) UUID() mtgjson.UUID   { return c.uuid }
 func (c *DBCard) SetID() mtgjson.SetID { return c.setID }
+func (c *DBCard) Number() string       { return c.number }
+func (c *DBCard) Owner() string        { return c.owner }
+func (c *DBCard) Location() Location   { return c.loc }
+func (c *DBCard) Loan() Loan           { return c.loan }
 func (c *DBCard) Tags() []string       { return c.tags.Slice() }
 func (c *DBCard) HasTag(t string) bool { return c.tags.Contains(t) }
 func (c *DBCard) Foil() bool           { return c.HasTag("foil") }
 
 func (c *DBCard) Tag(tags []string) {
-	changed := c.tags.Add(tags)
-	c.db.save = c.db.save || changed
+	if c.tags.Add(tags) {
+		c.db.touch(c)
+	}
 }
 
 func (c *DBCard) Untag(tags []string) {
-	changed := c.tags.Del(tags)
-	c.db.save = c.db.save || changed
+	if c.tags.Del(tags) {
+		c.db.touch(c)
+	}
+}
+
+func (c *DBCard) SetOwner(owner string) {
+	if c.owner != owner {
+		c.owner = owner
+		c.db.touch(c)
+	}
+}
+
+func (c *DBCard) SetLocation(l Location) {
+	if c.loc != l {
+		c.loc = l
+		c.db.touch(c)
+	}
+}
+
+func (c *DBCard) SetLoan(l Loan) {
+	if !c.loan.Equal(l) {
+		c.loan = l
+		c.db.touch(c)
+	}
 }
 
 func (c *DBCard) SetPricing(p Pricing) {
 	if c.pricing != p {
 		c.pricing = p
-		c.db.save = true
+		c.db.touch(c)
 	}
 }
 
@@ -102,48 +156,178 @@ func (c *DBCard) Pricing() Pricing { return c.pricing }
 
 func FromCard(db *DB, c Card) *DBCard {
 	return &DBCard{
-		db:    db,
-		uuid:  c.UUID,
-		setID: c.SetCode,
-		name:  c.Name,
-		tags:  make(Tags),
+		db:     db,
+		uuid:   c.UUID,
+		setID:  c.SetCode,
+		number: c.Number,
+		name:   c.Name,
+		tags:   make(Tags),
 	}
 }
 
+func (c *DBCard) json() jsonCard {
+	return jsonCard{
+		c.id,
+		c.added,
+		c.name,
+		c.uuid,
+		c.setID,
+		c.number,
+		c.owner,
+		c.loc.Container,
+		c.loc.Slot,
+		c.loan.ref(),
+		c.Tags(),
+		c.pricing,
+	}
+}
+
+func fromJSON(db *DB, jc jsonCard) *DBCard {
+	c := &DBCard{db: db}
+	c.setJSON(jc)
+	return c
+}
+
+func (c *DBCard) setJSON(jc jsonCard) {
+	c.id, c.added, c.pricing = jc.ID, jc.Added, jc.Pricing
+	c.name, c.uuid, c.setID, c.number, c.owner = jc.Name, jc.UUID, jc.SetID, jc.Number, jc.Owner
+	c.loc = Location{jc.Container, jc.Slot}
+	c.loan = loanOf(jc.Loan)
+	c.tags = make(Tags, len(jc.Tags))
+	c.tags.Add(jc.Tags)
+}
+
 type DB struct {
-	data   []*DBCard
-	byUUID map[mtgjson.UUID][]int
-	save   bool
+	store   Storage
+	data    []*DBCard
+	byUUID  map[mtgjson.UUID][]int
+	byID    map[CopyID]*DBCard
+	dirty   map[*DBCard]struct{}
+	deleted []CopyID
+
+	containers      []Container
+	containersDirty bool
+
+	// base is every card and container as it was last loaded or saved, see
+	// merge.
+	base           map[CopyID]jsonCard
+	baseContainers []Container
+	synced         SyncReport
 }
 
+func (db *DB) touch(c *DBCard) { db.dirty[c] = struct{}{} }
+
+// Add appends c to the database and assigns it a copy id and the current
+// time as added-at if it has none.
 func (db *DB) Add(c *DBCard) {
+	if c.added.IsZero() {
+		c.added = time.Now()
+	}
+	db.add(c)
+}
+
+func (db *DB) add(c *DBCard) {
+	if _, ok := db.byID[c.id]; ok || c.id == "" {
+		c.id = newCopyID()
+		for _, ok := db.byID[c.id]; ok; _, ok = db.byID[c.id] {
+			c.id = newCopyID()
+		}
+	}
+	db.byID[c.id] = c
 	db.data = append(db.data, c)
 	if _, ok := db.byUUID[c.uuid]; !ok {
 		db.byUUID[c.uuid] = make([]int, 0, 1)
 	}
 	db.byUUID[c.uuid] = append(db.byUUID[c.uuid], len(db.data)-1)
-	db.save = true
+	db.touch(c)
+}
+
+// AddCopy adds a copy of c from another database, keeping its copy id (if it
+// is not taken), added-at date, owner, location, loan, tags and pricing.
+func (db *DB) AddCopy(c *DBCard) *DBCard {
+	n := &DBCard{
+		db:      db,
+		id:      c.id,
+		added:   c.added,
+		name:    c.name,
+		uuid:    c.uuid,
+		setID:   c.setID,
+		number:  c.number,
+		owner:   c.owner,
+		loc:     c.loc,
+		loan:    c.loan,
+		tags:    make(Tags, len(c.tags)),
+		pricing: c.pricing,
+	}
+	n.tags.Add(c.Tags())
+	db.Add(n)
+	return n
 }
 
 func (db *DB) AddMTGJSON(c Card) {
 	db.Add(FromCard(db, c))
 }
 
-func (db *DB) Delete(c *DBCard) {
-	n := false
-	for i := 0; i < len(db.data); i++ {
-		dc := db.data[i]
-		if c == dc {
-			n = true
-			db.data = append(db.data[:i], db.data[i+1:]...)
-			i--
-			db.save = true
+// Delete removes all given cards in a single pass.
+func (db *DB) Delete(cards ...*DBCard) {
+	del := make(map[*DBCard]struct{}, len(cards))
+	for _, c := range cards {
+		if _, ok := db.byID[c.id]; ok {
+			del[c] = struct{}{}
+		}
+	}
+	if len(del) == 0 {
+		return
+	}
+
+	data := db.data[:0]
+	for _, c := range db.data {
+		if _, ok := del[c]; !ok {
+			data = append(data, c)
+			continue
 		}
+		delete(db.byID, c.id)
+		delete(db.dirty, c)
+		db.deleted = append(db.deleted, c.id)
+	}
+	for i := len(data); i < len(db.data); i++ {
+		db.data[i] = nil
 	}
+	db.data = data
+	db.rebuildUUIDs()
+}
 
-	if n {
-		db.rebuildUUIDs()
+// Remap points c to the mtgjson card to, updating its name, set and number.
+func (db *DB) Remap(c *DBCard, to Card) {
+	if c.uuid == to.UUID && c.name == to.Name && c.setID == to.SetCode && c.number == to.Number {
+		return
 	}
+	if ix := db.IndexOf(c); ix >= 0 && c.uuid != to.UUID {
+		db.moveUUID(ix, c.uuid, to.UUID)
+	}
+	c.uuid, c.name, c.setID, c.number = to.UUID, to.Name, to.SetCode, to.Number
+	db.touch(c)
+}
+
+// moveUUID moves index ix from the byUUID entry of uuid from to that of to,
+// keeping both sorted.
+func (db *DB) moveUUID(ix int, from, to mtgjson.UUID) {
+	l := db.byUUID[from]
+	if i := sort.SearchInts(l, ix); i < len(l) && l[i] == ix {
+		l = append(l[:i], l[i+1:]...)
+	}
+	if len(l) == 0 {
+		delete(db.byUUID, from)
+	} else {
+		db.byUUID[from] = l
+	}
+
+	l = db.byUUID[to]
+	i := sort.SearchInts(l, ix)
+	l = append(l, 0)
+	copy(l[i+1:], l[i:])
+	l[i] = ix
+	db.byUUID[to] = l
 }
 
 func (db *DB) Cards() []*DBCard {
@@ -156,6 +340,11 @@ func (db *DB) Count(uuid mtgjson.UUID) int {
 	return len(db.byUUID[uuid])
 }
 
+func (db *DB) ByID(id CopyID) (*DBCard, bool) {
+	c, ok := db.byID[id]
+	return c, ok
+}
+
 func (db *DB) CardAt(ix int) (*DBCard, bool) {
 	if ix < 0 || ix >= len(db.data) {
 		return nil, false
@@ -163,45 +352,103 @@ func (db *DB) CardAt(ix int) (*DBCard, bool) {
 	return db.data[ix], true
 }
 
-func (db *DB) Save(file string) (bool, error) {
-	if !db.save {
-		_, err := os.Stat(file)
-		if err == nil {
-			return false, nil
+// IndexOf returns the index of c or -1 if it is not in db.
+func (db *DB) IndexOf(c *DBCard) int {
+	for _, ix := range db.byUUID[c.uuid] {
+		if db.data[ix] == c {
+			return ix
 		}
 	}
+	return -1
+}
 
-	tmp := file + ".tmp"
-	f, err := os.Create(tmp)
+// Dirty returns true if there are changes that have not been saved yet.
+func (db *DB) Dirty() bool {
+	return len(db.dirty) != 0 || len(db.deleted) != 0 || db.containersDirty
+}
+
+// Save merges the changes other sessions saved since the database was loaded
+// or last saved and writes all changes to storage, it returns false if there
+// were none. See Synced for the result of the merge.
+func (db *DB) Save() (bool, error) {
+	db.synced = SyncReport{}
+	if !db.Dirty() {
+		return false, nil
+	}
+	disk, err := db.store.Load()
 	if err != nil {
 		return true, err
 	}
+	containers, err := db.store.Containers()
+	if err != nil {
+		return true, err
+	}
+	db.synced = db.merge(disk)
+	db.mergeContainers(containers)
+	return true, db.write()
+}
+
+// Synced returns the changes of other sessions merged by the last Save, it is
+// empty if that saved nothing.
+func (db *DB) Synced() SyncReport { return db.synced }
 
-	enc := json.NewEncoder(f)
+func (db *DB) write() error {
+	changes := Changes{Cards: db.data, Deleted: db.deleted, Containers: db.containers}
 	for _, c := range db.data {
-		jc := jsonCard{
-			c.name,
-			c.uuid,
-			c.setID,
-			c.Tags(),
-			c.pricing,
-		}
-		if err := enc.Encode(jc); err != nil {
-			f.Close()
-			os.Remove(tmp)
-			return true, err
+		if _, ok := db.dirty[c]; ok {
+			changes.Changed = append(changes.Changed, c)
 		}
 	}
+	if err := db.store.Save(changes); err != nil {
+		return err
+	}
+	db.dirty = make(map[*DBCard]struct{})
+	db.deleted = nil
+	db.containersDirty = false
+	db.setBase()
+	return nil
+}
 
-	f.Close()
-	err = os.Rename(tmp, file)
+func (db *DB) setBase() {
+	db.base = make(map[CopyID]jsonCard, len(db.data))
+	for _, c := range db.data {
+		db.base[c.id] = c.json()
+	}
+	db.baseContainers = db.Containers()
+}
+
+// Migrate writes all cards and containers to the (empty) storage dst.
+func (db *DB) Migrate(dst Storage) error {
+	return dst.Save(Changes{Cards: db.data, Changed: db.data, Containers: db.containers})
+}
+
+// Restore replaces all cards and containers in the storage of db with those
+// of src (keeping their copy ids) in a single save and returns the database
+// to use instead of db. Unlike replacing the file, other sessions that have
+// the database open keep working.
+func (db *DB) Restore(src *DB) (*DB, error) {
+	n := &DB{
+		store:      db.store,
+		data:       make([]*DBCard, 0, len(src.data)),
+		byUUID:     make(map[mtgjson.UUID][]int),
+		byID:       make(map[CopyID]*DBCard),
+		dirty:      make(map[*DBCard]struct{}),
+		containers: src.Containers(),
+	}
+	for _, c := range src.data {
+		n.AddCopy(c)
+	}
+	err := n.store.Save(Changes{Cards: n.data, Changed: n.data, Containers: n.containers, Replace: true})
 	if err != nil {
-		return true, err
+		return db, err
 	}
-	db.save = false
-	return true, nil
+	n.dirty = make(map[*DBCard]struct{})
+	n.setBase()
+	return n, nil
 }
 
+func (db *DB) Close() error { return db.store.Close() }
+
 func (db *DB) rebuildUUIDs() {
 	byUUID := make(map[mtgjson.UUID][]int)
 	for i, c := range db.data {
@@ -213,38 +460,45 @@ func (db *DB) rebuildUUIDs() {
 	db.byUUID = byUUID
 }
 
-func LoadDB(file string) (*DB, error) {
-	byUUID := make(map[mtgjson.UUID][]int)
-	db := &DB{data: make([]*DBCard, 0, 1024), byUUID: byUUID}
-	f, err := os.Open(file)
+// LoadDB reads all cards and containers from store. Cards with a duplicate
+// copy id (e.g.: a hand edited database) are assigned a new one, which is
+// saved right away so it stays stable across sessions.
+func LoadDB(store Storage) (*DB, error) {
+	db := &DB{
+		store:  store,
+		data:   make([]*DBCard, 0, 1024),
+		byUUID: make(map[mtgjson.UUID][]int),
+		byID:   make(map[CopyID]*DBCard),
+		dirty:  make(map[*DBCard]struct{}),
+	}
+
+	list, err := store.Load()
 	if err != nil {
-		if os.IsNotExist(err) {
-			return db, nil
-		}
 		return nil, err
 	}
-	defer f.Close()
+	if db.containers, err = store.Containers(); err != nil {
+		return nil, err
+	}
 
-	dec := json.NewDecoder(f)
-	for dec.More() {
-		jc := &jsonCard{}
-		if err := dec.Decode(jc); err != nil {
-			return nil, err
+	assigned := make([]*DBCard, 0)
+	for _, jc := range list {
+		c := fromJSON(db, jc)
+		db.add(c)
+		if c.id != jc.ID {
+			assigned = append(assigned, c)
 		}
-		tags := make(Tags)
-		tags.Add(jc.Tags)
-		c := &DBCard{
-			db,
-			jc.Name,
-			jc.UUID,
-			jc.SetID,
-			tags,
-			false,
-			jc.Pricing,
+	}
+
+	db.dirty = make(map[*DBCard]struct{})
+	db.setBase()
+	if len(assigned) != 0 {
+		for _, c := range assigned {
+			db.touch(c)
+		}
+		if err := db.write(); err != nil {
+			return nil, err
 		}
-		db.Add(c)
 	}
-	db.save = false
 
 	return db, nil
 }
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/frizinak/gomtg/mtgjson"
+)
+
+// DiffLevel decides when two cards in different databases are the same.
+type DiffLevel string
+
+const (
+	// DiffPrinting matches cards with the same uuid (set, number, language).
+	DiffPrinting DiffLevel = "printing"
+	// DiffOracle matches cards with the same rules text (any printing).
+	DiffOracle DiffLevel = "oracle"
+)
+
+func (l DiffLevel) Valid() bool {
+	return l == DiffPrinting || l == DiffOracle
+}
+
+// DiffCard is a card in one of the databases, Index starts at 1.
+type DiffCard struct {
+	ID    CopyID        `json:"id"`
+	Index int           `json:"index"`
+	UUID  mtgjson.UUID  `json:"uuid"`
+	Name  string        `json:"name"`
+	SetID mtgjson.SetID `json:"set_id"`
+	Tags  []string      `json:"tags,omitempty"`
+	Value float64       `json:"value"`
+}
+
+// DiffTagging is a card (as it is in the second database) whose tags
+// differ.
+type DiffTagging struct {
+	Card   DiffCard `json:"card"`
+	Add    []string `json:"add,omitempty"`
+	Remove []string `json:"remove,omitempty"`
+}
+
+// DiffMove is a card (as it is in the second database) whose position
+// relative to the other cards changed, From is its index in the first
+// database.
+type DiffMove struct {
+	Card DiffCard `json:"card"`
+	From int      `json:"from"`
+}
+
+// DiffPlace is a card (as it is in the second database) that is in another
+// location, e.g.: moved to another container. From and To are
+// <container>:<slot> or empty if the card was not placed.
+type DiffPlace struct {
+	Card DiffCard `json:"card"`
+	From string   `json:"from,omitempty"`
+	To   string   `json:"to,omitempty"`
+}
+
+// DiffValue is the total value of both databases.
+type DiffValue struct {
+	From  float64 `json:"from"`
+	To    float64 `json:"to"`
+	Delta float64 `json:"delta"`
+}
+
+// CollectionDiff are the differences between two databases.
+type CollectionDiff struct {
+	From     string        `json:"from"`
+	To       string        `json:"to"`
+	Level    DiffLevel     `json:"level"`
+	Currency string        `json:"currency"`
+	Added    []DiffCard    `json:"added"`
+	Removed  []DiffCard    `json:"removed"`
+	Retagged []DiffTagging `json:"retagged"`
+	Moved    []DiffMove    `json:"moved"`
+	Placed   []DiffPlace   `json:"placed"`
+	Value    DiffValue     `json:"value"`
+}
+
+func (d CollectionDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Retagged) == 0 && len(d.Moved) == 0 &&
+		len(d.Placed) == 0
+}
+
+func (d CollectionDiff) String() string {
+	return fmt.Sprintf(
+		"%s -> %s (%s): +%d -%d ~%d >%d @%d, value %.2f -> %.2f (%+.2f %s)",
+		d.From,
+		d.To,
+		d.Level,
+		len(d.Added),
+		len(d.Removed),
+		len(d.Retagged),
+		len(d.Moved),
+		len(d.Placed),
+		d.Value.From,
+		d.Value.To,
+		d.Value.Delta,
+		strings.ToUpper(d.Currency),
+	)
+}
+
+// Table returns a line for every change in d followed by a summary.
+func (d CollectionDiff) Table() []string {
+	card := func(c DiffCard) string {
+		s := fmt.Sprintf("%6d %s %s %-5s %-30s %8.2f", c.Index, c.ID, c.UUID, c.SetID, c.Name, c.Value)
+		if len(c.Tags) != 0 {
+			s += " [" + strings.Join(c.Tags, ",") + "]"
+		}
+		return s
+	}
+
+	l := make([]string, 0, len(d.Added)+len(d.Removed)+len(d.Retagged)+len(d.Moved)+len(d.Placed)+1)
+	for _, c := range d.Added {
+		l = append(l, " + "+card(c))
+	}
+	for _, c := range d.Removed {
+		l = append(l, " - "+card(c))
+	}
+	for _, t := range d.Retagged {
+		tags := make([]string, 0, len(t.Add)+len(t.Remove))
+		for _, tag := range t.Add {
+			tags = append(tags, "+"+tag)
+		}
+		for _, tag := range t.Remove {
+			tags = append(tags, "-"+tag)
+		}
+		l = append(l, fmt.Sprintf(" ~ %6d %s %s %-5s %s %s", t.Card.Index, t.Card.ID, t.Card.UUID, t.Card.SetID, t.Card.Name, strings.Join(tags, " ")))
+	}
+	for _, m := range d.Moved {
+		l = append(l, fmt.Sprintf(" > %6d %s %s %-5s %s (was %d)", m.Card.Index, m.Card.ID, m.Card.UUID, m.Card.SetID, m.Card.Name, m.From))
+	}
+	for _, p := range d.Placed {
+		from, to := p.From, p.To
+		if from == "" {
+			from = "-"
+		}
+		if to == "" {
+			to = "-"
+		}
+		l = append(l, fmt.Sprintf(" @ %6d %s %s %-5s %s %s -> %s", p.Card.Index, p.Card.ID, p.Card.UUID, p.Card.SetID, p.Card.Name, from, to))
+	}
+	return append(l, d.String())
+}
+
+// Diff compares the databases from and to. Copies with the same copy id are
+// matched first (e.g.: a backup of the same database), the remaining cards
+// are matched by printing or oracle id, preferring copies with the same tags.
+// Cards that changed position relative to the other matched cards are moved,
+// cards that merely shifted because others were added or removed are not.
+// Matched cards in another container or slot are placed.
+func (a *App) Diff(from, to *DB, level DiffLevel) CollectionDiff {
+	d := CollectionDiff{
+		Level:    level,
+		Currency: a.pricing.currency,
+		Added:    []DiffCard{},
+		Removed:  []DiffCard{},
+		Retagged: []DiffTagging{},
+		Moved:    []DiffMove{},
+		Placed:   []DiffPlace{},
+	}
+
+	key := func(c *DBCard) string {
+		if level == DiffOracle {
+			if rc, ok := a.Cards.ByUUID(c.UUID()); ok && rc.Identifiers.ScryfallOracleId != "" {
+				return rc.Identifiers.ScryfallOracleId
+			}
+			return "name:" + strings.ToLower(c.Name())
+		}
+		return string(c.UUID())
+	}
+	diffCard := func(c *DBCard, ix int) DiffCard {
+		v := a.PricingValue(c.Pricing(), c.Foil())
+		if v == 0 {
+			v, _ = a.GetPricing(c.UUID(), c.Foil(), false)
+		}
+		return DiffCard{c.ID(), ix + 1, c.UUID(), c.Name(), c.SetID(), c.Tags(), v}
+	}
+
+	fromCards, toCards := from.Cards(), to.Cards()
+	pairs := make(map[int]int, len(fromCards))
+	matched := make(map[int]struct{}, len(toCards))
+	for i, c := range fromCards {
+		if o, ok := to.ByID(c.ID()); ok && key(o) == key(c) {
+			j := to.IndexOf(o)
+			pairs[i] = j
+			matched[j] = struct{}{}
+		}
+	}
+
+	type group struct{ from, to []int }
+	groups := make(map[string]*group)
+	var keys []string
+	get := func(k string) *group {
+		g, ok := groups[k]
+		if !ok {
+			g = &group{}
+			groups[k] = g
+			keys = append(keys, k)
+		}
+		return g
+	}
+	for i, c := range fromCards {
+		if _, ok := pairs[i]; !ok {
+			g := get(key(c))
+			g.from = append(g.from, i)
+		}
+	}
+	for j, c := range toCards {
+		if _, ok := matched[j]; !ok {
+			g := get(key(c))
+			g.to = append(g.to, j)
+		}
+	}
+
+	tagKey := func(c *DBCard) string { return strings.Join(c.Tags(), "\x00") }
+	for _, k := range keys {
+		g := groups[k]
+		used := make([]bool, len(g.to))
+		rest := make([]int, 0, len(g.from))
+		for _, i := range g.from {
+			found := false
+			for n, j := range g.to {
+				if !used[n] && tagKey(fromCards[i]) == tagKey(toCards[j]) {
+					used[n], found = true, true
+					pairs[i] = j
+					break
+				}
+			}
+			if !found {
+				rest = append(rest, i)
+			}
+		}
+		n := 0
+		for _, i := range rest {
+			for n < len(g.to) && used[n] {
+				n++
+			}
+			if n == len(g.to) {
+				break
+			}
+			used[n] = true
+			pairs[i] = g.to[n]
+		}
+	}
+
+	// the longest run of matched cards that kept their order did not move.
+	order := make([]int, 0, len(pairs))
+	for i := range fromCards {
+		if _, ok := pairs[i]; ok {
+			order = append(order, i)
+		}
+	}
+	stay := inOrder(order, func(i int) int { return pairs[i] })
+
+	paired := make(map[int]struct{}, len(pairs))
+	for _, i := range order {
+		j := pairs[i]
+		paired[j] = struct{}{}
+		c, o := fromCards[i], toCards[j]
+		var add, del []string
+		for _, t := range o.Tags() {
+			if !c.HasTag(t) {
+				add = append(add, t)
+			}
+		}
+		for _, t := range c.Tags() {
+			if !o.HasTag(t) {
+				del = append(del, t)
+			}
+		}
+		if len(add) != 0 || len(del) != 0 {
+			d.Retagged = append(d.Retagged, DiffTagging{diffCard(o, j), add, del})
+		}
+		if _, ok := stay[i]; !ok {
+			d.Moved = append(d.Moved, DiffMove{diffCard(o, j), i + 1})
+		}
+		if c.Location() != o.Location() {
+			d.Placed = append(d.Placed, DiffPlace{diffCard(o, j), logLocation(c.Location()), logLocation(o.Location())})
+		}
+	}
+	sort.Slice(d.Moved, func(i, j int) bool { return d.Moved[i].Card.Index < d.Moved[j].Card.Index })
+	sort.Slice(d.Placed, func(i, j int) bool { return d.Placed[i].Card.Index < d.Placed[j].Card.Index })
+
+	for i, c := range fromCards {
+		dc := diffCard(c, i)
+		d.Value.From += dc.Value
+		if _, ok := pairs[i]; !ok {
+			d.Removed = append(d.Removed, dc)
+		}
+	}
+	for j, c := range toCards {
+		dc := diffCard(c, j)
+		d.Value.To += dc.Value
+		if _, ok := paired[j]; !ok {
+			d.Added = append(d.Added, dc)
+		}
+	}
+	d.Value.Delta = d.Value.To - d.Value.From
+
+	return d
+}
+
+// inOrder returns the longest subsequence of items whose value is
+// increasing.
+func inOrder(items []int, value func(int) int) map[int]struct{} {
+	// tails[n] is the index in items of the smallest value ending a run of
+	// length n+1, prev links each item to the one before it in its run.
+	tails := make([]int, 0, len(items))
+	prev := make([]int, len(items))
+	for i, item := range items {
+		v := value(item)
+		n := sort.Search(len(tails), func(n int) bool { return value(items[tails[n]]) >= v })
+		prev[i] = -1
+		if n > 0 {
+			prev[i] = tails[n-1]
+		}
+		if n == len(tails) {
+			tails = append(tails, i)
+			continue
+		}
+		tails[n] = i
+	}
+
+	run := make(map[int]struct{}, len(tails))
+	if len(tails) == 0 {
+		return run
+	}
+	for i := tails[len(tails)-1]; i >= 0; i = prev[i] {
+		run[items[i]] = struct{}{}
+	}
+	return run
+}
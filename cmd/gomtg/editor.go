@@ -0,0 +1,556 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/containerd/console"
+	"github.com/mattn/go-runewidth"
+)
+
+const historySize = 1000
+
+// escTimeout is how long to wait for the rest of an escape sequence before
+// treating ESC as a key on its own.
+const escTimeout = 50 * time.Millisecond
+
+// Completer returns the rune offset in line from which the candidates
+// should replace the text up to pos.
+type Completer func(line []rune, pos int) (int, []string)
+
+type key int
+
+const (
+	keyRune key = iota
+	keyUp
+	keyDown
+	keyLeft
+	keyRight
+	keyHome
+	keyEnd
+	keyDelete
+	keyWordLeft
+	keyWordRight
+	keyPageUp
+	keyPageDown
+	keyCursorUp
+	keyCursorDown
+	keyUnknown
+)
+
+// Editor is a minimal raw mode line editor with history and tab completion.
+// It implements io.Writer so other output can be written without
+// garbling the line being edited.
+type Editor struct {
+	c     console.Console
+	r     *bufio.Reader
+	w     io.Writer
+	mutex sync.Mutex
+
+	runes   chan readRune
+	pending *readRune
+
+	prompt string
+	buf    []rune
+	pos    int
+
+	history     []string
+	historyFile string
+	historyIx   int
+	historyTmp  []rune
+
+	complete  Completer
+	interrupt func()
+	eof       func()
+	scroll    func(n int, page bool)
+}
+
+type readRune struct {
+	r   rune
+	err error
+}
+
+func NewEditor(c console.Console, in io.Reader, out io.Writer, historyFile string) (*Editor, error) {
+	e := &Editor{
+		c:           c,
+		r:           bufio.NewReader(in),
+		runes:       make(chan readRune, 16),
+		w:           out,
+		history:     make([]string, 0, historySize),
+		historyFile: historyFile,
+		complete:    func([]rune, int) (int, []string) { return 0, nil },
+		interrupt:   func() {},
+		eof:         func() {},
+		scroll:      func(int, bool) {},
+	}
+
+	return e, e.loadHistory()
+}
+
+func (e *Editor) SetCompleter(c Completer) { e.complete = c }
+func (e *Editor) OnInterrupt(cb func())    { e.interrupt = cb }
+func (e *Editor) OnEOF(cb func())          { e.eof = cb }
+
+// OnScroll registers a callback for the page up / down and
+// shift|ctrl|alt-up / down keys.
+func (e *Editor) OnScroll(cb func(n int, page bool)) { e.scroll = cb }
+
+func (e *Editor) SetPrompt(p string) {
+	e.mutex.Lock()
+	e.prompt = p
+	e.mutex.Unlock()
+}
+
+func (e *Editor) Refresh() {
+	e.mutex.Lock()
+	e.refresh()
+	e.mutex.Unlock()
+}
+
+// Write translates newlines as the terminal is in raw mode.
+func (e *Editor) Write(b []byte) (int, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	s := strings.ReplaceAll(string(b), "\n", "\r\n")
+	_, err := io.WriteString(e.w, s)
+	return len(b), err
+}
+
+func (e *Editor) Close() error {
+	return e.c.Reset()
+}
+
+func (e *Editor) Run(lines chan<- string) error {
+	if err := e.c.SetRaw(); err != nil {
+		return err
+	}
+
+	// reads happen in the background so the rest of an escape sequence can
+	// be waited for with a timeout.
+	go func() {
+		for {
+			r, _, err := e.r.ReadRune()
+			e.runes <- readRune{r, err}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		k, r, err := e.readKey()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case k == keyRune && r == '\t':
+			e.tab()
+			continue
+		case k == keyRune && (r == '\r' || r == '\n'):
+			e.mutex.Lock()
+			line := string(e.buf)
+			e.buf, e.pos = e.buf[:0], 0
+			e.historyIx, e.historyTmp = len(e.history), nil
+			_, _ = io.WriteString(e.w, "\r\n")
+			e.mutex.Unlock()
+			e.addHistory(line)
+			lines <- line
+			continue
+		case k == keyRune && r == 3: // ctrl-c
+			e.mutex.Lock()
+			e.buf, e.pos = e.buf[:0], 0
+			e.refresh()
+			e.mutex.Unlock()
+			e.interrupt()
+			continue
+		case k == keyRune && r == 4 && e.empty(): // ctrl-d
+			e.eof()
+			continue
+		case k == keyPageUp:
+			e.scroll(-1, true)
+			continue
+		case k == keyPageDown:
+			e.scroll(1, true)
+			continue
+		case k == keyCursorUp:
+			e.scroll(-1, false)
+			continue
+		case k == keyCursorDown:
+			e.scroll(1, false)
+			continue
+		}
+
+		e.mutex.Lock()
+		e.handle(k, r)
+		e.refresh()
+		e.mutex.Unlock()
+	}
+}
+
+func (e *Editor) empty() bool {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return len(e.buf) == 0
+}
+
+// next returns the next rune, ok is false if timeout (0 means none)
+// elapsed first.
+func (e *Editor) next(timeout time.Duration) (r rune, ok bool, err error) {
+	if p := e.pending; p != nil {
+		e.pending = nil
+		return p.r, true, p.err
+	}
+	if timeout == 0 {
+		rr := <-e.runes
+		return rr.r, true, rr.err
+	}
+
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case rr := <-e.runes:
+		return rr.r, true, rr.err
+	case <-t.C:
+		return 0, false, nil
+	}
+}
+
+func (e *Editor) readKey() (key, rune, error) {
+	r, _, err := e.next(0)
+	if err != nil || r != 27 {
+		return keyRune, r, err
+	}
+
+	r, ok, err := e.next(escTimeout)
+	if err != nil || !ok {
+		return keyUnknown, 0, err
+	}
+	switch r {
+	case 'b':
+		return keyWordLeft, 0, nil
+	case 'f':
+		return keyWordRight, 0, nil
+	case '[', 'O':
+	default:
+		e.pending = &readRune{r, nil}
+		return keyUnknown, 0, nil
+	}
+
+	seq := make([]rune, 0, 4)
+	for {
+		r, ok, err = e.next(escTimeout)
+		if err != nil || !ok {
+			return keyUnknown, 0, err
+		}
+		if r >= 0x40 && r <= 0x7e {
+			break
+		}
+		seq = append(seq, r)
+	}
+
+	modified := strings.Contains(string(seq), ";")
+	switch r {
+	case 'A':
+		if modified {
+			return keyCursorUp, 0, nil
+		}
+		return keyUp, 0, nil
+	case 'B':
+		if modified {
+			return keyCursorDown, 0, nil
+		}
+		return keyDown, 0, nil
+	case 'C':
+		if strings.HasSuffix(string(seq), ";5") {
+			return keyWordRight, 0, nil
+		}
+		return keyRight, 0, nil
+	case 'D':
+		if strings.HasSuffix(string(seq), ";5") {
+			return keyWordLeft, 0, nil
+		}
+		return keyLeft, 0, nil
+	case 'H':
+		return keyHome, 0, nil
+	case 'F':
+		return keyEnd, 0, nil
+	case '~':
+		switch string(seq) {
+		case "1", "7":
+			return keyHome, 0, nil
+		case "4", "8":
+			return keyEnd, 0, nil
+		case "3":
+			return keyDelete, 0, nil
+		case "5":
+			return keyPageUp, 0, nil
+		case "6":
+			return keyPageDown, 0, nil
+		}
+	}
+
+	return keyUnknown, 0, nil
+}
+
+func (e *Editor) handle(k key, r rune) {
+	switch k {
+	case keyUp:
+		e.browseHistory(-1)
+	case keyDown:
+		e.browseHistory(1)
+	case keyLeft:
+		if e.pos > 0 {
+			e.pos--
+		}
+	case keyRight:
+		if e.pos < len(e.buf) {
+			e.pos++
+		}
+	case keyHome:
+		e.pos = 0
+	case keyEnd:
+		e.pos = len(e.buf)
+	case keyDelete:
+		if e.pos < len(e.buf) {
+			e.buf = append(e.buf[:e.pos], e.buf[e.pos+1:]...)
+		}
+	case keyWordLeft:
+		e.pos = e.wordStart()
+	case keyWordRight:
+		for e.pos < len(e.buf) && unicode.IsSpace(e.buf[e.pos]) {
+			e.pos++
+		}
+		for e.pos < len(e.buf) && !unicode.IsSpace(e.buf[e.pos]) {
+			e.pos++
+		}
+	case keyRune:
+		switch r {
+		case 1: // ctrl-a
+			e.pos = 0
+		case 2: // ctrl-b
+			e.handle(keyLeft, 0)
+		case 4: // ctrl-d
+			e.handle(keyDelete, 0)
+		case 5: // ctrl-e
+			e.pos = len(e.buf)
+		case 6: // ctrl-f
+			e.handle(keyRight, 0)
+		case 8, 127: // backspace
+			if e.pos > 0 {
+				e.buf = append(e.buf[:e.pos-1], e.buf[e.pos:]...)
+				e.pos--
+			}
+		case 11: // ctrl-k
+			e.buf = e.buf[:e.pos]
+		case 14: // ctrl-n
+			e.browseHistory(1)
+		case 16: // ctrl-p
+			e.browseHistory(-1)
+		case 21: // ctrl-u
+			e.buf = append(e.buf[:0], e.buf[e.pos:]...)
+			e.pos = 0
+		case 23: // ctrl-w
+			start := e.wordStart()
+			e.buf = append(e.buf[:start], e.buf[e.pos:]...)
+			e.pos = start
+		default:
+			if !unicode.IsPrint(r) {
+				return
+			}
+			e.insert([]rune{r})
+		}
+	}
+}
+
+func (e *Editor) insert(r []rune) {
+	n := make([]rune, 0, len(e.buf)+len(r))
+	n = append(n, e.buf[:e.pos]...)
+	n = append(n, r...)
+	n = append(n, e.buf[e.pos:]...)
+	e.buf = n
+	e.pos += len(r)
+}
+
+func (e *Editor) wordStart() int {
+	p := e.pos
+	for p > 0 && unicode.IsSpace(e.buf[p-1]) {
+		p--
+	}
+	for p > 0 && !unicode.IsSpace(e.buf[p-1]) {
+		p--
+	}
+	return p
+}
+
+func (e *Editor) refresh() {
+	width := 80
+	if dims, err := e.c.Size(); err == nil && dims.Width != 0 {
+		width = int(dims.Width)
+	}
+	promptWidth := runewidth.StringWidth(csiRE.ReplaceAllString(e.prompt, ""))
+	avail := width - promptWidth - 1
+
+	start, end := 0, len(e.buf)
+	if avail > 10 {
+		for runewidth.StringWidth(string(e.buf[start:e.pos])) > avail {
+			start++
+		}
+		for runewidth.StringWidth(string(e.buf[start:end])) > avail {
+			end--
+		}
+	}
+
+	s := "\r\033[K" + e.prompt + string(e.buf[start:end])
+	if back := runewidth.StringWidth(string(e.buf[e.pos:end])); back > 0 {
+		s += fmt.Sprintf("\033[%dD", back)
+	}
+	_, _ = io.WriteString(e.w, s)
+}
+
+func (e *Editor) tab() {
+	e.mutex.Lock()
+	line := make([]rune, len(e.buf))
+	copy(line, e.buf)
+	pos := e.pos
+	e.mutex.Unlock()
+
+	start, candidates := e.complete(line, pos)
+	if len(candidates) == 0 || start < 0 || start > pos {
+		return
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	word := string(line[start:pos])
+	replace := func(with string) {
+		e.buf = append(e.buf[:start], e.buf[pos:]...)
+		e.pos = start
+		e.insert([]rune(with))
+		e.refresh()
+	}
+
+	if len(candidates) == 1 {
+		replace(candidates[0] + " ")
+		return
+	}
+
+	prefix := commonPrefix(candidates)
+	if len([]rune(prefix)) > len([]rune(word)) &&
+		strings.HasPrefix(strings.ToLower(prefix), strings.ToLower(word)) {
+		replace(prefix)
+		return
+	}
+
+	sort.Strings(candidates)
+	const max = 100
+	more := len(candidates) - max
+	if more > 0 {
+		candidates = candidates[:max]
+	}
+	list := strings.Join(candidates, "  ")
+	if more > 0 {
+		list += fmt.Sprintf("  (and %d more)", more)
+	}
+	_, _ = io.WriteString(e.w, "\r\n"+list+"\r\n")
+	e.refresh()
+}
+
+func commonPrefix(list []string) string {
+	if len(list) == 0 {
+		return ""
+	}
+	prefix := []rune(list[0])
+	for _, s := range list[1:] {
+		r := []rune(s)
+		n := 0
+		for n < len(prefix) && n < len(r) && prefix[n] == r[n] {
+			n++
+		}
+		prefix = prefix[:n]
+	}
+	return string(prefix)
+}
+
+func (e *Editor) browseHistory(dir int) {
+	ix := e.historyIx + dir
+	if ix < 0 || ix > len(e.history) {
+		return
+	}
+	if e.historyIx == len(e.history) {
+		e.historyTmp = append(e.historyTmp[:0], e.buf...)
+	}
+	e.historyIx = ix
+	if ix == len(e.history) {
+		e.buf = append(e.buf[:0], e.historyTmp...)
+	} else {
+		e.buf = []rune(e.history[ix])
+	}
+	e.pos = len(e.buf)
+}
+
+func (e *Editor) loadHistory() error {
+	f, err := os.Open(e.historyFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	scan := bufio.NewScanner(f)
+	for scan.Scan() {
+		e.history = append(e.history, scan.Text())
+	}
+	f.Close()
+	if err := scan.Err(); err != nil {
+		return err
+	}
+
+	if len(e.history) > historySize {
+		e.history = e.history[len(e.history)-historySize:]
+		tmp := e.historyFile + ".tmp"
+		err := os.WriteFile(tmp, []byte(strings.Join(e.history, "\n")+"\n"), 0600)
+		if err != nil {
+			return err
+		}
+		if err := os.Rename(tmp, e.historyFile); err != nil {
+			return err
+		}
+	}
+	e.historyIx = len(e.history)
+
+	return nil
+}
+
+func (e *Editor) addHistory(line string) {
+	line = strings.TrimSpace(line)
+	e.mutex.Lock()
+	if line == "" ||
+		(len(e.history) != 0 && e.history[len(e.history)-1] == line) {
+		e.mutex.Unlock()
+		return
+	}
+	e.history = append(e.history, line)
+	if len(e.history) > historySize {
+		// in place so the backing array does not keep growing.
+		n := copy(e.history, e.history[len(e.history)-historySize:])
+		e.history = e.history[:n]
+	}
+	e.historyIx = len(e.history)
+	e.mutex.Unlock()
+
+	f, err := os.OpenFile(e.historyFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return
+	}
+	_, _ = fmt.Fprintln(f, line)
+	f.Close()
+}
@@ -12,7 +12,7 @@ import (
 func exportCSV(cards []LocalCard, dir string) (string, error) {
 	file := filepath.Join(
 		dir,
-		fmt.Sprintf("export-%s.csv", time.Now().Format("2006-02-01_15-04-05")),
+		fmt.Sprintf("export-%s.csv", time.Now().Format("2006-01-02_15-04-05")),
 	)
 	f, err := os.Create(file)
 	if err != nil {
@@ -21,11 +21,17 @@ func exportCSV(cards []LocalCard, dir string) (string, error) {
 	w := csv.NewWriter(f)
 	defer f.Close()
 
-	recs := make([]string, 4)
+	recs := make([]string, 10)
 	recs[0] = "Index"
 	recs[1] = "Name"
 	recs[2] = "Set Code"
 	recs[3] = "Foil"
+	recs[4] = "ID"
+	recs[5] = "Added"
+	recs[6] = "Owner"
+	recs[7] = "Location"
+	recs[8] = "Lent To"
+	recs[9] = "Lent Since"
 	if err := w.Write(recs); err != nil {
 		return file, err
 	}
@@ -40,6 +46,18 @@ func exportCSV(cards []LocalCard, dir string) (string, error) {
 		recs[1] = c.Name()
 		recs[2] = string(c.SetID())
 		recs[3] = foil
+		recs[4] = string(c.ID())
+		recs[5] = ""
+		if !c.Added().IsZero() {
+			recs[5] = c.Added().Format(time.RFC3339)
+		}
+		recs[6] = c.Owner()
+		recs[7] = logLocation(c.Location())
+		recs[8], recs[9] = "", ""
+		if l := c.Loan(); !l.Empty() {
+			recs[8] = l.To
+			recs[9] = l.Since.Format(time.RFC3339)
+		}
 
 		if err := w.Write(recs); err != nil {
 			return file, err
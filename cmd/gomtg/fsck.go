@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/frizinak/gomtg/mtgjson"
+)
+
+// FsckIssue is a problem with a card in the collection and the changes that
+// would fix it, if any.
+type FsckIssue struct {
+	Card    LocalCard
+	Problem string
+	Fix     string
+	Remap   *Remap
+	Tagging *Tagging
+}
+
+func (i FsckIssue) String() string {
+	s := fmt.Sprintf("%6d %s %s %-5s %s: %s", i.Card.Index+1, i.Card.ID(), i.Card.UUID(), i.Card.SetID(), i.Card.Name(), i.Problem)
+	if i.Fix == "" {
+		return s + ", no fix available"
+	}
+	return s + ", fix: " + i.Fix
+}
+
+type fsckKey struct {
+	name   string
+	set    mtgjson.SetID
+	number string
+}
+
+// Fsck cross-checks every card in the collection with the mtgjson data.
+// Cards with an unknown uuid (e.g.: after mtgjson re-issued uuids) are
+// remapped by their mtgjson v4 id, name, set and number or a unique name and
+// set. Cards whose name or set no longer match are updated and empty or
+// duplicate (same but for case or surrounding whitespace) tags are removed.
+func (a *App) Fsck() []FsckIssue {
+	byV4 := make(map[mtgjson.UUID]Card)
+	byKey := make(map[fsckKey][]Card)
+	for _, c := range a.Cards.Cards {
+		if !c.Front() {
+			continue
+		}
+		if c.Identifiers.MtgjsonV4Id != "" {
+			byV4[mtgjson.UUID(c.Identifiers.MtgjsonV4Id)] = c
+		}
+		k := fsckKey{c.Name, c.SetCode, c.Number}
+		byKey[k] = append(byKey[k], c)
+		k.number = ""
+		byKey[k] = append(byKey[k], c)
+	}
+
+	lookup := func(c *DBCard) (Card, string, bool) {
+		if rc, ok := byV4[c.UUID()]; ok {
+			return rc, "mtgjson v4 id", true
+		}
+		if c.Number() != "" {
+			if l := byKey[fsckKey{c.Name(), c.SetID(), c.Number()}]; len(l) == 1 {
+				return l[0], "name, set and number", true
+			}
+		}
+		if l := byKey[fsckKey{c.Name(), c.SetID(), ""}]; len(l) == 1 {
+			return l[0], "name and set", true
+		}
+		return Card{}, "", false
+	}
+
+	var issues []FsckIssue
+	for i, c := range a.DB.Cards() {
+		lc := NewLocalCard(c, i)
+		rc, ok := a.Cards.ByUUID(c.UUID())
+		switch {
+		case !ok:
+			issue := FsckIssue{Card: lc, Problem: "unknown uuid"}
+			if rc, how, ok := lookup(c); ok {
+				issue.Fix = fmt.Sprintf("remap to %s (%s, %s) by %s", rc.UUID, rc.Name, rc.SetCode, how)
+				issue.Remap = &Remap{c, rc.UUID}
+			}
+			issues = append(issues, issue)
+		case rc.Name != c.Name() || rc.SetCode != c.SetID():
+			issues = append(issues, FsckIssue{
+				Card:    lc,
+				Problem: fmt.Sprintf("mtgjson has %s (%s)", rc.Name, rc.SetCode),
+				Fix:     "update name and set",
+				Remap:   &Remap{c, rc.UUID},
+			})
+		}
+
+		// keep the first spelling of each tag that needs no trimming.
+		tags := c.Tags()
+		keep := make(map[string]string)
+		for _, tag := range tags {
+			trimmed := strings.TrimSpace(tag)
+			key := strings.ToLower(trimmed)
+			if k, ok := keep[key]; trimmed != "" && (!ok || (k != strings.TrimSpace(k) && trimmed == tag)) {
+				keep[key] = tag
+			}
+		}
+
+		t := NewTagging(c)
+		var fixes []string
+		for _, tag := range tags {
+			trimmed := strings.TrimSpace(tag)
+			k := keep[strings.ToLower(trimmed)]
+			switch {
+			case trimmed == "":
+				t.Add(false, tag)
+				fixes = append(fixes, fmt.Sprintf("remove empty tag '%s'", tag))
+			case tag == k && k != trimmed:
+				t.Add(false, tag)
+				t.Add(true, trimmed)
+				fixes = append(fixes, fmt.Sprintf("rename '%s' to '%s'", tag, trimmed))
+			case tag != k:
+				t.Add(false, tag)
+				fixes = append(fixes, fmt.Sprintf("remove '%s' (duplicate of '%s')", tag, strings.TrimSpace(k)))
+			}
+		}
+		if len(fixes) != 0 {
+			issues = append(issues, FsckIssue{
+				Card:    lc,
+				Problem: "empty or duplicate tags",
+				Fix:     strings.Join(fixes, ", "),
+				Tagging: &t,
+			})
+		}
+	}
+
+	return issues
+}
@@ -2,11 +2,14 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"image"
 	"io"
+	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
@@ -23,19 +26,20 @@ import (
 	"github.com/frizinak/gomtg/mtgjson"
 	"github.com/frizinak/gomtg/scryfall"
 	"github.com/mattn/go-runewidth"
-	"github.com/nightlyone/lockfile"
 )
 
 var GitVersion string
 
+var stdout io.Writer = os.Stdout
+
 func progress(msg string, cb func() error) error {
-	fmt.Printf("\033[?25l[ ] %s", msg)
+	fmt.Fprintf(stdout, "\033[?25l[ ] %s", msg)
 	ts := time.Now()
 	if err := cb(); err != nil {
-		fmt.Println()
+		fmt.Fprintln(stdout)
 		return err
 	}
-	fmt.Printf("\033[2GX\033[30C %dms\n\033[?25h", int(time.Since(ts).Milliseconds()))
+	fmt.Fprintf(stdout, "\033[2GX\033[30C %dms\n\033[?25h", int(time.Since(ts).Milliseconds()))
 	return nil
 }
 
@@ -162,6 +166,18 @@ type App struct {
 	Colors Colors
 	Scry   *scryfall.API
 
+	// Offline disables fetching pricing data.
+	Offline bool
+
+	// Backups are taken before each commit that changes the database.
+	Backups Backups
+
+	// Owner is the owner of newly added cards.
+	Owner string
+
+	fuzz      *fuzzy.Index
+	localFuzz *fuzzy.Index
+
 	pricing struct {
 		currency string
 		data     map[mtgjson.UUID]Pricing
@@ -194,10 +210,16 @@ func (a *App) PricingValue(p Pricing, foil bool) float64 {
 
 func (a *App) GetFullPricing(uuid mtgjson.UUID, fetch, forceFetch, wait bool) Pricing {
 	p := Pricing{T: time.Now()}
+	if a.Offline {
+		fetch, forceFetch = false, false
+	}
 	c, ok := a.Cards.ByUUID(uuid)
 	if !ok || c.Identifiers.ScryfallId == "" {
 		return p
 	}
+	// all faces of a physical card share the price of the front face.
+	c = a.Cards.FrontFace(c)
+	uuid = c.UUID
 	id := c.Identifiers.ScryfallId
 	check := func() (Pricing, bool) {
 		v, ok := a.pricing.data[uuid]
@@ -277,6 +299,235 @@ func (a *App) GetPricing(uuid mtgjson.UUID, foil, fetch bool) (float64, bool) {
 	return v, v != 0 && time.Since(p.T) <= scryfall.PricingOutdated
 }
 
+// CommitError is returned by Commit if nothing was committed, the staged
+// changes can be committed again. Journal is set if the database was
+// reloaded, the staged changes then refer to cards that are no longer in it
+// and have to be staged again with Journal.Apply.
+type CommitError struct {
+	Err     error
+	Journal *Journal
+}
+
+func (e *CommitError) Error() string { return e.Err.Error() }
+
+func (e *CommitError) Unwrap() error { return e.Err }
+
+// Commit applies the staged changes in s to the database, saves it and
+// appends the changes to the audit log. The database is only locked while
+// saving, changes saved by other sessions in the meantime are merged (see
+// DB.Synced). See CommitError for failures that leave the staged changes
+// uncommitted.
+func (a *App) Commit(s State, file string) (bool, error) {
+	unlock, err := lockDB(file)
+	if err != nil {
+		return false, &CommitError{Err: err}
+	}
+	defer unlock()
+
+	// cards are written to the other database first so a failure can at
+	// worst leave them in both.
+	if err := a.writeSplits(file, s); err != nil {
+		return false, &CommitError{Err: err}
+	}
+
+	journal := NewJournal(s, a.DB)
+	for _, c := range s.Containers {
+		if c.Remove {
+			a.DB.RemoveContainer(c.Name)
+			continue
+		}
+		a.DB.SetContainer(c.Container)
+	}
+
+	snap := newLogSnapshot(a.DB)
+	received := make(map[*DBCard]string)
+	for _, c := range s.Selection {
+		dbCard := FromCard(a.DB, c.Card)
+		dbCard.id, dbCard.added, dbCard.owner, dbCard.loc, dbCard.loan = c.ID, c.Added, c.Owner, c.Location, c.Loan
+		if dbCard.owner == "" {
+			dbCard.owner = a.Owner
+		}
+		dbCard.Tag(c.Tags.Slice())
+		a.DB.Add(dbCard)
+		if c.From != "" {
+			received[dbCard] = c.From
+		}
+	}
+
+	// valued before the given cards are deleted.
+	trades := a.Trades(s, received)
+
+	del := make([]*DBCard, 0, len(s.Delete)+len(s.Split)+len(s.Trading))
+	for _, c := range s.Delete {
+		del = append(del, c.DBCard)
+	}
+	for _, c := range s.Split {
+		del = append(del, c.DBCard)
+	}
+	for _, c := range s.Trading {
+		del = append(del, c.DBCard)
+	}
+	a.DB.Delete(del...)
+
+	for _, r := range s.Remap {
+		if to, ok := a.Cards.ByUUID(r.To); ok && a.DB.IndexOf(r.DBCard) >= 0 {
+			a.DB.Remap(r.DBCard, to)
+		}
+	}
+
+	for _, o := range s.Ownership {
+		if a.DB.IndexOf(o.DBCard) >= 0 {
+			o.SetOwner(o.To)
+		}
+	}
+
+	for _, p := range s.Placement {
+		if a.DB.IndexOf(p.DBCard) >= 0 {
+			p.SetLocation(p.To)
+		}
+	}
+
+	for _, l := range s.Lending {
+		if a.DB.IndexOf(l.DBCard) >= 0 {
+			l.SetLoan(l.Loan)
+		}
+	}
+
+	for _, c := range a.DB.Cards() {
+		c.SetPricing(a.GetFullPricing(c.UUID(), false, false, false))
+	}
+
+	for _, t := range s.Tagging {
+		t.Commit()
+	}
+
+	if a.DB.Dirty() {
+		if err := a.Backups.Commit(file, time.Now()); err != nil {
+			return false, a.discard(err, journal)
+		}
+	}
+
+	// before saving, which adds the changes of other sessions.
+	e := snap.entry(a.DB, s)
+	saved, err := a.DB.Save()
+	if err != nil {
+		return false, a.discard(err, journal)
+	}
+	a.BuildLocalIndex()
+
+	e = e.without(a.DB.Synced())
+	if !e.Empty() {
+		if _, err := AppendLog(auditFile(file), e); err != nil {
+			return saved, fmt.Errorf("database saved but failed to append to the audit log: %w", err)
+		}
+	}
+
+	for _, t := range trades {
+		t = t.without(a.DB.Synced())
+		if len(t.Given) == 0 && len(t.Received) == 0 {
+			continue
+		}
+		if _, err := AppendTrade(tradeFile(file), t); err != nil {
+			return saved, fmt.Errorf("database saved but failed to append to the trade log: %w", err)
+		}
+	}
+	return saved, nil
+}
+
+// discard reloads the database after the staged changes in j were applied
+// to it but could not be saved.
+func (a *App) discard(err error, j Journal) error {
+	db, lerr := LoadDB(a.DB.store)
+	if lerr != nil {
+		// the changes stay in the database and are saved on the next commit.
+		a.BuildLocalIndex()
+		return fmt.Errorf("%w (reloading the database failed as well: %s)", err, lerr)
+	}
+	a.DB = db
+	a.BuildLocalIndex()
+	return &CommitError{Err: err, Journal: &j}
+}
+
+// CardInfo returns the details of c as shown by /info.
+func (a *App) CardInfo(c Card) ([]string, error) {
+	faces := a.Cards.Faces(c)
+	full := make([]mtgjson.Card, len(faces))
+	for i, f := range faces {
+		var err error
+		if full[i], err = f.Full(); err != nil {
+			return nil, err
+		}
+	}
+	card := full[0]
+
+	data := make([]string, 2, 10)
+	data[0] = fmt.Sprintf("%s: %s", card.SetCode, a.Cards.Sets[card.SetCode])
+	if len(full) > 1 {
+		data[0] += fmt.Sprintf(" (%s)", card.Layout)
+	}
+	for _, f := range full {
+		name := f.Name
+		if f.FaceName != "" {
+			name = f.FaceName
+		}
+		var pt string
+		if f.Power != "" && f.Toughness != "" {
+			pt = fmt.Sprintf("%2s/%-2s", f.Power, f.Toughness)
+		}
+		data = append(
+			data,
+			fmt.Sprintf("%-30s %12s %-6s", name, f.ManaCost, pt),
+			"",
+			fmt.Sprintf("Type: %s", strings.Join(f.Types, "|")),
+			"",
+		)
+		data = append(data, strings.Split(f.Text, "\n")...)
+		data = append(data, "")
+	}
+
+	if len(card.Rulings) != 0 {
+		data = append(data, "Rulings:")
+		for _, r := range card.Rulings {
+			data = append(data, "  "+string(r.Date))
+			for _, t := range strings.Split(r.Text, "\n") {
+				data = append(data, "  "+t)
+			}
+		}
+		data = append(data, "")
+	}
+
+	if len(card.Keywords) != 0 {
+		data = append(data, "Keywords: "+strings.Join(card.Keywords, "|"))
+	}
+
+	special := make([]string, 0, 2)
+	if card.IsReserved {
+		special = append(special, "Reserved")
+	}
+	if card.IsPromo {
+		special = append(special, "Promo")
+	}
+	if card.IsAlternative {
+		special = append(special, "Alternative")
+	}
+	if card.IsOversized {
+		special = append(special, "Oversized")
+	}
+	reprint := "Reprint"
+	if !card.IsReprint {
+		reprint = "First print"
+	}
+	special = append(special, reprint)
+
+	if len(special) != 0 {
+		data = append(data, "", strings.Join(special, "|"))
+	}
+
+	data = append(data, "", fmt.Sprintf("Artist: %s", card.Artist))
+
+	return data, nil
+}
+
 func (a *App) colorUniqUUID(uuids []string) []string {
 	list := uniqUUIDPart(uuids)
 	ret := make([]string, len(uuids))
@@ -319,10 +570,11 @@ func (a *App) CardsString(cards []Card, max int, uniq bool) []string {
 	titlePad := strconv.Itoa(longestTitle)
 	bad := a.Colors.Get("bad")
 
+	offset := 0
 	if max > 0 && len(cards) > max {
-		s := len(cards) - max
-		uuids = uuids[s:]
-		cards = cards[s:]
+		offset = len(cards) - max
+		uuids = uuids[offset:]
+		cards = cards[offset:]
 	}
 
 	for i, c := range cards {
@@ -334,11 +586,12 @@ func (a *App) CardsString(cards []Card, max int, uniq bool) []string {
 		l = append(
 			l,
 			fmt.Sprintf(
-				"%s \u2502 %-5s \u2502 %-4d \u2502 %-"+titlePad+"s \u2502%s %-.2f \033[0m",
+				"%4d \u2502 %s \u2502 %s \u2502 %-4d \u2502 %s \u2502%s %-.2f \033[0m",
+				offset+i+1,
 				uuids[i],
-				c.SetCode,
-				a.DB.Count(c.UUID),
-				c.Name,
+				a.Colors.Wrap("set", fmt.Sprintf("%-5s", c.SetCode)),
+				a.DB.Available(c.UUID),
+				a.Colors.Wrap("rarity-"+string(c.Rarity), fmt.Sprintf("%-"+titlePad+"s", c.Name)),
 				pricingClr,
 				pricing,
 			),
@@ -405,7 +658,8 @@ func (a *App) LocalCardsString(cards []LocalCard, max int, uniq bool) []string {
 	for i, c := range cards {
 		rc, ok := a.Cards.ByUUID(c.UUID())
 		if !ok {
-			panic("local mtgjson database seems corrupt")
+			// unknown to mtgjson, see /fsck
+			rc = Card{UUID: c.UUID(), Name: c.Name(), SetCode: c.SetID(), Number: c.Number()}
 		}
 		rcards[i] = rc
 	}
@@ -428,15 +682,12 @@ func (a *App) LocalCardsString(cards []LocalCard, max int, uniq bool) []string {
 		}
 	}
 	titlePad := strconv.Itoa(longestTitle)
-	manaPad := strconv.Itoa(longestMana)
 	kwPad := strconv.Itoa(longestKeywords)
 	typePad := strconv.Itoa(longestType)
 	bad := a.Colors.Get("bad")
 
-	p1 := "%6d \u2502 %s \u2502 %-5s \u2502 %-4d \u2502 %-" +
-		titlePad + "s \u2502 %-" +
-		typePad + "s \u2502 %-" +
-		manaPad + "s \u2502 %-" +
+	p1 := "%6d \u2502 %s \u2502 %s \u2502 %-4d \u2502 %s \u2502 %-" +
+		typePad + "s \u2502 %s%s \u2502 %-" +
 		kwPad + "s "
 	p2 := "\u2502%s %" + pricePad + "s \033[0m\u2502 %s"
 
@@ -455,20 +706,28 @@ func (a *App) LocalCardsString(cards []LocalCard, max int, uniq bool) []string {
 				p1,
 				c.Index+1,
 				uuids[i],
-				c.SetID(),
-				a.DB.Count(c.UUID()),
-				c.Name(),
+				a.Colors.Wrap("set", fmt.Sprintf("%-5s", c.SetID())),
+				a.DB.Available(c.UUID()),
+				a.Colors.Wrap("rarity-"+string(rc.Rarity), fmt.Sprintf("%-"+titlePad+"s", c.Name())),
 				strTypes(rc.Types),
-				rc.ManaCost,
+				a.Colors.Mana(rc.ManaCost),
+				strings.Repeat(" ", longestMana-len(rc.ManaCost)),
 				strKeywords(rc.Keywords),
 			),
 			fmt.Sprintf(
 				p2,
 				pricingClr,
 				prices[i][1:],
-				tagstr,
+				a.Colors.Wrap("tags", tagstr),
 			),
 		}
+		if o := c.Owner(); o != "" && o != a.Owner {
+			// cards of the current owner are not marked.
+			items[1] += " (" + o + ")"
+		}
+		if l := c.Loan(); !l.Empty() {
+			items[1] += a.Colors.Wrap("bad", " lent to "+l.To)
+		}
 		if p1Len == 0 {
 			p1Len = runewidth.StringWidth(csiRE.ReplaceAllString(items[0], ""))
 		}
@@ -504,7 +763,6 @@ func main() {
 	dir := filepath.Join(cacheDir, "gomtg")
 	exportDir := filepath.Join(dir, "exports")
 	dest := filepath.Join(dir, "v5-all-printings")
-	imagePath := filepath.Join(dir, "options.jpg")
 	imageDir := filepath.Join(dir, "images")
 	var skipIntro bool
 	var imageCommand string
@@ -512,19 +770,41 @@ func main() {
 	var imageRefreshCommand string
 	var imageAutoView int
 	var imageNoCache bool
-	var dbFile string
+	var imageCacheSize int
+	var offline bool
+	var dbFile, dbFormat string
+	var backups Backups
+	var owner string
 	var noPricing bool
 	var currency string
-	colors := Colors{
-		"bad":    {0, 2, 1},
-		"good":   {1, 3, 1},
-		"high":   {8, 1, 1},
-		"low":    {0, 1, 0},
-		"status": {3, 1, 0},
-	}
-	colorStr := colors.Encode()
+	var colorStr, theme, colorMode string
 	var testColors bool
+	var batch bool
+	var batchFile string
+	var outputFormat string
+	var webAddr string
+	var graphicsFlag string
+	var collage Collage
+	var collageFormat string
+	var collageOverlays string
+	var sheet Sheet
+	var sheetPaper, sheetFormat string
+	var configFile, profile string
+	var config ConfigSection
+
+	defaultConfig := ""
+	if configDir, err := os.UserConfigDir(); err == nil {
+		defaultConfig = filepath.Join(configDir, "gomtg", "config.json")
+	}
 
+	flag.StringVar(
+		&configFile,
+		"config",
+		defaultConfig,
+		`json file with default flag values, command aliases, macros and profiles.
+flags given on the command line take precedence.`,
+	)
+	flag.StringVar(&profile, "profile", "", "config profile to use on top of the defaults in -config")
 	flag.BoolVar(&skipIntro, "n", false, "Skip intro")
 	flag.StringVar(
 		&imageCommand,
@@ -552,14 +832,240 @@ Only useful if your viewer auto reloads updated images (imv and feh for example)
 ignored if -ia is passed. {fn} is replaced by the filename and {pid} with the process id.`,
 	)
 	flag.BoolVar(&imageNoCache, "no-cache", false, fmt.Sprintf("disable image caching (in '%s')", imageDir))
+	flag.IntVar(&imageCacheSize, "cache-size", 1024, "maximum size of the image cache in MiB, least recently used images are removed first (0 = unlimited)")
+	flag.BoolVar(&offline, "offline", false, "never use the network, show placeholders for images that are not cached and disable pricing updates")
 	flag.StringVar(&dbFile, "db", "gomtg.db", "Database file to use")
-	flag.StringVar(&colorStr, "c", colorStr, "change default colors (key:bg:fg:bold[,key:value...])")
+	flag.StringVar(
+		&dbFormat,
+		"db-format",
+		string(FormatJSON),
+		"format of new databases: json or sqlite, the format of existing databases is detected (see the migrate subcommand)",
+	)
+	flag.StringVar(&owner, "owner", currentUser(), "owner of the cards you add, see /owner")
+	flag.IntVar(&backups.Keep, "backups", 10, "amount of backups of the database taken before each /commit to keep (in '<db>.backups', 0 = disabled)")
+	flag.IntVar(&backups.Daily, "backups-daily", 7, "amount of daily backups of the database to keep (0 = disabled)")
+	flag.IntVar(&backups.Weekly, "backups-weekly", 4, "amount of weekly backups of the database to keep (0 = disabled)")
+	flag.StringVar(
+		&colorStr,
+		"c",
+		"",
+		`change colors of the -theme (key:bg:fg:bold[,key:value...])
+bg and fg are 0 (default), 1-8 or black, red, green, yellow, blue, magenta, cyan, white
+or @0-@255 for 256 color terminals or #rrggbb for truecolor terminals.
+keys: bad, good, high, low, status, set, tags,
+      rarity-<common|uncommon|rare|mythic|special|bonus> and mana-<w|u|b|r|g|c>
+e.g.: -c 'low:0:@242:0,rarity-mythic:0:#e8632b:1'`,
+	)
+	flag.StringVar(&theme, "theme", "default", "color theme: default, 256 or truecolor")
+	flag.StringVar(
+		&colorMode,
+		"color",
+		string(ColorAuto),
+		"use colors: auto, always or never. auto disables colors if NO_COLOR is set or output is not a terminal",
+	)
 	flag.BoolVar(&testColors, "color-test", testColors, "test colors")
+	flag.StringVar(
+		&graphicsFlag,
+		"ig",
+		string(GraphicsAuto),
+		`Draw images inline in the terminal: auto, none, kitty, iterm, sixel or blocks.
+blocks uses unicode half blocks and works in any truecolor terminal.
+auto detects the protocol but only if no -i command is given.`,
+	)
 	flag.IntVar(&imageAutoView, "iav", 0, "if value > 0: Show last added card in image viewer and render collage if amount of options <= value")
+	flag.IntVar(&collage.Columns, "collage-cols", 0, "amount of columns in /images collages (0 = square grid)")
+	flag.IntVar(&collage.MaxWidth, "collage-width", 0, "maximum width in pixels of /images collages (0 = unlimited)")
+	flag.IntVar(&collage.PerPage, "collage-per-page", 100, "amount of cards per /images page")
+	flag.StringVar(&collage.Size, "collage-size", "normal", "card image size in /images collages: small, normal or large")
+	flag.StringVar(&collageFormat, "collage-format", string(FormatJPEG), "/images output format: jpeg, png or webp")
+	flag.StringVar(
+		&collageOverlays,
+		"collage-overlay",
+		string(OverlayUUID),
+		"comma separated fields drawn over each card in /images collages: uuid, name, set, price, count (not lent), tags and/or index",
+	)
+	flag.StringVar(&sheetPaper, "print-paper", string(PaperA4), "/print and /binder paper size: a4 or letter")
+	flag.IntVar(&sheet.DPI, "print-dpi", 300, "/print and /binder resolution")
+	flag.StringVar(&sheetFormat, "print-format", string(FormatPDF), "/print and /binder output format: pdf or png")
 	flag.BoolVar(&noPricing, "np", false, "Disable automatically pricing newly added cards")
 	flag.StringVar(&currency, "currency", "EUR", "EUR or USD")
+	flag.BoolVar(
+		&batch,
+		"batch",
+		false,
+		`Run commands non-interactively and exit, exits with a non-zero status on the first error.
+Commands are read from the arguments, the file passed to -f or stdin (in that order).`,
+	)
+	flag.StringVar(&batchFile, "f", "", "File to read -batch commands from (- for stdin)")
+	flag.StringVar(&outputFormat, "o", string(OutputText), "-batch output format: text or json")
+	flag.StringVar(&webAddr, "web", "", "Serve a web ui on this address (e.g.: 127.0.0.1:7358) showing the collection and current options")
 	flag.Parse()
 
+	{
+		given := make(map[string]struct{})
+		flag.Visit(func(f *flag.Flag) { given[f.Name] = struct{}{} })
+		_, required := given["config"]
+		cfg, err := LoadConfig(configFile, required)
+		if err == nil {
+			config, err = cfg.Profile(profile)
+		}
+		if err == nil {
+			if _, ok := config.Flags["config"]; ok {
+				err = errors.New("config: the config file can not be set from the config file")
+			}
+			if _, ok := config.Flags["profile"]; ok {
+				err = errors.New("config: profiles can not be set from the config file")
+			}
+		}
+		if err == nil {
+			err = config.ApplyFlags(flag.CommandLine, given)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
+	if !StorageFormat(dbFormat).Valid() {
+		fmt.Fprintln(os.Stderr, "invalid database format")
+		os.Exit(1)
+	}
+
+	if !ColorMode(colorMode).Valid() {
+		fmt.Fprintln(os.Stderr, "invalid color mode")
+		os.Exit(1)
+	}
+
+	format := Output(outputFormat)
+	if !format.Valid() {
+		fmt.Fprintln(os.Stderr, "invalid output format")
+		os.Exit(1)
+	}
+
+	graphics := Graphics(graphicsFlag)
+	if !graphics.Valid() {
+		fmt.Fprintln(os.Stderr, "invalid graphics protocol")
+		os.Exit(1)
+	}
+
+	collage.Format = ImageFormat(collageFormat)
+	if !collage.Format.Valid() {
+		fmt.Fprintln(os.Stderr, "invalid collage format")
+		os.Exit(1)
+	}
+	if _, ok := ImageSizes[collage.Size]; !ok {
+		fmt.Fprintln(os.Stderr, "invalid collage size")
+		os.Exit(1)
+	}
+	if collage.Overlays, err = ParseOverlays(collageOverlays); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	imagePath := filepath.Join(dir, "options"+collage.Format.Ext())
+
+	sheet.Paper, sheet.Format = Paper(sheetPaper), ImageFormat(sheetFormat)
+	if !sheet.Paper.Valid() {
+		fmt.Fprintln(os.Stderr, "invalid paper size")
+		os.Exit(1)
+	}
+	if sheet.Format != FormatPDF && sheet.Format != FormatPNG {
+		fmt.Fprintln(os.Stderr, "invalid print format")
+		os.Exit(1)
+	}
+	if sheet.DPI < 72 || sheet.DPI > 1200 {
+		fmt.Fprintln(os.Stderr, "print dpi should be between 72 and 1200")
+		os.Exit(1)
+	}
+
+	var subcommand string
+	serveFlags := flag.NewFlagSet("serve", flag.ExitOnError)
+	serveAddr := serveFlags.String("addr", "127.0.0.1:7357", "Address to listen on")
+	migrateFlags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	migrateFormat := migrateFlags.String("to", "", "format to convert to: json or sqlite (default: the one the database is not in)")
+	migrateFlags.Usage = func() {
+		fmt.Fprintln(migrateFlags.Output(), "Usage: gomtg [-db <file>] migrate [-to json|sqlite] <destination>")
+		fmt.Fprintln(migrateFlags.Output(), "Copies the database to a new file in another format.")
+		migrateFlags.PrintDefaults()
+	}
+	diffFlags := flag.NewFlagSet("diff", flag.ExitOnError)
+	diffLevel := diffFlags.String("by", string(DiffPrinting), "match cards by printing or oracle (any printing of the same card)")
+	diffOutput := diffFlags.String("o", "table", "output format: table or json")
+	diffFlags.Usage = func() {
+		fmt.Fprintln(diffFlags.Output(), "Usage: gomtg [-db <file>] diff [-by printing|oracle] [-o table|json] [<from>] <to>")
+		fmt.Fprintln(diffFlags.Output(), "Compares two databases, <from> defaults to -db.")
+		fmt.Fprintln(diffFlags.Output(), "Both can also be the name of a snapshot or backup of -db (see /restore).")
+		diffFlags.PrintDefaults()
+	}
+	mergeFlags := flag.NewFlagSet("merge", flag.ExitOnError)
+	mergeFlags.Usage = func() {
+		fmt.Fprintln(mergeFlags.Output(), "Usage: gomtg [-db <file>] merge <file>[=<tag>]...")
+		fmt.Fprintln(mergeFlags.Output(), "Adds all cards in each file to -db, optionally tagging them with <tag> (e.g.: owner-alice).")
+		mergeFlags.PrintDefaults()
+	}
+	splitFlags := flag.NewFlagSet("split", flag.ExitOnError)
+	splitSet := splitFlags.String("set", "", "only split cards in this set")
+	splitFlags.Usage = func() {
+		fmt.Fprintln(splitFlags.Output(), "Usage: gomtg [-db <file>] split [-set <set>] <destination> <query>...")
+		fmt.Fprintln(splitFlags.Output(), "Moves all cards in -db matching the collection search <query> to <destination>.")
+		splitFlags.PrintDefaults()
+	}
+	if flag.NArg() != 0 {
+		switch flag.Arg(0) {
+		case "serve":
+			subcommand = flag.Arg(0)
+			skipIntro = true
+			_ = serveFlags.Parse(flag.Args()[1:])
+		case "migrate":
+			subcommand = flag.Arg(0)
+			skipIntro = true
+			_ = migrateFlags.Parse(flag.Args()[1:])
+			if migrateFlags.NArg() != 1 {
+				migrateFlags.Usage()
+				os.Exit(1)
+			}
+		case "merge":
+			subcommand = flag.Arg(0)
+			skipIntro = true
+			_ = mergeFlags.Parse(flag.Args()[1:])
+			if mergeFlags.NArg() == 0 {
+				mergeFlags.Usage()
+				os.Exit(1)
+			}
+		case "split":
+			subcommand = flag.Arg(0)
+			skipIntro = true
+			_ = splitFlags.Parse(flag.Args()[1:])
+			if splitFlags.NArg() < 2 {
+				splitFlags.Usage()
+				os.Exit(1)
+			}
+		case "diff":
+			subcommand = flag.Arg(0)
+			skipIntro = true
+			_ = diffFlags.Parse(flag.Args()[1:])
+			if diffFlags.NArg() < 1 || diffFlags.NArg() > 2 {
+				diffFlags.Usage()
+				os.Exit(1)
+			}
+			if !DiffLevel(*diffLevel).Valid() || (*diffOutput != "table" && *diffOutput != "json") {
+				diffFlags.Usage()
+				os.Exit(1)
+			}
+			// progress is not mixed with the diff on stdout.
+			stdout = io.Discard
+		}
+	}
+	if batch {
+		skipIntro = true
+		stdout = io.Discard
+	}
+	if graphics == GraphicsAuto {
+		graphics = GraphicsNone
+		if imageCommand == "" && subcommand == "" && !batch {
+			graphics = DetectGraphics()
+		}
+	}
+
 	currency = strings.ToLower(currency)
 	if currency != "eur" && currency != "usd" {
 		fmt.Fprintln(os.Stderr, "invalid currency")
@@ -572,28 +1078,30 @@ ignored if -ia is passed. {fn} is replaced by the filename and {pid} with the pr
 	_ = os.MkdirAll(filepath.Dir(dbFile), 0700)
 	_ = os.MkdirAll(imageDir, 0700)
 
-	imageGetter := func(url string) (image.Image, error) {
-		return getImageCached(url, imageDir)
+	if offline {
+		noPricing = true
+	}
+
+	imageCache, err := NewImageCache(imageDir, int64(imageCacheSize)<<20, offline)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read image cache: %s\n", err)
 	}
-	if imageNoCache {
+	imageGetter := ImageGetter(imageCache.Get)
+	if imageNoCache && !offline {
 		imageGetter = func(url string) (image.Image, error) {
 			return getImage(url)
 		}
 	}
 
-	lockFile, err := filepath.Abs(dbFile + ".lock")
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Could not get absolute path to %s", dbFile)
-		os.Exit(1)
-	}
-	locker, err := lockfile.New(lockFile)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Lockfile error: %s", err.Error())
-		os.Exit(1)
+	// stdio might not be a terminal in -batch mode
+	var term console.Console
+	for _, f := range []*os.File{os.Stderr, os.Stdout, os.Stdin} {
+		if c, err := console.ConsoleFromFile(f); err == nil {
+			term = c
+			break
+		}
 	}
 
-	term := console.Current()
-
 	sigCh := make(chan os.Signal, 10)
 	cancelCh := make(chan struct{}, 1)
 	signal.Notify(
@@ -604,10 +1112,21 @@ ignored if -ia is passed. {fn} is replaced by the filename and {pid} with the pr
 		syscall.SIGTERM,
 		syscall.SIGQUIT,
 	)
+	var editor *Editor
+	var store Storage
+	var unlockJournal func()
 	cleanup := func() {
-		fmt.Println("\033[?25h")
+		fmt.Fprintln(stdout, "\033[?25h")
+		if unlockJournal != nil {
+			unlockJournal()
+		}
+		if editor != nil {
+			_ = editor.Close()
+		}
 		killViewer()
-		_ = locker.Unlock()
+		if store != nil {
+			_ = store.Close()
+		}
 	}
 	bye := func() {
 		cleanup()
@@ -622,9 +1141,18 @@ ignored if -ia is passed. {fn} is replaced by the filename and {pid} with the pr
 		os.Exit(1)
 	}
 
-	ncolors, err := DecodeColors(colorStr)
+	themeColors, ok := Themes[theme]
+	if !ok {
+		exit(fmt.Errorf("no such theme '%s'", theme))
+	}
+	colors, err := DecodeColors(colorStr)
 	exit(err)
-	colors = ncolors.Merge(colors)
+	colors = colors.Merge(themeColors)
+	_, ttyErr := console.ConsoleFromFile(os.Stdout)
+	plain := !ColorMode(colorMode).Enabled(ttyErr == nil)
+	if plain {
+		colors = Colors{}
+	}
 	if testColors {
 		d := map[string]string{
 			"bad":    "an error",
@@ -632,10 +1160,22 @@ ignored if -ia is passed. {fn} is replaced by the filename and {pid} with the pr
 			"high":   "highlighted",
 			"low":    "nobody cares about this",
 			"status": "mode:test set: selected:>3000",
+			"set":    "M10",
+			"tags":   "shoebox,nm",
 		}
-		for k, v := range d {
-			fmt.Printf("%s %s \033[0m\n", colors.Get(k), v)
+		keys := make([]string, 0, len(themeColors))
+		for k := range themeColors {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			v, ok := d[k]
+			if !ok {
+				v = k
+			}
+			fmt.Printf("%-16s %s\n", k, colors.Wrap(k, " "+v+" "))
 		}
+		fmt.Printf("%-16s %s\n", "mana", colors.Mana("{X}{2}{W}{U}{B}{R}{G}{W/U}{G/P}{C}"))
 		os.Exit(0)
 	}
 
@@ -650,42 +1190,27 @@ ignored if -ia is passed. {fn} is replaced by the filename and {pid} with the pr
 		}
 	}()
 
-	exit(progress("Lock database", func() error {
-		try := 0
-		for {
-			try++
-			if err := locker.TryLock(); err != nil {
-				if err != lockfile.ErrNotExist {
-					return fmt.Errorf("Failed to get lock: %w", err)
-				}
-				time.Sleep(time.Millisecond * 50)
-				if try > 20 {
-					return errors.New("Could not claim lock")
-				}
-				continue
-			}
-			break
-		}
-		return nil
-	}))
-
 	app := NewApp(currency)
 	app.Scry = scryfall.New(nil, time.Second*10)
 	app.Colors = colors
-
-	var localFuzz *fuzzy.Index
-
-	rebuildLocalFuzz := func() {
-		list := make([]string, 0)
-		for _, card := range app.DB.Cards() {
-			list = append(list, card.Name())
-		}
-		localFuzz = fuzzy.NewIndex(2, list)
-	}
+	app.Offline = offline
+	backups.Dir = backupDir(dbFile)
+	app.Backups = backups
+	app.Owner = owner
 
 	exit(progress("Load database", func() error {
-		var err error
-		app.DB, err = LoadDB(dbFile)
+		// loading might upgrade the database.
+		unlock, err := lockDB(dbFile)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+		if store, err = OpenStorage(dbFile, StorageFormat(dbFormat)); err != nil {
+			return err
+		}
+		if app.DB, err = LoadDB(store); err != nil {
+			return err
+		}
 		app.pricing.mutex.Lock()
 		for _, card := range app.DB.Cards() {
 			p := card.Pricing()
@@ -694,28 +1219,66 @@ ignored if -ia is passed. {fn} is replaced by the filename and {pid} with the pr
 			}
 		}
 		app.pricing.mutex.Unlock()
-		return err
+		return nil
 	}))
+	if u, ok := store.Upgraded(); ok {
+		fmt.Fprintln(os.Stderr, u)
+	}
+
+	if subcommand == "migrate" {
+		dst := migrateFlags.Arg(0)
+		exit(progress("Migrate database", func() error {
+			from, err := DetectFormat(dbFile, StorageFormat(dbFormat))
+			if err != nil {
+				return err
+			}
+			to := StorageFormat(*migrateFormat)
+			switch {
+			case to == "" && from == FormatJSON:
+				to = FormatSQLite
+			case to == "":
+				to = FormatJSON
+			case !to.Valid():
+				return fmt.Errorf("invalid database format '%s'", to)
+			}
+			if _, err := os.Stat(dst); err == nil {
+				return fmt.Errorf("'%s' already exists", dst)
+			}
+
+			s, err := OpenStorage(dst, to)
+			if err != nil {
+				return err
+			}
+			err = app.DB.Migrate(s)
+			if cerr := s.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				os.Remove(dst)
+			}
+			return err
+		}))
+		fmt.Fprintf(stdout, "Migrated %d cards to '%s'\n", len(app.DB.Cards()), dst)
+		bye()
+	}
 
 	exit(progress("Create local index", func() error {
-		rebuildLocalFuzz()
+		app.BuildLocalIndex()
 		return nil
 	}))
 
-	var fuzz *fuzzy.Index
 	reloadData := func(refresh bool) error {
+		if refresh && offline {
+			return errors.New("can not update card data in offline mode")
+		}
 		var err error
-		app.Cards, err = loadData(dest, refresh)
+		app.Cards, err = loadData(dest, refresh, offline)
 		if err != nil {
 			return err
 		}
 
 		err = progress("Create full index", func() error {
-			list := make([]string, 0)
-			for _, card := range app.Cards.Cards {
-				list = append(list, card.Name)
-			}
-			fuzz = fuzzy.NewIndex(2, list)
+			app.BuildIndex()
 			return nil
 		})
 		if err != nil {
@@ -726,26 +1289,122 @@ ignored if -ia is passed. {fn} is replaced by the filename and {pid} with the pr
 
 	exit(reloadData(false))
 
-	state := State{Mode: ModeCollection, Sort: SortIndex}
-	output := make([]string, 1, 30)
-
-	print := func(msg ...string) {
-		output = append(output, msg...)
+	// readDB loads the database in file or a snapshot or backup by that
+	// name.
+	readDB := func(file string) (*DB, error) {
+		if _, err := os.Stat(file); err != nil {
+			f, ferr := app.Backups.Find(file)
+			if ferr != nil {
+				return nil, fmt.Errorf("no such database, snapshot or backup '%s'", file)
+			}
+			file = f.File
+		}
+		return ReadDBFile(file)
 	}
-	printDiv := func() {
-		max := 0
-		for _, o := range output {
-			l := runewidth.StringWidth(csiRE.ReplaceAllString(o, ""))
-			if l > max {
-				max = l
+
+	if subcommand == "merge" {
+		exists := func(file string) bool {
+			if _, err := os.Stat(file); err == nil {
+				return true
+			}
+			_, err := app.Backups.Find(file)
+			return err == nil
+		}
+		var s State
+		for _, arg := range mergeFlags.Args() {
+			file, tag := splitMergeArg(arg, exists)
+			if sameFile(file, dbFile) {
+				exit(fmt.Errorf("can not merge '%s' into itself", file))
 			}
+			db, err := readDB(file)
+			exit(err)
+			s.Selection = append(s.Selection, app.MergeSelection(db, tag)...)
+			s.Containers = append(s.Containers, app.MergeContainers(s, db)...)
+		}
+		exit(progress("Merge databases", func() error {
+			_, err := app.Commit(s, dbFile)
+			return err
+		}))
+		fmt.Fprintf(stdout, "Merged %d cards into '%s'\n", len(s.Selection), dbFile)
+		bye()
+	}
+
+	if subcommand == "split" {
+		dst := splitFlags.Arg(0)
+		local := app.SearchLocal(splitFlags.Args()[1:], mtgjson.SetID(strings.ToUpper(*splitSet)))
+		if len(local) == 0 {
+			exit(errors.New("no cards match the query"))
 		}
+		s := State{Split: NewSplit(local, dst)}
+		exit(progress("Split database", func() error {
+			_, err := app.Commit(s, dbFile)
+			return err
+		}))
+		fmt.Fprintf(stdout, "Moved %d cards to '%s'\n", len(local), dst)
+		bye()
+	}
 
-		n := make([]rune, max)
-		for i := range n {
-			n[i] = '_'
+	if subcommand == "diff" {
+		from, to := dbFile, diffFlags.Arg(0)
+		fromDB := app.DB
+		if diffFlags.NArg() == 2 {
+			from, to = diffFlags.Arg(0), diffFlags.Arg(1)
+			var err error
+			fromDB, err = readDB(from)
+			exit(err)
+		}
+		toDB, err := readDB(to)
+		exit(err)
+
+		d := app.Diff(fromDB, toDB, DiffLevel(*diffLevel))
+		d.From, d.To = from, to
+		if *diffOutput == "json" {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			exit(enc.Encode(d))
+			bye()
 		}
-		print(string(n))
+		for _, l := range d.Table() {
+			fmt.Println(l)
+		}
+		bye()
+	}
+
+	if subcommand == "serve" {
+		server := NewServer(app, dbFile)
+		mux := http.NewServeMux()
+		mux.Handle("/api/", server.Handler())
+		mux.Handle("/", NewWeb(app, imageCache, server.Do).Handler())
+		fmt.Fprintf(stdout, "Listening on http://%s/api\n", *serveAddr)
+		exit(http.ListenAndServe(*serveAddr, mux))
+	}
+
+	state := State{Mode: ModeCollection, Sort: SortIndex}
+	output := make([]string, 0, 30)
+	screen := Screen{ImageClear: graphics.Clear()}
+	pager := false
+	var inlineImage image.Image
+	var inlineKey string
+	var inlineRows []string
+
+	var view batchView
+	errs := make([]string, 0)
+
+	var web *Web
+	webCh := make(chan func())
+	if webAddr != "" && !batch {
+		web = NewWeb(app, imageCache, func(f func()) {
+			done := make(chan struct{})
+			webCh <- func() { f(); close(done) }
+			<-done
+		})
+		go func() {
+			exit(http.ListenAndServe(webAddr, web.Handler()))
+		}()
+	}
+
+	print := func(msg ...string) {
+		output = append(output, msg...)
 	}
 	printAlert := func(msg string) {
 		clr := app.Colors.Get("good")
@@ -756,18 +1415,112 @@ ignored if -ia is passed. {fn} is replaced by the filename and {pid} with the pr
 			return
 		}
 
+		if batch {
+			errs = append(errs, err.Error())
+		}
+		if batch && format == OutputJSON {
+			return
+		}
 		clr := app.Colors.Get("bad")
 		print(fmt.Sprintf("%s%s\033[0m", clr, err.Error()))
 	}
 
-	flush := func() {
-		fmt.Print("\033[2J\033[0;0H")
-		output[0] = state.StringShort(app) + "\n"
-		fmt.Print(strings.Join(output, "\n"))
-		output = make([]string, 1, 30)
+	termSize := func() (int, int) {
+		if term == nil {
+			return 80, 24
+		}
+		dims, err := term.Size()
+		if err != nil || dims.Width == 0 || dims.Height == 0 {
+			return 80, 24
+		}
+		return int(dims.Width), int(dims.Height)
+	}
+
+	render := func() {
+		width, height := termSize()
+		screen.Status = state.StringShort(app)
+		screen.Image = ""
+		if !pager && inlineImage != nil {
+			screen.Rows, screen.Footer = nil, ""
+			h := screen.PaneHeight(height)
+			cw, ch := cellSize(os.Stdout)
+			key := fmt.Sprintf("%dx%d %dx%d", width, h, cw, ch)
+			if key != inlineKey {
+				rows, err := graphics.Render(inlineImage, width, h, cw, ch)
+				if err != nil {
+					rows = []string{err.Error()}
+				}
+				inlineKey, inlineRows = key, rows
+			}
+			screen.Cursor, screen.Offset = -1, 0
+			screen.Rows = inlineRows
+			if graphics.Inline() {
+				screen.Rows, screen.Image = nil, inlineRows[0]
+			}
+		} else if !pager {
+			screen.Rows, screen.Footer = nil, ""
+			switch state.Mode {
+			case ModeCollection:
+				rows := app.LocalCardsString(state.Local, 0, true)
+				if len(rows) != 0 {
+					screen.Rows, screen.Footer = rows[:len(rows)-1], rows[len(rows)-1]
+				}
+			case ModeAdd:
+				screen.Rows = app.CardsString(state.Selection.Cards(), 0, true)
+			default:
+				screen.Rows = app.CardsString(state.Options, 0, true)
+			}
+			screen.Cursor, screen.Offset = state.Cursor, state.PageOffset
+			screen.Clamp(height)
+			state.Cursor, state.PageOffset = screen.Cursor, screen.Offset
+		}
+		if web != nil {
+			web.Publish(state.Options)
+		}
+
+		_ = screen.Render(stdout, width, height)
+		if editor != nil {
+			editor.Refresh()
+		}
+	}
+
+	flush := func() {
+		if batch {
+			return
+		}
+		_, height := termSize()
+		screen.Messages = output
+		pager = len(output) > MaxMessages(height)
+		if pager {
+			screen.Rows, screen.Footer = output, ""
+			screen.Cursor, screen.Offset = -1, 0
+			screen.Messages = []string{
+				fmt.Sprintf(
+					"%s %d lines, PgUp/PgDn to scroll, enter to return \033[0m",
+					app.Colors.Get("good"),
+					len(output),
+				),
+			}
+		}
+		output = make([]string, 0, 30)
+		render()
+	}
+
+	scroll := func(n int, page bool) {
+		_, height := termSize()
+		if page {
+			n *= screen.PaneHeight(height)
+		}
+		if pager {
+			screen.ScrollView(n, height)
+		} else {
+			state.Cursor += n
+		}
+		render()
 	}
 
 	queue := []State{state}
+	var redo []State
 
 	modifyState := func(undoable bool, cb func(s State) State) {
 		ostate := state
@@ -775,13 +1528,69 @@ ignored if -ia is passed. {fn} is replaced by the filename and {pid} with the pr
 		if state.PrevMode != ostate.Mode && ostate.Mode.ValidInput() {
 			state.PrevMode = ostate.Mode
 			state.PageOffset = 0
+			state.Cursor = 0
 		}
 		if undoable && !ostate.Equal(state) {
 			queue = append(queue, state)
+			redo = nil
 		}
 	}
 
 	lastImageListID := ""
+	lastImagePage := 0
+	var lastImage image.Image
+	// collageLabels returns the overlay lines for each card, local is
+	// optional and offset is the position of the first card in the view
+	// (< 0 to omit the index if local is nil).
+	collageLabels := func(cards []Card, local []LocalCard, offset int) [][]string {
+		labels := make([][]string, len(cards))
+		for i, c := range cards {
+			var lc *LocalCard
+			if i < len(local) {
+				lc = &local[i]
+			}
+			var name, tags string
+			info := make([]string, 0, 4)
+			for _, o := range collage.Overlays {
+				switch o {
+				case OverlayName:
+					name = c.Name
+				case OverlayIndex:
+					switch {
+					case lc != nil:
+						info = append(info, fmt.Sprintf("#%d", lc.Index+1))
+					case offset >= 0:
+						info = append(info, fmt.Sprintf("#%d", offset+i+1))
+					}
+				case OverlaySet:
+					info = append(info, string(c.SetCode))
+				case OverlayCount:
+					info = append(info, fmt.Sprintf("x%d", app.DB.Available(c.UUID)))
+				case OverlayPrice:
+					p, _ := app.GetPricing(c.UUID, lc != nil && lc.Foil(), false)
+					info = append(info, fmt.Sprintf("%.2f %s", p, strings.ToUpper(currency)))
+				case OverlayTags:
+					if lc != nil {
+						tags = strings.Join(lc.Tags(), " ")
+					}
+				}
+			}
+			for _, l := range []string{name, strings.Join(info, "  "), tags} {
+				if l != "" {
+					labels[i] = append(labels[i], l)
+				}
+			}
+		}
+		return labels
+	}
+	// viewImage shows img inline if enabled and spawns the external viewer
+	// for imagePath.
+	viewImage := func(img image.Image) error {
+		if graphics != GraphicsNone {
+			inlineImage, inlineKey = img, ""
+		}
+		return spawnViewer(imageCommand, imageRefreshCommand, imageAutoReload, imagePath)
+	}
 	printSets := func(filter string) {
 		filter = strings.ToLower(filter)
 		list := make([]string, 0, len(app.Cards.Sets))
@@ -798,38 +1607,71 @@ ignored if -ia is passed. {fn} is replaced by the filename and {pid} with the pr
 		}
 	}
 
-	printSkipped := func(n, max int) {
-		if max == 0 || n <= max {
+	// printOptions sorts the current options and moves the cursor to the
+	// last one, they are rendered in the result pane on the next flush.
+	printOptions := func() {
+		view.Options = true
+		if state.Mode == ModeCollection {
+			state.SortLocal(app)
+			state.Cursor = len(state.Local)
 			return
 		}
-		printAlert(
-			fmt.Sprintf(
-				"Skipped %d cards, showing last %d",
-				n-max,
-				max,
-			),
-		)
+		state.SortOptions(app)
+		state.Cursor = len(state.Options)
 	}
 
-	printOptions := func() {
-		max := 0
-		if !state.Filtered {
-			max = 20
-			dims, err := term.Size()
-			h := int(dims.Height - 10)
-			if err == nil && h > max {
-				max = h
+	paneCards := func() []Card {
+		switch state.Mode {
+		case ModeCollection:
+			list := make([]Card, 0, len(state.Local))
+			for _, c := range state.Local {
+				rc, _ := app.Cards.ByUUID(c.UUID())
+				list = append(list, rc)
 			}
+			return list
+		case ModeAdd:
+			return state.Selection.Cards()
 		}
-		if state.Mode == ModeCollection {
-			state.SortLocal(app)
-			print(app.LocalCardsString(state.Local, max, true)...)
-			printSkipped(len(state.Local), max)
-			return
+		return state.Options
+	}
+
+	// writeSheets renders the pages of layout to exportDir one at a time.
+	writeSheets := func(name string, layout func(progress func(n, total int), page func(*image.NRGBA) error) error) error {
+		w, err := NewSheetWriter(sheet, exportDir, name)
+		if err != nil {
+			return err
 		}
-		state.SortOptions(app)
-		print(app.CardsString(state.Options, max, true)...)
-		printSkipped(len(state.Options), max)
+		progress := func(i, total int) {
+			print(fmt.Sprintf("Downloaded %02d/%02d", i, total))
+			flush()
+		}
+		if err := layout(progress, w.Page); err != nil {
+			w.Abort()
+			return err
+		}
+		files, err := w.Close()
+		if err != nil {
+			w.Abort()
+			return err
+		}
+		printAlert(fmt.Sprintf("exported %d page(s) to: %s", w.Pages(), strings.Join(files, ", ")))
+		return nil
+	}
+
+	cursorCard := func() (Card, error) {
+		cards := paneCards()
+		if pager || state.Cursor < 0 || state.Cursor >= len(cards) {
+			return Card{}, errors.New("no card under cursor")
+		}
+		return cards[state.Cursor], nil
+	}
+
+	cursorLocal := func() (LocalCard, error) {
+		if pager || state.Mode != ModeCollection ||
+			state.Cursor < 0 || state.Cursor >= len(state.Local) {
+			return LocalCard{}, errors.New("no card under cursor")
+		}
+		return state.Local[state.Cursor], nil
 	}
 
 	lastAdded := make([]Card, 0)
@@ -841,8 +1683,20 @@ ignored if -ia is passed. {fn} is replaced by the filename and {pid} with the pr
 			sel := NewSelection(cards)
 			for i := range sel {
 				sel[i].Tags.Add(state.Tags...)
+				sel[i].From = s.TradeWith
+			}
+			if s.Place != "" {
+				slots, err := s.FreeSlots(app.DB, s.Place, 1, len(sel))
+				if err != nil {
+					printErr(fmt.Errorf("not placing the added cards: %w", err))
+					slots = nil
+				}
+				for i, slot := range slots {
+					sel[i].Location = Location{s.Place, slot}
+				}
 			}
 			s.Selection = append(s.Selection, sel...)
+			s.Cursor = len(s.Selection)
 			return s
 		})
 
@@ -870,19 +1724,83 @@ ignored if -ia is passed. {fn} is replaced by the filename and {pid} with the pr
 		if imageAutoView <= 0 {
 			return
 		}
-		err := genImages(cards, imagePath, imageGetter, func(i, total int) {})
+		img, err := genImages(
+			cards,
+			collageLabels(cards, nil, -1),
+			imagePath,
+			collage,
+			imageGetter,
+			func(i, total int) {},
+		)
 		if err != nil {
 			printErr(err)
 			return
 		}
 		lastImageListID = ""
-		err = spawnViewer(imageCommand, imageRefreshCommand, imageAutoReload, imagePath)
+		err = viewImage(img)
 		if err != nil {
 			printErr(err)
 		}
 	}
 
+	selectOptions := func(line string) ([]Card, error) {
+		fields := strings.FieldsFunc(line, func(r rune) bool {
+			return r == ',' || r == ' '
+		})
+		sel := make([]Card, 0, len(fields))
+		for _, f := range fields {
+			if f == "*" {
+				sel = append(sel, state.Options...)
+				continue
+			}
+			if f == "." {
+				c, err := cursorCard()
+				if err != nil {
+					return nil, err
+				}
+				sel = append(sel, c)
+				continue
+			}
+
+			// positions are prefixed so digits can still be uuid fragments.
+			if strings.HasPrefix(f, "#") {
+				ints, ok := intRange(f[1:])
+				if !ok {
+					return nil, fmt.Errorf("invalid position '%s'", f)
+				}
+				for _, i := range ints {
+					if i < 1 || i > len(state.Options) {
+						return nil, fmt.Errorf("no card at position %d", i)
+					}
+					sel = append(sel, state.Options[i-1])
+				}
+				continue
+			}
+
+			var match Card
+			for _, c := range state.Options {
+				if !strings.Contains(strings.ToLower(string(c.UUID)), strings.ToLower(f)) {
+					continue
+				}
+				if match.UUID != "" && match.UUID != c.UUID {
+					return nil, fmt.Errorf("multiple cards match '%s', try a more specific query", f)
+				}
+				match = c
+			}
+			if match.UUID == "" {
+				return nil, fmt.Errorf("no card matches '%s'", f)
+			}
+			sel = append(sel, match)
+		}
+
+		return sel, nil
+	}
+
 	partialUUID := func(str string) (Card, error) {
+		if str == "." {
+			return cursorCard()
+		}
+
 		var result Card
 		add := func(c Card) error {
 			if !strings.Contains(
@@ -924,6 +1842,7 @@ ignored if -ia is passed. {fn} is replaced by the filename and {pid} with the pr
 	}
 
 	_commandQ := func([]string) error {
+		view.Queue = true
 		if len(queue) == 1 {
 			print("Queue is empty")
 			return nil
@@ -954,6 +1873,24 @@ ignored if -ia is passed. {fn} is replaced by the filename and {pid} with the pr
 		return false
 	}
 
+	// logEntry returns the commit with the given id and all commits after it.
+	logEntry := func(id string) (LogEntry, []LogEntry, error) {
+		n, err := strconv.Atoi(strings.TrimPrefix(id, "#"))
+		if err != nil {
+			return LogEntry{}, nil, fmt.Errorf("'%s' is not a valid commit", id)
+		}
+		list, err := ReadLog(auditFile(dbFile))
+		if err != nil {
+			return LogEntry{}, nil, err
+		}
+		for i, e := range list {
+			if e.ID == n {
+				return e, list[i+1:], nil
+			}
+		}
+		return LogEntry{}, nil, fmt.Errorf("no such commit #%d", n)
+	}
+
 	commands := map[string]func(arg []string) error{
 		"help": func([]string) error {
 			print("Usage:")
@@ -964,39 +1901,109 @@ ignored if -ia is passed. {fn} is replaced by the filename and {pid} with the pr
 			print("{+G,-BURW}                    can only require green mana")
 			print("#flying                       must have keyword flying")
 			print("#creature                     must be a creature")
+			print("@<id>                         a single copy in your collection by its (partial) copy id")
+			print("owner:<name>                  owned by <name>, owner: for cards without an owner")
+			print("in:<container>                stored in <container>, in: for cards that are not placed")
+			print("lent:<person>                 lent to <person>, lent:* for all lent cards, lent: for cards that are not lent")
 			print("")
 			print("SIGINT (Ctrl-c)               cancel action in progress")
+			print("Tab                           complete commands, sets, tags and card names")
+			print("Up / Down                     browse input history")
+			print("PgUp / PgDn                   scroll results a page")
+			print("Ctrl-Up / Ctrl-Down           move the cursor in the results")
+			print("                              commands taking a <uuid> act on the card under the cursor")
+			print("                              if it is omitted or given as .")
 			print("/help                         this")
 			print("/exit   | /quit               quit")
 			print("/queue  | /q                  view operation queue")
 			print("/update                       update mtgjson.com data")
 			print("/sets <filter>                print all known sets (optionally filtered)")
-			print("/sort <sort>                  sort items by index, name, count or price")
+			print("/sort <sort>                  sort items by index, name, count (not lent), price or location")
 			print("/undo   | /u                  remove last item from queue")
+			print("/redo                         restore the last item removed with /undo")
 			print("/reset  | /all                reset query")
-			print("/images | /imgs               create a collage of all cards in current view")
-			print("/image  | /img <uuid>         show card image for card with (partial) UUID <uuid>")
-			print("/info <uuid>                  show card details for card with (partial UUID <uuid>")
+			print("/images | /imgs [next|prev|n] create a collage of all cards in current view")
+			print("                              large views are split in pages (see -collage-per-page)")
+			print("/image  | /img [uuid]         show card image for card with (partial) UUID <uuid>")
+			print("/info [uuid]                  show card details for card with (partial UUID <uuid>")
+			print("/cache [clear]                show image cache statistics or remove all cached images")
+			print("/prefetch [all|stop]          download images for all cards in the current view (or collection)")
+			print("                              in the background")
 			print("/prices                       refresh pricing data (async) for cards in collection")
-			print("/price <uuid>                 show pricing for card with (partial) UUID")
-			print("/tag  {+|-}<tag>,…            tag/untag cards in collection with <tag> or tag all future cards added with <tag>")
+			print("/price [uuid]                 show pricing for card with (partial) UUID")
+			print("/tag  [.] {+|-}<tag>,…        tag/untag cards in collection with <tag> or tag all future cards added with <tag>")
 			print("                                - mode:collection: filter your collection (/mode collection)")
 			print("                                                   and add / remove tags")
 			print("                                - mode:add:        set tags to be added for each card added to your collection")
 			print("                                                   -<tag> does nothing")
+			print("                                                   in:<container> puts each card in the next free slot")
 			print("                              e.g.: +nm -played +shoebox")
 			print("/commit                       commit selection to file (empties selection)")
+			print("/log [commit]                 list all commits or show the changes of a single commit")
+			print("/revert <commit>              stage the inverse of the changes of a commit")
+			print("/fsck                         check your collection against the mtgjson data and stage fixes")
+			print("                              (unknown uuids, changed names or sets and empty or duplicate tags)")
+			print("/snapshot [name]              save a copy of the database that is never rotated out (see -backups)")
+			print("/restore [name [confirm]]     list snapshots and backups, compare one with the database")
+			print("                              or replace the database with it, other sessions keep running")
+			print("                              and merge their staged changes into it on /commit")
+			print("/merge <file> [tag]           stage all cards in another database, snapshot or backup for adding")
+			print("                              optionally tagged with <tag> (e.g.: owner-alice)")
+			print("/split <file>                 stage moving all cards in the current view to another database")
+			print("/container                    list containers and how many cards they hold")
+			print("/container <name> [<kind> [size] | delete]")
+			print("                              show, add or change (kind: box, binder or deckbox, size: number of slots)")
+			print("                              or remove a container")
+			print("/place [.] <container>[:slot] put cards in the current view in the next free slots (from slot)")
+			print("/place [.] none               take cards in the current view out of their container")
+			print("/where [.]                    print the container and slot of the cards in the current view")
+			print("                              or, outside mode:collection, of the copies you own of them")
+			print("/owner [.] [name]             show or change the owner of cards you add (see -owner)")
+			print("                              or, in mode:collection, stage giving cards to <name>")
+			print("/lend [.] <person> [date]     stage lending cards in the current view to <person> since <date>")
+			print("                              (YYYY-MM-DD, default: today)")
+			print("/return [.]                   stage returning lent cards in the current view")
+			print("/loans [person]               list outstanding loans (optionally only to <person>)")
+			print("/trade [.] <person>           in mode:collection, stage trading cards in the current view to <person>")
+			print("                              in mode:add, record cards you add as received from <person>")
+			print("                              (/trade without a person stops recording)")
+			print("/trades [person | #<trade>]   list trades and their value at trade time or show the cards of a trade")
+			print("/diff <file> [oracle] [json]  compare the database with another database, snapshot or backup")
+			print("                              matching cards by printing or oracle, as a table or json")
 			print("/mode   | /m <mode>           enter <mode>")
 			print("                                - add:           add cards by entering their name (fuzzy)")
+			print("                                                 if multiple cards match, select one or more by")
+			print("                                                 (partial) UUID, position (#1,#2,#8-10) or * for all")
 			print("                                - collection:    search your collection for cards")
 			print("                                                 by name (fuzzy) or a range (1,2,8-10)")
 			print("                                                 filter by tag with +<tag> to only include items with <tag>")
 			print("                                                                    -<tag> to exclude items with <tag>")
 			print("                                - search:        search all cards (fuzzy)")
 			print("/repeat | /r                  add last card again")
-			print("/delete | /del                remove cards from collection in current view")
+			print("/delete | /del [.]            remove cards from collection in current view (or under the cursor)")
 			print("/set    | /s <set>            only operate on cards within the given set")
 			print("/csv                          export cards in current collection view as csv")
+			print("/print                        export printable 3x3 proxy sheets of all cards in current view")
+			print("/binder                       export 9-pocket binder pages of the current collection view")
+			print("                              (see -print-paper, -print-dpi and -print-format)")
+			if len(config.Aliases) != 0 || len(config.Macros) != 0 {
+				print("")
+				names := make([]string, 0, len(config.Aliases)+len(config.Macros))
+				for name := range config.Aliases {
+					names = append(names, name)
+				}
+				for name := range config.Macros {
+					names = append(names, name)
+				}
+				sort.Strings(names)
+				for _, name := range names {
+					desc := config.Aliases[name]
+					if lines, ok := config.Macros[name]; ok {
+						desc = "macro: " + strings.Join(lines, "; ")
+					}
+					print(fmt.Sprintf("/%-29s%s", name, desc))
+				}
+			}
 			return nil
 		},
 		"exit": func([]string) error {
@@ -1013,11 +2020,21 @@ ignored if -ia is passed. {fn} is replaced by the filename and {pid} with the pr
 		"queue": _commandQ,
 		"undo": func([]string) error {
 			if len(queue) > 1 {
+				redo = append(redo, queue[len(queue)-1])
 				queue = queue[:len(queue)-1]
 			}
 			state = queue[len(queue)-1]
 			return _commandQ(nil)
 		},
+		"redo": func([]string) error {
+			if len(redo) == 0 {
+				return errors.New("nothing to redo")
+			}
+			state = redo[len(redo)-1]
+			redo = redo[:len(redo)-1]
+			queue = append(queue, state)
+			return _commandQ(nil)
+		},
 		"reset": func([]string) error {
 			modifyState(true, func(s State) State {
 				s.Query = nil
@@ -1034,48 +2051,800 @@ ignored if -ia is passed. {fn} is replaced by the filename and {pid} with the pr
 			return nil
 		},
 		"commit": func([]string) error {
-			selection := state.Selection
-			deletes := state.Delete
-			tags := state.Tagging
+			saved, err := app.Commit(state, dbFile)
+			var cerr *CommitError
+			if errors.As(err, &cerr) {
+				if cerr.Journal != nil {
+					// the database was reloaded, stage the changes again.
+					var skipped int
+					base := state.Unstaged()
+					base.Local = nil
+					state, skipped = cerr.Journal.Apply(app, base)
+					queue = []State{base, state}
+					redo = nil
+					if skipped != 0 {
+						printErr(fmt.Errorf("skipped %d changes to cards that are no longer in the database", skipped))
+					}
+				}
+				return fmt.Errorf("%w, your changes are still staged", err)
+			}
 
-			state.Selection = nil
-			state.Delete = nil
-			state.Tagging = nil
+			state = state.Unstaged()
 			for i := range queue {
-				queue[i].Selection = nil
-				queue[i].Delete = nil
-				queue[i].Tagging = nil
+				queue[i] = queue[i].Unstaged()
+			}
+			redo = nil
+			if err != nil {
+				return err
 			}
 
-			for _, c := range selection {
-				dbCard := FromCard(app.DB, c.Card)
-				dbCard.Tag(c.Tags.Slice())
-				app.DB.Add(dbCard)
+			if !saved {
+				printErr(errors.New("nothing to commit"))
+				return nil
+			}
+			if r := app.DB.Synced(); !r.Empty() {
+				for _, c := range r.Conflicts {
+					print(c.String())
+				}
+				print(r.String())
+			}
+			printAlert("all changes committed to database")
+			return nil
+		},
+		"log": func(args []string) error {
+			if len(args) > 1 {
+				return errors.New("usage: /log [commit]")
+			}
+			if len(args) == 1 {
+				e, _, err := logEntry(args[0])
+				if err != nil {
+					return err
+				}
+				for _, l := range e.Details() {
+					print(l)
+				}
+				return nil
 			}
 
-			for _, c := range deletes {
-				app.DB.Delete(c.DBCard)
+			list, err := ReadLog(auditFile(dbFile))
+			if err != nil {
+				return err
+			}
+			if len(list) == 0 {
+				return errors.New("no commits yet")
+			}
+			for i := len(list) - 1; i >= 0; i-- {
+				print(list[i].String())
+			}
+			return nil
+		},
+		"revert": func(args []string) error {
+			if len(args) != 1 {
+				return errors.New("usage: /revert <commit>")
+			}
+			e, later, err := logEntry(args[0])
+			if err != nil {
+				return err
+			}
+			var skipped int
+			modifyState(true, func(s State) State {
+				s, skipped = e.Revert(app, s, later)
+				return s
+			})
+			msg := fmt.Sprintf("staged the inverse of commit #%d, /commit to apply", e.ID)
+			if skipped != 0 {
+				msg = fmt.Sprintf("%s (%d changes skipped, cards no longer exist)", msg, skipped)
+			}
+			printAlert(msg)
+			return nil
+		},
+		"fsck": func([]string) error {
+			issues := app.Fsck()
+			if len(issues) == 0 {
+				printAlert("no problems found")
+				return nil
 			}
 
-			for _, c := range app.DB.Cards() {
-				c.SetPricing(app.GetFullPricing(c.UUID(), false, false, false))
+			staged := make(map[*DBCard]struct{}, len(state.Remap))
+			for _, r := range state.Remap {
+				staged[r.DBCard] = struct{}{}
+			}
+			var remaps []Remap
+			var tagging []Tagging
+			unfixable := 0
+			for _, i := range issues {
+				print(i.String())
+				switch {
+				case i.Remap != nil:
+					if _, ok := staged[i.Remap.DBCard]; !ok {
+						remaps = append(remaps, *i.Remap)
+					}
+				case i.Tagging != nil:
+					tagging = append(tagging, *i.Tagging)
+				default:
+					unfixable++
+				}
 			}
 
-			for _, t := range tags {
-				t.Commit()
+			if len(remaps) != 0 || len(tagging) != 0 {
+				modifyState(true, func(s State) State {
+					s.Remap = append(s.Remap[:len(s.Remap):len(s.Remap)], remaps...)
+					s.Tagging = append(s.Tagging[:len(s.Tagging):len(s.Tagging)], tagging...)
+					return s
+				})
+			}
+			msg := fmt.Sprintf("%d problems", len(issues))
+			if n := len(remaps) + len(tagging); n != 0 {
+				msg += fmt.Sprintf(", staged %d fixes, /commit to apply or /undo to discard them", n)
+			}
+			if unfixable != 0 {
+				msg += fmt.Sprintf(", %d cards could not be fixed, /delete them or edit the database manually", unfixable)
+			}
+			printAlert(msg)
+			return nil
+		},
+		"snapshot": func(args []string) error {
+			if len(args) > 1 {
+				return errors.New("usage: /snapshot [name]")
+			}
+			name := time.Now().Format("2006-01-02_15-04-05")
+			if len(args) == 1 {
+				name = args[0]
+			}
+			if _, err := app.Backups.Snapshot(dbFile, name); err != nil {
+				return err
+			}
+			msg := fmt.Sprintf("created snapshot '%s'", name)
+			if state.Changes() {
+				msg += ", uncommitted changes are not included"
+			}
+			printAlert(msg)
+			return nil
+		},
+		"restore": func(args []string) error {
+			if len(args) > 2 || (len(args) == 2 && args[1] != "confirm") {
+				return errors.New("usage: /restore [<name> [confirm]]")
+			}
+			if len(args) == 0 {
+				list, err := app.Backups.List()
+				if err != nil {
+					return err
+				}
+				if len(list) == 0 {
+					return errors.New("no snapshots or backups yet")
+				}
+				for i := len(list) - 1; i >= 0; i-- {
+					f := list[i]
+					print(fmt.Sprintf("%-8s %s %s", f.Kind, f.Time.Local().Format("2006-01-02 15:04:05"), f.Name))
+				}
+				return nil
 			}
 
-			saved, err := app.DB.Save(dbFile)
+			f, err := app.Backups.Find(args[0])
 			if err != nil {
 				return err
 			}
+			db, err := ReadDBFile(f.File)
+			if err != nil {
+				return err
+			}
+			diff := DiffDB(app.DB, db)
+			if len(args) == 1 {
+				if diff.Empty() {
+					printAlert(fmt.Sprintf("'%s' is identical to the database", f.Name))
+					return nil
+				}
+				for _, l := range diff.Changes() {
+					print(l)
+				}
+				printAlert(fmt.Sprintf(
+					"restoring '%s' would add %d, remove %d, retag %d and remap %d cards, /restore %s confirm to restore it",
+					f.Name,
+					len(diff.Added),
+					len(diff.Removed),
+					len(diff.Tagged),
+					len(diff.Remapped),
+					args[0],
+				))
+				return nil
+			}
 
-			rebuildLocalFuzz()
-			if !saved {
-				printErr(errors.New("nothing to commit"))
+			if state.Changes() {
+				return errors.New("/commit or /undo your staged changes before restoring")
+			}
+			unlock, err := lockDB(dbFile)
+			if err != nil {
+				return err
+			}
+			defer unlock()
+			msg := fmt.Sprintf("restored '%s'", f.Name)
+			if _, err := os.Stat(dbFile); err == nil {
+				before := "before-restore-" + time.Now().Format("2006-01-02_15-04-05")
+				if _, err := app.Backups.Snapshot(dbFile, before); err != nil {
+					return err
+				}
+				msg += fmt.Sprintf(", the previous database is in snapshot '%s'", before)
+			}
+
+			// written through the open storage instead of replacing the file,
+			// other sessions using a sqlite database would otherwise keep
+			// the removed file open.
+			if db, err = app.DB.Restore(db); err != nil {
+				return err
+			}
+			app.DB = db
+			app.BuildLocalIndex()
+
+			state.Query = nil
+			state.Local = nil
+			state.Options = nil
+			state.PageOffset = 0
+			state.Cursor = 0
+			queue = []State{state}
+			redo = nil
+
+			if !diff.Empty() {
+				diff.Time, diff.User = time.Now(), currentUser()
+				if _, err := AppendLog(auditFile(dbFile), diff); err != nil {
+					return fmt.Errorf("database restored but failed to append to the audit log: %w", err)
+				}
+			}
+			printAlert(msg)
+			return nil
+		},
+		"merge": func(args []string) error {
+			if len(args) == 0 || len(args) > 2 {
+				return errors.New("usage: /merge <file> [tag]")
+			}
+			if sameFile(args[0], dbFile) {
+				return fmt.Errorf("can not merge '%s' into itself", args[0])
+			}
+			db, err := readDB(args[0])
+			if err != nil {
+				return err
+			}
+			tag := ""
+			if len(args) == 2 {
+				tag = args[1]
+			}
+			sel := app.MergeSelection(db, tag)
+			if len(sel) == 0 {
+				return fmt.Errorf("'%s' is empty", args[0])
+			}
+			modifyState(true, func(s State) State {
+				s.Selection = append(s.Selection[:len(s.Selection):len(s.Selection)], sel...)
+				s.Containers = append(s.Containers[:len(s.Containers):len(s.Containers)], app.MergeContainers(s, db)...)
+				return s
+			})
+			printAlert(fmt.Sprintf("staged %d cards from '%s', /commit to apply or /undo to discard them", len(sel), args[0]))
+			return nil
+		},
+		"split": func(args []string) error {
+			if len(args) != 1 {
+				return errors.New("usage: /split <file>")
+			}
+			if state.Mode != ModeCollection {
+				return errors.New("/split can only be used from /mode collection")
+			}
+			if sameFile(args[0], dbFile) {
+				return errors.New("can not split cards into the database itself")
+			}
+			if len(state.Local) == 0 {
+				return errors.New("no cards in the current view")
+			}
+
+			n := len(state.Local)
+			modifyState(true, func(s State) State {
+				s.Query = nil
+				s.Split = append(s.Split[:len(s.Split):len(s.Split)], NewSplit(s.Local, args[0])...)
+				s.Local = nil
+				s.Options = nil
+				return s
+			})
+			printAlert(fmt.Sprintf("staged moving %d cards to '%s', /commit to apply or /undo to discard them", n, args[0]))
+			printOptions()
+			return nil
+		},
+		"owner": func(args []string) error {
+			if len(args) == 0 {
+				printAlert(fmt.Sprintf("cards you add are owned by '%s'", app.Owner))
 				return nil
 			}
-			printAlert("all changes committed to database")
+			if state.Mode != ModeCollection {
+				if len(args) != 1 || args[0] == "." {
+					return errors.New("usage: /owner <name> to change the owner of cards you add")
+				}
+				app.Owner = args[0]
+				printAlert(fmt.Sprintf("cards you add are now owned by '%s'", app.Owner))
+				return nil
+			}
+
+			cards := state.Local
+			if args[0] == "." {
+				c, err := cursorLocal()
+				if err != nil {
+					return err
+				}
+				cards, args = []LocalCard{c}, args[1:]
+			}
+			if len(args) != 1 {
+				return errors.New("usage: /owner [.] <name>")
+			}
+			l := make([]Ownership, 0, len(cards))
+			for _, c := range cards {
+				if c.Owner() != args[0] {
+					l = append(l, Ownership{c, args[0]})
+				}
+			}
+			if len(l) == 0 {
+				return errors.New("no cards to give away")
+			}
+			modifyState(true, func(s State) State {
+				s.Ownership = append(s.Ownership[:len(s.Ownership):len(s.Ownership)], l...)
+				return s
+			})
+			printAlert(fmt.Sprintf("staged giving %d cards to '%s', /commit to apply or /undo to discard them", len(l), args[0]))
+			return nil
+		},
+		"container": func(args []string) error {
+			if len(args) == 0 {
+				list := state.AllContainers(app.DB)
+				if len(list) == 0 {
+					return errors.New("no containers yet, see /help")
+				}
+				sortContainers(list)
+				usage := state.Usage(app.DB)
+				for _, c := range list {
+					size := "-"
+					if c.Size != 0 {
+						size = strconv.Itoa(c.Size)
+					}
+					print(fmt.Sprintf("%-20s %-8s %6d / %-6s cards", c.Name, c.Kind, usage[c.Name], size))
+				}
+				return nil
+			}
+
+			name := args[0]
+			if !containerNameRE.MatchString(name) {
+				return fmt.Errorf("invalid container name '%s', only letters, digits, _, . and - are allowed", name)
+			}
+			c, exists := state.FindContainer(app.DB, name)
+			used := state.Slots(app.DB, name)
+			switch {
+			case len(args) == 1:
+				if !exists {
+					return fmt.Errorf("no such container '%s'", name)
+				}
+				next := "none"
+				if slots, err := state.FreeSlots(app.DB, name, 1, 1); err == nil {
+					next = c.Slot(slots[0])
+				}
+				print(fmt.Sprintf("%s: %d cards, next free slot: %s", c, len(used), next))
+				return nil
+			case len(args) == 2 && args[1] == "delete":
+				if !exists {
+					return fmt.Errorf("no such container '%s'", name)
+				}
+				if len(used) != 0 {
+					return fmt.Errorf("%s still holds %d cards, /place them elsewhere first", c, len(used))
+				}
+				modifyState(true, func(s State) State {
+					s.Containers = append(s.Containers[:len(s.Containers):len(s.Containers)], ContainerEdit{c, true})
+					return s
+				})
+				printAlert(fmt.Sprintf("staged removing %s, /commit to apply or /undo to discard it", c))
+				return nil
+			case len(args) > 3:
+				return errors.New("usage: /container [<name> [box|binder|deckbox [size] | delete]]")
+			}
+
+			n := Container{Name: name, Kind: ContainerKind(args[1])}
+			if !n.Kind.Valid() {
+				return fmt.Errorf("invalid container kind '%s', valid: box, binder, deckbox", args[1])
+			}
+			if len(args) == 3 {
+				size, err := strconv.Atoi(args[2])
+				if err != nil || size < 0 {
+					return fmt.Errorf("invalid container size '%s'", args[2])
+				}
+				n.Size = size
+			}
+			for slot := range used {
+				if n.Size != 0 && slot > n.Size {
+					return fmt.Errorf("%s has a card in slot %d", c, slot)
+				}
+			}
+			if exists && c == n {
+				return nil
+			}
+			modifyState(true, func(s State) State {
+				s.Containers = append(s.Containers[:len(s.Containers):len(s.Containers)], ContainerEdit{Container: n})
+				return s
+			})
+			printAlert(fmt.Sprintf("staged container %s, /commit to apply or /undo to discard it", n))
+			return nil
+		},
+		"place": func(args []string) error {
+			if state.Mode != ModeCollection {
+				return errors.New("/place can only be used from /mode collection")
+			}
+			cards := state.Local
+			if len(args) != 0 && args[0] == "." {
+				c, err := cursorLocal()
+				if err != nil {
+					return err
+				}
+				cards, args = []LocalCard{c}, args[1:]
+			}
+			if len(args) != 1 {
+				return errors.New("usage: /place [.] <container>[:<slot>] | none")
+			}
+			if len(cards) == 0 {
+				return errors.New("no cards in the current view")
+			}
+
+			l := make([]Placement, 0, len(cards))
+			if args[0] == "none" {
+				for _, c := range cards {
+					if !state.Location(c.DBCard).Empty() {
+						l = append(l, Placement{c, Location{}})
+					}
+				}
+			} else {
+				to, err := ParseLocation(args[0])
+				if err != nil {
+					return err
+				}
+				// the slots of the cards being placed are free to take.
+				tmp := state
+				for _, c := range cards {
+					tmp.Placement = append(tmp.Placement[:len(tmp.Placement):len(tmp.Placement)], Placement{c, Location{}})
+				}
+				slots, err := tmp.FreeSlots(app.DB, to.Container, to.Slot, len(cards))
+				if err != nil {
+					return err
+				}
+				if to.Slot != 0 && len(cards) == 1 && slots[0] != to.Slot {
+					c, _ := tmp.FindContainer(app.DB, to.Container)
+					return fmt.Errorf("%s is taken", c.Slot(to.Slot))
+				}
+				for i, c := range cards {
+					if loc := (Location{to.Container, slots[i]}); state.Location(c.DBCard) != loc {
+						l = append(l, Placement{c, loc})
+					}
+				}
+			}
+			if len(l) == 0 {
+				return errors.New("no cards to place")
+			}
+
+			modifyState(true, func(s State) State {
+				s.Placement = append(s.Placement[:len(s.Placement):len(s.Placement)], l...)
+				return s
+			})
+			printAlert(fmt.Sprintf("staged placing %d cards, /commit to apply or /undo to discard them", len(l)))
+			return nil
+		},
+		"where": func(args []string) error {
+			if len(args) > 1 || (len(args) == 1 && args[0] != ".") {
+				return errors.New("/where only takes . as an argument")
+			}
+			if state.Mode != ModeCollection {
+				cards := paneCards()
+				if len(args) == 1 {
+					c, err := cursorCard()
+					if err != nil {
+						return err
+					}
+					cards = []Card{c}
+				}
+				if len(cards) == 0 {
+					return errors.New("no cards in the current view")
+				}
+				// the copies in the collection of each card.
+				seen := make(map[mtgjson.UUID]struct{}, len(cards))
+				for _, rc := range cards {
+					if _, ok := seen[rc.UUID]; ok {
+						continue
+					}
+					seen[rc.UUID] = struct{}{}
+					n := 0
+					for i, c := range app.DB.Cards() {
+						if c.UUID() != rc.UUID {
+							continue
+						}
+						n++
+						print(fmt.Sprintf(
+							"%6d %s %-5s %-30s %s",
+							i+1,
+							c.ID(),
+							c.SetID(),
+							c.Name(),
+							state.Where(app.DB, c),
+						))
+					}
+					if n == 0 {
+						print(fmt.Sprintf("%6s %16s %-5s %-30s not in the collection", "-", "", rc.SetCode, rc.Name))
+					}
+				}
+				return nil
+			}
+			cards := state.Local
+			if len(args) == 1 {
+				c, err := cursorLocal()
+				if err != nil {
+					return err
+				}
+				cards = []LocalCard{c}
+			}
+			if len(cards) == 0 {
+				return errors.New("no cards in the current view")
+			}
+			for _, c := range cards {
+				print(fmt.Sprintf(
+					"%6d %s %-5s %-30s %s",
+					c.Index+1,
+					c.ID(),
+					c.SetID(),
+					c.Name(),
+					state.Where(app.DB, c.DBCard),
+				))
+			}
+			return nil
+		},
+		"lend": func(args []string) error {
+			if state.Mode != ModeCollection {
+				return errors.New("/lend can only be used from /mode collection")
+			}
+			cards := state.Local
+			if len(args) != 0 && args[0] == "." {
+				c, err := cursorLocal()
+				if err != nil {
+					return err
+				}
+				cards, args = []LocalCard{c}, args[1:]
+			}
+			if len(args) == 0 || len(args) > 2 {
+				return errors.New("usage: /lend [.] <person> [YYYY-MM-DD]")
+			}
+			if len(cards) == 0 {
+				return errors.New("no cards in the current view")
+			}
+			y, m, d := time.Now().Date()
+			since := time.Date(y, m, d, 0, 0, 0, 0, time.Local)
+			if len(args) == 2 {
+				var err error
+				if since, err = time.ParseInLocation("2006-01-02", args[1], time.Local); err != nil {
+					return fmt.Errorf("invalid date '%s', expected YYYY-MM-DD", args[1])
+				}
+			}
+
+			loan := Loan{args[0], since}
+			l := make([]Lending, 0, len(cards))
+			for _, c := range cards {
+				cur := state.Loan(c.DBCard)
+				if !cur.Empty() && !strings.EqualFold(cur.To, loan.To) {
+					return fmt.Errorf("%s %s is %s, /return it first", c.ID(), c.Name(), cur)
+				}
+				if !cur.Equal(loan) {
+					l = append(l, Lending{c, loan})
+				}
+			}
+			if len(l) == 0 {
+				return errors.New("no cards to lend")
+			}
+			modifyState(true, func(s State) State {
+				s.Lending = append(s.Lending[:len(s.Lending):len(s.Lending)], l...)
+				return s
+			})
+			printAlert(fmt.Sprintf("staged lending %d cards to '%s', /commit to apply or /undo to discard them", len(l), loan.To))
+			return nil
+		},
+		"return": func(args []string) error {
+			if len(args) > 1 || (len(args) == 1 && args[0] != ".") {
+				return errors.New("/return only takes . as an argument")
+			}
+			if state.Mode != ModeCollection {
+				return errors.New("/return can only be used from /mode collection")
+			}
+			cards := state.Local
+			if len(args) == 1 {
+				c, err := cursorLocal()
+				if err != nil {
+					return err
+				}
+				cards = []LocalCard{c}
+			}
+			l := make([]Lending, 0, len(cards))
+			for _, c := range cards {
+				if !state.Loan(c.DBCard).Empty() {
+					l = append(l, Lending{c, Loan{}})
+				}
+			}
+			if len(l) == 0 {
+				return errors.New("no lent cards to return")
+			}
+			modifyState(true, func(s State) State {
+				s.Lending = append(s.Lending[:len(s.Lending):len(s.Lending)], l...)
+				return s
+			})
+			printAlert(fmt.Sprintf("staged returning %d cards, /commit to apply or /undo to discard them", len(l)))
+			return nil
+		},
+		"loans": func(args []string) error {
+			if len(args) > 1 {
+				return errors.New("usage: /loans [person]")
+			}
+			type loaned struct {
+				LocalCard
+				Loan
+			}
+			var list []loaned
+			for i, c := range app.DB.Cards() {
+				l := state.Loan(c)
+				if l.Empty() || (len(args) == 1 && !strings.EqualFold(l.To, args[0])) {
+					continue
+				}
+				list = append(list, loaned{NewLocalCard(c, i), l})
+			}
+			if len(list) == 0 {
+				return errors.New("no outstanding loans")
+			}
+			sort.SliceStable(list, func(i, j int) bool {
+				if !strings.EqualFold(list[i].To, list[j].To) {
+					return strings.ToLower(list[i].To) < strings.ToLower(list[j].To)
+				}
+				return list[i].Since.Before(list[j].Since)
+			})
+			now := time.Now()
+			for _, c := range list {
+				print(fmt.Sprintf(
+					"%6d %s %-5s %-30s %-12s %s %4d days",
+					c.Index+1,
+					c.ID(),
+					c.SetID(),
+					c.Name(),
+					c.To,
+					c.Since.Local().Format("2006-01-02"),
+					int(now.Sub(c.Since).Hours()/24),
+				))
+			}
+			return nil
+		},
+		"trade": func(args []string) error {
+			switch state.Mode {
+			case ModeAdd:
+				if len(args) > 1 {
+					return errors.New("usage: /trade [person]")
+				}
+				with := ""
+				if len(args) == 1 {
+					with = args[0]
+				}
+				modifyState(true, func(s State) State {
+					s.TradeWith = with
+					return s
+				})
+				if with == "" {
+					printAlert("cards you add are no longer received in a trade")
+					return nil
+				}
+				printAlert(fmt.Sprintf("cards you add are received in a trade with '%s'", with))
+				return nil
+			case ModeCollection:
+			default:
+				return errors.New("/trade can only be used from /mode collection or /mode add")
+			}
+
+			cards := state.Local
+			if len(args) != 0 && args[0] == "." {
+				c, err := cursorLocal()
+				if err != nil {
+					return err
+				}
+				cards, args = []LocalCard{c}, args[1:]
+			}
+			if len(args) != 1 {
+				return errors.New("usage: /trade [.] <person>")
+			}
+			if len(cards) == 0 {
+				return errors.New("no cards in the current view")
+			}
+			l := make([]Trade, 0, len(cards))
+			for _, c := range cards {
+				if loan := state.Loan(c.DBCard); !loan.Empty() {
+					return fmt.Errorf("%s %s is %s, /return it first", c.ID(), c.Name(), loan)
+				}
+				l = append(l, Trade{c, args[0]})
+			}
+			modifyState(true, func(s State) State {
+				s.Trading = append(s.Trading[:len(s.Trading):len(s.Trading)], l...)
+				return s
+			})
+			printAlert(fmt.Sprintf("staged trading %d cards to '%s', /commit to apply or /undo to discard them", len(l), args[0]))
+			return nil
+		},
+		"trades": func(args []string) error {
+			if len(args) > 1 {
+				return errors.New("usage: /trades [person | #<trade>]")
+			}
+			list, err := ReadTrades(tradeFile(dbFile))
+			if err != nil {
+				return err
+			}
+			if len(args) == 1 && strings.HasPrefix(args[0], "#") {
+				id, err := strconv.Atoi(args[0][1:])
+				if err != nil {
+					return fmt.Errorf("invalid trade '%s'", args[0])
+				}
+				for _, t := range list {
+					if t.ID == id {
+						for _, l := range t.Details() {
+							print(l)
+						}
+						return nil
+					}
+				}
+				return fmt.Errorf("no such trade '%s'", args[0])
+			}
+
+			n := 0
+			balance := 0.0
+			for i := len(list) - 1; i >= 0; i-- {
+				t := list[i]
+				if len(args) == 1 && !strings.EqualFold(t.With, args[0]) {
+					continue
+				}
+				print(t.String())
+				balance += tradeValue(t.Received) - tradeValue(t.Given)
+				n++
+			}
+			if n == 0 {
+				return errors.New("no trades yet")
+			}
+			if len(args) == 1 {
+				print(fmt.Sprintf("balance with %s over %d trades: %+.2f", args[0], n, balance))
+			}
+			return nil
+		},
+		"diff": func(args []string) error {
+			usage := errors.New("usage: /diff <file> [printing|oracle] [table|json]")
+			if len(args) == 0 || len(args) > 3 {
+				return usage
+			}
+			level, output := DiffPrinting, "table"
+			for _, a := range args[1:] {
+				switch {
+				case DiffLevel(a).Valid():
+					level = DiffLevel(a)
+				case a == "table" || a == "json":
+					output = a
+				default:
+					return usage
+				}
+			}
+
+			db, err := readDB(args[0])
+			if err != nil {
+				return err
+			}
+			d := app.Diff(app.DB, db, level)
+			d.From, d.To = dbFile, args[0]
+			if output == "json" {
+				b, err := json.MarshalIndent(d, "", "  ")
+				if err != nil {
+					return err
+				}
+				for _, l := range strings.Split(string(b), "\n") {
+					print(l)
+				}
+				return nil
+			}
+			l := d.Table()
+			for _, line := range l[:len(l)-1] {
+				print(line)
+			}
+			printAlert(l[len(l)-1])
 			return nil
 		},
 		"sets": func(args []string) error {
@@ -1093,34 +2862,91 @@ ignored if -ia is passed. {fn} is replaced by the filename and {pid} with the pr
 			printAlert(fmt.Sprintf("exported to: %s", file))
 			return nil
 		},
-		"images": func([]string) error {
-			if len(state.Options) > 100 {
-				return errors.New("too many cards to generate an image of")
-			}
-			listID := cardListID(state.Options)
-			if lastImageListID == listID {
-				printOptions()
-				return spawnViewer(imageCommand, imageRefreshCommand, imageAutoReload, imagePath)
+		"print": func([]string) error {
+			return writeSheets("proxies", func(progress func(n, total int), page func(*image.NRGBA) error) error {
+				return proxySheets(paneCards(), sheet, imageGetter, progress, page)
+			})
+		},
+		"binder": func([]string) error {
+			if state.Mode != ModeCollection {
+				return errors.New("/binder can only be used from /mode collection")
 			}
-			err := genImages(state.Options, imagePath, imageGetter, func(i, total int) {
-				print(fmt.Sprintf("Downloaded %02d/%02d", i, total))
-				flush()
+			return writeSheets("binder", func(progress func(n, total int), page func(*image.NRGBA) error) error {
+				return binderPages(state.Local, app.Cards, sheet, imageGetter, progress, page)
 			})
+		},
+		"images": func(a []string) error {
+			if len(a) > 1 {
+				return errors.New("usage: /images [next|prev|<page>]")
+			}
+			cards := paneCards()
+			var local []LocalCard
+			if state.Mode == ModeCollection {
+				local = state.Local
+			}
+			listID := cardListID(cards)
+			pages := collage.Pages(len(cards))
+			page := 0
+			if listID == lastImageListID {
+				page = lastImagePage
+			}
+			if len(a) == 1 {
+				switch a[0] {
+				case "next":
+					page++
+				case "prev":
+					page--
+				default:
+					n, err := strconv.Atoi(a[0])
+					if err != nil {
+						return errors.New("usage: /images [next|prev|<page>]")
+					}
+					page = n - 1
+				}
+				if page < 0 || page >= pages {
+					return fmt.Errorf("no page %d, there are %d pages", page+1, pages)
+				}
+			}
+
+			if lastImageListID == listID && lastImagePage == page && lastImage != nil {
+				return viewImage(lastImage)
+			}
+			from, to := collage.Page(page, len(cards))
+			if local != nil {
+				local = local[from:to]
+			}
+			img, err := genImages(
+				cards[from:to],
+				collageLabels(cards[from:to], local, from),
+				imagePath,
+				collage,
+				imageGetter,
+				func(i, total int) {
+					print(fmt.Sprintf("Downloaded %02d/%02d", i, total))
+					flush()
+				},
+			)
 			if err != nil {
 				return err
 			}
-			lastImageListID = listID
-			printOptions()
-			printAlert(fmt.Sprintf("Downloaded image to '%s'", imagePath))
-			return spawnViewer(imageCommand, imageRefreshCommand, imageAutoReload, imagePath)
+			lastImageListID, lastImagePage, lastImage = listID, page, img
+			msg := fmt.Sprintf("Downloaded image to '%s'", imagePath)
+			if pages > 1 {
+				msg += fmt.Sprintf(" (page %d/%d, /images next or prev)", page+1, pages)
+			}
+			printAlert(msg)
+			return viewImage(img)
 		},
 		"image": func(a []string) error {
-			if len(a) != 1 || len(a[0]) == 0 {
-				return errors.New("/img requires exactly 1 argument")
+			if len(a) > 1 {
+				return errors.New("/img takes at most 1 argument")
 			}
 
 			list := make([]Card, 1)
-			arg := a[0]
+			arg := "."
+			if len(a) == 1 {
+				arg = a[0]
+			}
 			card, err := partialUUID(arg)
 			if err != nil {
 				return err
@@ -1129,82 +2955,40 @@ ignored if -ia is passed. {fn} is replaced by the filename and {pid} with the pr
 
 			listID := cardListID(list)
 			if lastImageListID != listID {
-				err := genImages(list, imagePath, imageGetter, func(n, total int) {})
+				img, err := genImages(
+					list,
+					collageLabels(list, nil, -1),
+					imagePath,
+					collage,
+					imageGetter,
+					func(n, total int) {},
+				)
 				if err != nil {
 					return err
 				}
-				lastImageListID = listID
+				lastImageListID, lastImagePage, lastImage = listID, 0, img
 			}
 
-			printOptions()
 			printAlert(fmt.Sprintf("Downloaded image to '%s'", imagePath))
-			return spawnViewer(imageCommand, imageRefreshCommand, imageAutoReload, imagePath)
+			return viewImage(lastImage)
 		},
 		"info": func(a []string) error {
-			if len(a) != 1 || len(a[0]) == 0 {
-				return errors.New("/info requires exactly 1 argument")
+			if len(a) > 1 {
+				return errors.New("/info takes at most 1 argument")
+			}
+			arg := "."
+			if len(a) == 1 {
+				arg = a[0]
 			}
-			c, err := partialUUID(a[0])
+			c, err := partialUUID(arg)
 			if err != nil {
 				return err
 			}
-			card, err := c.Full()
+			data, err := app.CardInfo(c)
 			if err != nil {
 				return err
 			}
 
-			data := make([]string, 6, 10)
-			data[0] = fmt.Sprintf("%s: %s", card.SetCode, app.Cards.Sets[card.SetCode])
-			var pt string
-			if card.Power != "" && card.Toughness != "" {
-				pt = fmt.Sprintf("%2s/%-2s", card.Power, card.Toughness)
-			}
-			data[2] = fmt.Sprintf("%-30s %12s %-6s", card.Name, card.ManaCost, pt)
-
-			data[4] = fmt.Sprintf("Type: %s", strings.Join(card.Types, "|"))
-
-			data = append(data, strings.Split(card.Text, "\n")...)
-			data = append(data, "")
-			if len(card.Rulings) != 0 {
-				data = append(data, "Rulings:")
-				for _, r := range card.Rulings {
-					data = append(data, "  "+string(r.Date))
-					for _, t := range strings.Split(r.Text, "\n") {
-						data = append(data, "  "+t)
-					}
-				}
-				data = append(data, "")
-			}
-
-			if len(card.Keywords) != 0 {
-				data = append(data, "Keywords: "+strings.Join(card.Keywords, "|"))
-			}
-
-			special := make([]string, 0, 2)
-			if card.IsReserved {
-				special = append(special, "Reserved")
-			}
-			if card.IsPromo {
-				special = append(special, "Promo")
-			}
-			if card.IsAlternative {
-				special = append(special, "Alternative")
-			}
-			if card.IsOversized {
-				special = append(special, "Oversized")
-			}
-			reprint := "Reprint"
-			if !card.IsReprint {
-				reprint = "First print"
-			}
-			special = append(special, reprint)
-
-			if len(special) != 0 {
-				data = append(data, "", strings.Join(special, "|"))
-			}
-
-			data = append(data, "", fmt.Sprintf("Artist: %s", card.Artist))
-
 			print(data...)
 
 			return nil
@@ -1290,15 +3074,99 @@ ignored if -ia is passed. {fn} is replaced by the filename and {pid} with the pr
 				return s
 			})
 
-			printOptions()
+			printOptions()
+			return nil
+		},
+		"repeat": func([]string) error {
+			if len(state.Selection) == 0 {
+				return errors.New("nothing to repeat")
+			}
+			addToCollection(state.Selection[len(state.Selection)-1:].Cards())
+
+			return nil
+		},
+		"cache": func(a []string) error {
+			if len(a) > 1 || (len(a) == 1 && a[0] != "clear") {
+				return errors.New("usage: /cache [clear]")
+			}
+			if len(a) == 1 {
+				if err := imageCache.Clear(); err != nil {
+					return err
+				}
+				lastImageListID = ""
+				printAlert("Cleared image cache")
+			}
+
+			st := imageCache.Stats()
+			max := "unlimited"
+			if st.Max > 0 {
+				max = fmt.Sprintf("%.1fMiB", float64(st.Max)/(1<<20))
+			}
+			print(
+				fmt.Sprintf("Images:    %d", st.Files),
+				fmt.Sprintf("Size:      %.1fMiB / %s", float64(st.Size)/(1<<20), max),
+				fmt.Sprintf("Hits:      %d", st.Hits),
+				fmt.Sprintf("Misses:    %d", st.Misses),
+				fmt.Sprintf("Evictions: %d", st.Evictions),
+			)
+			if st.Prefetching {
+				print(fmt.Sprintf("Prefetch:  %d/%d", st.Prefetched, st.Prefetch))
+			}
+			if offline {
+				print("Offline mode is enabled")
+			}
 			return nil
 		},
-		"repeat": func([]string) error {
-			if len(state.Selection) == 0 {
-				return errors.New("nothing to repeat")
+		"prefetch": func(a []string) error {
+			if len(a) > 1 {
+				return errors.New("usage: /prefetch [all|stop]")
+			}
+			cards := paneCards()
+			if len(a) == 1 {
+				switch a[0] {
+				case "stop":
+					if !imageCache.StopPrefetch() {
+						return errors.New("not prefetching")
+					}
+					printAlert("Stopped prefetching")
+					return nil
+				case "all":
+					local := app.DB.Cards()
+					cards = make([]Card, 0, len(local))
+					for _, c := range local {
+						if rc, ok := app.Cards.ByUUID(c.UUID()); ok {
+							cards = append(cards, rc)
+						}
+					}
+				default:
+					return errors.New("usage: /prefetch [all|stop]")
+				}
+			}
+
+			urls := make([]string, 0, len(cards))
+			seen := make(map[string]struct{}, len(cards))
+			for _, c := range cards {
+				list, err := c.ImageURLs(collage.Size)
+				if err != nil {
+					continue
+				}
+				for _, u := range list {
+					if _, ok := seen[u]; !ok {
+						seen[u] = struct{}{}
+						urls = append(urls, u)
+					}
+				}
 			}
-			addToCollection(state.Selection[len(state.Selection)-1:].Cards())
 
+			n, err := imageCache.Prefetch(urls)
+			if err != nil {
+				return err
+			}
+			if n == 0 {
+				printAlert("All images are cached")
+				return nil
+			}
+			printAlert(fmt.Sprintf("Prefetching %d images in the background, see /cache", n))
 			return nil
 		},
 		"prices": func([]string) error {
@@ -1313,10 +3181,14 @@ ignored if -ia is passed. {fn} is replaced by the filename and {pid} with the pr
 			return nil
 		},
 		"price": func(a []string) error {
-			if len(a) != 1 || len(a[0]) == 0 {
-				return errors.New("/price requires exactly 1 argument")
+			if len(a) > 1 {
+				return errors.New("/price takes at most 1 argument")
+			}
+			arg := "."
+			if len(a) == 1 {
+				arg = a[0]
 			}
-			card, err := partialUUID(a[0])
+			card, err := partialUUID(arg)
 			if err != nil {
 				return err
 			}
@@ -1327,6 +3199,7 @@ ignored if -ia is passed. {fn} is replaced by the filename and {pid} with the pr
 			if !ok {
 				return errors.New("failed to fetch price")
 			}
+			view.Cards = append(view.Cards, card)
 			if n.T == o.T {
 				printAlert("price already up to date")
 				return nil
@@ -1339,6 +3212,7 @@ ignored if -ia is passed. {fn} is replaced by the filename and {pid} with the pr
 			if len(args) == 0 && state.Mode != ModeCollection {
 				modifyState(true, func(s State) State {
 					s.Tags = nil
+					s.Place = ""
 					return s
 				})
 			}
@@ -1349,12 +3223,23 @@ ignored if -ia is passed. {fn} is replaced by the filename and {pid} with the pr
 
 			switch state.Mode {
 			case ModeCollection:
+				cards := state.Local
+				if len(args) != 0 && args[0] == "." {
+					c, err := cursorLocal()
+					if err != nil {
+						return err
+					}
+					cards, args = []LocalCard{c}, args[1:]
+				}
 				tags := make([]Tagging, 0, len(args))
 				for _, arg := range args {
+					if strings.HasPrefix(arg, "in:") {
+						return errors.New("use /place to put cards in your collection in a container")
+					}
 					if len(arg) < 2 || (arg[0] != '-' && arg[0] != '+') {
 						return fmt.Errorf("'%s' is no a valid tag specifier", arg)
 					}
-					for _, c := range state.Local {
+					for _, c := range cards {
 						t := NewTagging(c.DBCard)
 						t.Add(arg[0] == '+', arg[1:])
 						tags = append(tags, t)
@@ -1370,10 +3255,18 @@ ignored if -ia is passed. {fn} is replaced by the filename and {pid} with the pr
 					return s
 				})
 
-				printAlert(fmt.Sprintf("Updated %d card(s)", len(state.Local)))
+				printAlert(fmt.Sprintf("Updated %d card(s)", len(cards)))
 			case ModeAdd:
 				tags := make([]string, 0, len(args))
+				place := ""
 				for _, arg := range args {
+					if strings.HasPrefix(arg, "in:") {
+						if _, ok := state.FindContainer(app.DB, arg[3:]); !ok {
+							return fmt.Errorf("no such container '%s', see /container", arg[3:])
+						}
+						place = arg[3:]
+						continue
+					}
 					if len(arg) < 2 || (arg[0] != '-' && arg[0] != '+') {
 						return fmt.Errorf("'%s' is no a valid tag specifier", arg)
 					}
@@ -1383,6 +3276,7 @@ ignored if -ia is passed. {fn} is replaced by the filename and {pid} with the pr
 				}
 				modifyState(true, func(s State) State {
 					s.Tags = tags
+					s.Place = place
 					return s
 				})
 			}
@@ -1390,13 +3284,33 @@ ignored if -ia is passed. {fn} is replaced by the filename and {pid} with the pr
 			return nil
 		},
 		"delete": func(a []string) error {
-			if len(a) != 0 {
-				return errors.New("/delete doesn't take any arguments")
+			if len(a) > 1 || (len(a) == 1 && a[0] != ".") {
+				return errors.New("/delete only takes . as an argument")
 			}
 			if state.Mode != ModeCollection {
 				return errors.New("/delete can only be used from /mode collection")
 			}
 
+			if len(a) == 1 {
+				c, err := cursorLocal()
+				if err != nil {
+					return err
+				}
+				printAlert(fmt.Sprintf("Deleted '%s'", c.Name()))
+				modifyState(true, func(s State) State {
+					s.Delete = append(s.Delete, c)
+					local := make([]LocalCard, 0, len(s.Local))
+					for _, l := range s.Local {
+						if l.DBCard != c.DBCard {
+							local = append(local, l)
+						}
+					}
+					s.Local = local
+					return s
+				})
+				return nil
+			}
+
 			printAlert(fmt.Sprintf("Deleted %d cards", len(state.Local)))
 			modifyState(true, func(s State) State {
 				s.Query = nil
@@ -1422,6 +3336,21 @@ ignored if -ia is passed. {fn} is replaced by the filename and {pid} with the pr
 	commands["del"] = commands["delete"]
 
 	var handleCommand func(f []string) (bool, error)
+	for name, alias := range config.Aliases {
+		if _, ok := commands[name]; ok {
+			exit(fmt.Errorf("config: alias '%s' shadows an existing command", name))
+		}
+		target := commandLine(alias)
+		if len(target) == 0 {
+			exit(fmt.Errorf("config: alias '%s' is empty", name))
+		}
+		// targets are checked once macros are registered as well.
+		commands[name] = func(args []string) error {
+			_, err := handleCommand(append(append([]string{}, target...), args...))
+			return err
+		}
+	}
+
 	handleCommand = func(f []string) (bool, error) {
 		isCommand := false
 		if len(f) == 0 {
@@ -1443,24 +3372,11 @@ ignored if -ia is passed. {fn} is replaced by the filename and {pid} with the pr
 	}
 
 	searchAll := func() []Card {
-		qry := strings.Join(state.Query, " ")
-		res := fuzz.Search(qry, func(score, min, max int) bool {
-			return score > 0 && score == max
-		})
-
-		list := make([]Card, 0, len(res))
-		for _, ix := range res {
-			if state.FilterSet != "" && app.Cards.Cards[ix].SetCode != state.FilterSet {
-				continue
-			}
-			list = append(list, app.Cards.Cards[ix])
-		}
-
-		return list
+		return app.SearchAll(strings.Join(state.Query, " "), state.FilterSet)
 	}
 
-	numericRE := regexp.MustCompile(`^\d+[,\-]?\d*$`)
 	searchLocal := func() ([]LocalCard, error) {
+		// only the last range is used, move it to the end of the query
 		var lastNumeric string
 		for i := 0; i < len(state.Query); i++ {
 			if numericRE.MatchString(state.Query[i]) {
@@ -1469,171 +3385,15 @@ ignored if -ia is passed. {fn} is replaced by the filename and {pid} with the pr
 				i--
 			}
 		}
-		filters := []func(c LocalCard) bool{
-			func(c LocalCard) bool {
-				return state.FilterSet == "" || c.SetID() == state.FilterSet
-			},
-		}
-
-		qry := state.Query
-		qryTags := make([]string, 0, len(qry))
-		qryNotTags := make([]string, 0, len(qry))
-		qryMana := make([]string, 0, len(qry))
-		qryKeywords := make([]string, 0, len(qry))
-		_qryStr := make([]string, 0, len(qry))
-		for _, p := range qry {
-			switch {
-			case len(p) == 0:
-				continue
-			case p[0] == '+':
-				qryTags = append(qryTags, p[1:])
-			case p[0] == '-':
-				qryNotTags = append(qryNotTags, p[1:])
-			case p[0] == '{' && p[len(p)-1] == '}':
-				// case len(p) == 3 && p[0] == '{' && p[len(p)-1] == '}':
-				qryMana = append(qryMana, strings.ToUpper(p[1:len(p)-1]))
-			case p[0] == '#':
-				qryKeywords = append(qryKeywords, strings.ToLower(p[1:]))
-			default:
-				_qryStr = append(_qryStr, p)
-			}
-		}
-		qryStr := strings.Join(_qryStr, " ")
-
-		if len(qryTags) != 0 || len(qryNotTags) != 0 {
-			filters = append(filters, func(c LocalCard) bool {
-				for _, t := range qryTags {
-					if !c.HasTag(t) {
-						return false
-					}
-				}
-				for _, t := range qryNotTags {
-					if c.HasTag(t) {
-						return false
-					}
-				}
-				return true
-			})
-		}
-
-		if len(qryMana) != 0 {
-			has := make([]byte, 0)
-			nhas := make([]byte, 0)
-			for _, qry := range qryMana {
-				p := strings.Split(qry, ",")
-				for _, color := range p {
-					if color == "" {
-						continue
-					}
-					f := color[0]
-					if f == '+' || f == '-' {
-						color = color[1:]
-					}
-					if f == '-' {
-						nhas = append(nhas, color...)
-						continue
-					}
-					has = append(has, color...)
-				}
-			}
-			filters = append(filters, func(c LocalCard) bool {
-				rc, _ := app.Cards.ByUUID(c.UUID())
-				d := []byte{'{', 0, '}'}
-				for _, m := range has {
-					d[1] = m
-					if !strings.Contains(rc.ManaCost, string(d)) {
-						return false
-					}
-				}
-				for _, m := range nhas {
-					d[1] = m
-					if strings.Contains(rc.ManaCost, string(d)) {
-						return false
-					}
-				}
-				return true
-			})
-		}
-
-		if len(qryKeywords) != 0 {
-			filters = append(filters, func(c LocalCard) bool {
-				rc, _ := app.Cards.ByUUID(c.UUID())
-				for _, m := range qryKeywords {
-					match := false
-					for _, kw := range rc.Keywords {
-						if strings.Contains(strings.ToLower(kw), m) {
-							match = true
-						}
-					}
-					for _, kw := range rc.Types {
-						if strings.Contains(strings.ToLower(kw), m) {
-							match = true
-						}
-					}
-					if !match {
-						return false
-					}
-				}
-				return true
-			})
-		}
-
-		search := func() []int {
-			return localFuzz.Search(qryStr, func(score, min, max int) bool {
-				return score > 0 && score == max
-			})
-		}
-
-		if qryStr == "" {
-			search = func() []int {
-				all := app.DB.Cards()
-				list := make([]int, 0, len(all))
-				for i := range all {
-					list = append(list, i)
-				}
-				return list
-			}
-		}
-
 		if lastNumeric != "" {
-			ints, ok := intRange(lastNumeric)
-			m := make(map[int]struct{}, len(ints))
-			for _, i := range ints {
-				m[i-1] = struct{}{}
-			}
-			if ok {
-				filters = append(filters, func(c LocalCard) bool {
-					_, ok := m[c.Index]
-					return ok
-				})
-			}
 			state.Query = append(state.Query, lastNumeric)
 		}
 
-		res := search()
-		list := make([]LocalCard, 0, len(res))
-		for _, ix := range res {
-			c, ok := app.DB.CardAt(ix)
-			if !ok {
-				continue
-			}
-			ok = true
-			lc := NewLocalCard(c, ix)
-			for _, f := range filters {
-				if !f(lc) {
-					ok = false
-					break
-				}
-			}
-			if ok {
-				list = append(list, lc)
-			}
-		}
-
-		return list, nil
+		return app.SearchLocal(state.Query, state.FilterSet), nil
 	}
 
-	handleInputLine := func(line string) {
+	var handleInputLine func(line string)
+	handleInputLine = func(line string) {
 		line = strings.TrimSpace(line)
 		fields := strings.Fields(line)
 
@@ -1740,22 +3500,15 @@ ignored if -ia is passed. {fn} is replaced by the filename and {pid} with the pr
 
 		case ModeSelect:
 			state.Filtered = true
-			sel := make([]Card, 0, 1)
-			for _, c := range state.Options {
-				if strings.Contains(strings.ToLower(string(c.UUID)), strings.ToLower(line)) {
-					sel = append(sel, c)
-				}
-			}
-
-			if len(sel) > 1 {
+			if line == "" {
 				printOptions()
-				if line != "" {
-					printErr(errors.New("multiple matches, try more specific query"))
-				}
 				return
-			} else if len(sel) == 0 {
+			}
+
+			sel, err := selectOptions(line)
+			if err != nil {
 				printOptions()
-				printErr(errors.New("no card matches that uuid"))
+				printErr(err)
 				return
 			}
 
@@ -1769,52 +3522,224 @@ ignored if -ia is passed. {fn} is replaced by the filename and {pid} with the pr
 		}
 	}
 
+	// readLine reads a line from stdin without buffering, anything after it
+	// is left for the editor.
+	readLine := func() string {
+		line := make([]byte, 0, 8)
+		b := make([]byte, 1)
+		for {
+			_, err := io.ReadFull(os.Stdin, b)
+			exit(err)
+			if b[0] == 10 {
+				return strings.TrimSpace(string(line))
+			}
+			line = append(line, b[0])
+		}
+	}
+
 	if !skipIntro {
 		fmt.Printf("GOMTG Version: %s\n", GitVersion)
 		fmt.Println("Type /help for usage information")
 		fmt.Println("Type /exit to quit")
 		fmt.Println("press enter to continue...")
-		func() {
-			b := make([]byte, 1)
-			for {
-				_, err := io.ReadFull(os.Stdin, b)
-				exit(err)
-				if b[0] == 10 {
-					break
-				}
-			}
-		}()
+		readLine()
 	}
 
 	prompt := func() {
+		hint, p := "", "> "
 		switch state.Mode {
 		case ModeAdd:
-			printDiv()
-			print("Search all and add to collection")
-			print("> ")
+			hint = "Search all and add to collection"
 		case ModeCollection:
-			printDiv()
-			print("Search collection (card name or range)")
-			print("> ")
+			hint = "Search collection (card name or range)"
 		case ModeSearch:
-			printDiv()
-			print("Search all")
-			print("> ")
+			hint = "Search all"
 		case ModeSelect:
-			printDiv()
-			print("Enter (partial) UUID to select a card")
+			hint = "Enter (partial) UUIDs, positions (#1,#2,#8-10), * or . to select cards"
 			listID := cardListID(state.Options)
 			if lastImageListID != listID {
-				print("Run /images to view images")
+				hint += ", run /images to view images"
 			}
-			print("UUID > ")
-		default:
-			printDiv()
-			print("> ")
+			p = "UUID > "
+		}
+
+		screen.Hint = fmt.Sprintf("%s %s \033[0m", app.Colors.Get("status"), hint)
+		screen.Prompt = p
+		if editor != nil {
+			editor.SetPrompt(p)
 		}
 		flush()
 	}
 
+	macroDepth := 0
+	for name, lines := range config.Macros {
+		if _, ok := commands[name]; ok {
+			exit(fmt.Errorf("config: macro '%s' shadows an existing command or alias", name))
+		}
+		name, lines := name, lines
+		commands[name] = func(args []string) error {
+			if len(args) != 0 {
+				return fmt.Errorf("macro /%s does not take arguments", name)
+			}
+			if macroDepth > 8 {
+				return errors.New("macros nested too deeply")
+			}
+			macroDepth++
+			for _, l := range lines {
+				handleInputLine(l)
+			}
+			macroDepth--
+			return nil
+		}
+	}
+
+	// aliases can refer to commands, other aliases and macros so they are
+	// only checked once all of them are registered.
+	for name, alias := range config.Aliases {
+		seen := map[string]struct{}{name: {}}
+		for target := commandLine(alias); len(target) != 0; {
+			if !strings.HasPrefix(target[0], "/") {
+				exit(fmt.Errorf("config: alias '%s' does not start with a /command: '%s'", name, target[0]))
+			}
+			cmd := target[0][1:]
+			if _, ok := commands[cmd]; !ok {
+				exit(fmt.Errorf("config: alias '%s' refers to an unknown command '%s'", name, target[0]))
+			}
+			next, ok := config.Aliases[cmd]
+			if !ok {
+				break
+			}
+			if _, ok := seen[cmd]; ok {
+				exit(fmt.Errorf("config: alias '%s' refers to itself through '/%s'", name, cmd))
+			}
+			seen[cmd] = struct{}{}
+			target = commandLine(next)
+		}
+	}
+
+	if batch {
+		lines := flag.Args()
+		if len(lines) == 0 {
+			var err error
+			lines, err = readBatch(batchFile)
+			exit(err)
+		}
+
+		enc := json.NewEncoder(os.Stdout)
+		for _, line := range lines {
+			view, errs = batchView{}, errs[:0]
+			handleInputLine(line)
+
+			switch format {
+			case OutputJSON:
+				res := Result{Input: line, Mode: state.Mode, Errors: errs}
+				for _, o := range output {
+					res.Output = append(res.Output, csiRE.ReplaceAllString(o, ""))
+				}
+				cards := view.Cards
+				if view.Options {
+					if state.Mode == ModeCollection {
+						res.Cards = app.ResultLocalCards(state.Local)
+					} else {
+						cards = append(append([]Card{}, state.Options...), cards...)
+					}
+				}
+				res.Cards = append(res.Cards, app.ResultCards(cards)...)
+				if view.Queue {
+					res.Queue = app.ResultQueue(state)
+				}
+				exit(enc.Encode(res))
+			default:
+				if view.Options {
+					if state.Mode == ModeCollection {
+						print(app.LocalCardsString(state.Local, 0, false)...)
+					} else {
+						print(app.CardsString(state.Options, 0, false)...)
+					}
+				}
+				print(app.CardsString(view.Cards, 0, false)...)
+				for _, o := range output {
+					if plain {
+						o = csiRE.ReplaceAllString(o, "")
+					}
+					fmt.Println(o)
+				}
+			}
+			output = output[:0]
+
+			if len(errs) != 0 {
+				exit(fmt.Errorf("%s: %s", line, errs[0]))
+			}
+		}
+
+		if state.Changes() {
+			fmt.Fprintln(os.Stderr, "discarding uncommitted changes, end your commands with /commit to save them")
+		}
+		cleanup()
+		os.Exit(0)
+	}
+
+	journalPath := journalFile(dbFile, currentUser(), newSession())
+	if unlock, err := lockJournal(journalPath); err != nil {
+		printErr(err)
+	} else {
+		unlockJournal = unlock
+	}
+
+	orphans, err := OrphanedJournals(dbFile, currentUser())
+	printErr(err)
+	var restored []OrphanedJournal
+	for _, j := range orphans {
+		if j.Empty() {
+			printErr(j.Discard())
+			continue
+		}
+		fmt.Printf(
+			"Found uncommitted changes of a session that is no longer running from %s: %s\n",
+			j.Time.Format("2006-01-02 15:04:05"),
+			j,
+		)
+		fmt.Print("Restore them? [Y/n] ")
+		switch strings.ToLower(readLine()) {
+		case "", "y", "yes":
+			var skipped int
+			modifyState(true, func(s State) State {
+				s, skipped = j.Apply(app, s)
+				return s
+			})
+			printAlert(fmt.Sprintf("restored %s, see /queue", j))
+			if skipped != 0 {
+				printErr(fmt.Errorf("skipped %d changes to cards that are no longer in the database", skipped))
+			}
+			restored = append(restored, j)
+		default:
+			printErr(j.Discard())
+		}
+	}
+
+	var lastJournal []byte
+	syncJournal := func() {
+		j := NewJournal(state, app.DB)
+		data, err := json.Marshal(j)
+		if err != nil || bytes.Equal(data, lastJournal) {
+			return
+		}
+		lastJournal = data
+		if j.Empty() {
+			if err := os.Remove(journalPath); err != nil && !os.IsNotExist(err) {
+				printErr(err)
+			}
+			return
+		}
+		j.Time = time.Now()
+		printErr(j.Write(journalPath))
+	}
+	syncJournal()
+	// now that they are in the journal of this session.
+	for _, j := range restored {
+		printErr(j.Discard())
+	}
+
 	inputCh := make(chan string, 1)
 	args := flag.Args()
 	go func() {
@@ -1826,7 +3751,154 @@ ignored if -ia is passed. {fn} is replaced by the filename and {pid} with the pr
 		}
 	}()
 
+	type scrollEvent struct {
+		n    int
+		page bool
+	}
+	scrollCh := make(chan scrollEvent)
+	resizeCh := make(chan os.Signal, 1)
+	notifyResize(resizeCh)
+
+	type completion struct {
+		line []rune
+		pos  int
+		res  chan []string
+		from chan int
+	}
+	completeCh := make(chan completion)
+
+	complete := func(line []rune, pos int) (int, []string) {
+		start := pos
+		for start > 0 && line[start-1] != ' ' {
+			start--
+		}
+		word := string(line[start:pos])
+		fields := strings.Fields(string(line[:start]))
+
+		prefixed := func(list []string, prefix string) []string {
+			n := make([]string, 0, len(list))
+			for _, item := range list {
+				if strings.HasPrefix(strings.ToLower(item), strings.ToLower(prefix)) {
+					n = append(n, item)
+				}
+			}
+			sort.Strings(n)
+			return n
+		}
+
+		cmd := ""
+		if len(fields) != 0 && strings.HasPrefix(fields[0], "/") {
+			cmd = fields[0][1:]
+		}
+
+		switch {
+		case len(fields) == 0 && strings.HasPrefix(word, "/"):
+			list := make([]string, 0, len(commands))
+			for name := range commands {
+				list = append(list, "/"+name)
+			}
+			return start, prefixed(list, word)
+		case len(fields) == 1 && (cmd == "set" || cmd == "s"):
+			list := make([]string, 0, len(app.Cards.Sets))
+			for set := range app.Cards.Sets {
+				list = append(list, string(set))
+			}
+			return start, prefixed(list, word)
+		case len(fields) == 1 && (cmd == "mode" || cmd == "m"):
+			list := make([]string, 0, len(AllInputModes))
+			for mode := range AllInputModes {
+				list = append(list, string(mode))
+			}
+			return start, prefixed(list, word)
+		case len(fields) == 1 && cmd == "sort":
+			list := make([]string, 0, len(Sorts))
+			for sorting := range Sorts {
+				list = append(list, string(sorting))
+			}
+			return start, prefixed(list, word)
+		case len(word) != 0 && (word[0] == '+' || word[0] == '-'):
+			tags := make(Tags)
+			for _, c := range app.DB.Cards() {
+				tags.Add(c.Tags())
+			}
+			list := make([]string, 0, len(tags))
+			for _, tag := range tags.Slice() {
+				list = append(list, word[0:1]+tag)
+			}
+			return start, prefixed(list, word)
+		case cmd != "", state.Mode == ModeSelect:
+			return start, nil
+		}
+
+		// card names can contain spaces, complete everything after the
+		// last tag, mana or keyword filter.
+		start = 0
+		for i := 0; i < pos; i++ {
+			if line[i] == ' ' || (i != 0 && line[i-1] != ' ') {
+				continue
+			}
+			if strings.ContainsRune("+-{#", line[i]) {
+				for i < pos && line[i] != ' ' {
+					i++
+				}
+				start = i
+			}
+		}
+		for start < pos && line[start] == ' ' {
+			start++
+		}
+		qry := string(line[start:pos])
+		if len(qry) < 2 {
+			return start, nil
+		}
+
+		index, names := app.fuzz, func(ix int) string { return app.Cards.Cards[ix].Name }
+		if state.Mode == ModeCollection {
+			all := app.DB.Cards()
+			index, names = app.localFuzz, func(ix int) string { return all[ix].Name() }
+		}
+
+		res := index.Search(qry, func(score, min, max int) bool {
+			return score > 0 && score == max
+		})
+		seen := make(map[string]struct{}, len(res))
+		fuzzy := make([]string, 0, len(res))
+		for _, ix := range res {
+			name := names(ix)
+			if _, ok := seen[name]; ok {
+				continue
+			}
+			seen[name] = struct{}{}
+			fuzzy = append(fuzzy, name)
+		}
+
+		if list := prefixed(fuzzy, qry); len(list) != 0 {
+			return start, list
+		}
+		sort.Strings(fuzzy)
+		return start, fuzzy
+	}
+
+	if con, err := console.ConsoleFromFile(os.Stdin); err == nil {
+		editor, err = NewEditor(con, os.Stdin, os.Stdout, dbFile+".history")
+		exit(err)
+		editor.SetCompleter(func(line []rune, pos int) (int, []string) {
+			c := completion{line, pos, make(chan []string, 1), make(chan int, 1)}
+			completeCh <- c
+			return <-c.from, <-c.res
+		})
+		editor.OnInterrupt(func() { go func() { cancelCh <- struct{}{} }() })
+		editor.OnEOF(func() { inputCh <- "/exit" })
+		editor.OnScroll(func(n int, page bool) { scrollCh <- scrollEvent{n, page} })
+		stdout = editor
+	}
+
 	go func() {
+		if editor != nil {
+			exit(editor.Run(inputCh))
+			return
+		}
+
 		for {
 			scan := bufio.NewScanner(os.Stdin)
 			scan.Split(bufio.ScanLines)
@@ -1839,7 +3911,18 @@ ignored if -ia is passed. {fn} is replaced by the filename and {pid} with the pr
 
 	for {
 		select {
+		case c := <-completeCh:
+			from, list := complete(c.line, c.pos)
+			c.from <- from
+			c.res <- list
+		case e := <-scrollCh:
+			scroll(e.n, e.page)
+		case <-resizeCh:
+			render()
+		case f := <-webCh:
+			f()
 		case <-cancelCh:
+			pager, inlineImage = false, nil
 			modifyState(true, func(s State) State {
 				switch s.Mode {
 				case ModeSelect:
@@ -1851,9 +3934,12 @@ ignored if -ia is passed. {fn} is replaced by the filename and {pid} with the pr
 				}
 				return s
 			})
+			syncJournal()
 			prompt()
 		case txt := <-inputCh:
+			pager, inlineImage = false, nil
 			handleInputLine(txt)
+			syncJournal()
 			prompt()
 		}
 	}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/png"
+	"os"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+// Graphics is a protocol to draw images inline in the terminal.
+type Graphics string
+
+const (
+	GraphicsAuto   Graphics = "auto"
+	GraphicsNone   Graphics = "none"
+	GraphicsKitty  Graphics = "kitty"
+	GraphicsITerm  Graphics = "iterm"
+	GraphicsSixel  Graphics = "sixel"
+	GraphicsBlocks Graphics = "blocks"
+)
+
+var GraphicsAll = []Graphics{
+	GraphicsAuto,
+	GraphicsNone,
+	GraphicsKitty,
+	GraphicsITerm,
+	GraphicsSixel,
+	GraphicsBlocks,
+}
+
+func (g Graphics) Valid() bool {
+	for _, v := range GraphicsAll {
+		if g == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Inline returns true if images are drawn as escape sequences over the pane
+// instead of as regular text rows.
+func (g Graphics) Inline() bool {
+	return g == GraphicsKitty || g == GraphicsITerm || g == GraphicsSixel
+}
+
+// Clear returns the sequence that removes previously drawn images.
+func (g Graphics) Clear() string {
+	if g == GraphicsKitty {
+		return "\033_Ga=d,q=2\033\\"
+	}
+	return ""
+}
+
+// DetectGraphics guesses the best supported protocol from the environment.
+func DetectGraphics() Graphics {
+	term := os.Getenv("TERM")
+	prog := os.Getenv("TERM_PROGRAM")
+	switch {
+	case os.Getenv("KITTY_WINDOW_ID") != "" || term == "xterm-kitty":
+		return GraphicsKitty
+	case prog == "iTerm.app" || prog == "WezTerm" || os.Getenv("LC_TERMINAL") == "iTerm2":
+		return GraphicsITerm
+	case strings.Contains(term, "sixel") ||
+		strings.HasPrefix(term, "foot") ||
+		strings.HasPrefix(term, "mlterm") ||
+		strings.HasPrefix(term, "yaft"):
+		return GraphicsSixel
+	}
+
+	switch os.Getenv("COLORTERM") {
+	case "truecolor", "24bit":
+		return GraphicsBlocks
+	}
+	return GraphicsNone
+}
+
+// Render scales img to fit within cols x rows terminal cells of cellW x cellH
+// pixels each and encodes it. Inline protocols return a single escape
+// sequence, GraphicsBlocks returns one string per row.
+func (g Graphics) Render(img image.Image, cols, rows, cellW, cellH int) ([]string, error) {
+	switch g {
+	case GraphicsKitty:
+		data, err := encodePNG(fitImage(img, cols*cellW, rows*cellH))
+		if err != nil {
+			return nil, err
+		}
+		return []string{kittyImage(data)}, nil
+	case GraphicsITerm:
+		scaled := fitImage(img, cols*cellW, rows*cellH)
+		data, err := encodePNG(scaled)
+		if err != nil {
+			return nil, err
+		}
+		b := scaled.Bounds()
+		return []string{fmt.Sprintf(
+			"\033]1337;File=inline=1;size=%d;width=%dpx;height=%dpx;preserveAspectRatio=1:%s\a",
+			len(data),
+			b.Dx(),
+			b.Dy(),
+			base64.StdEncoding.EncodeToString(data),
+		)}, nil
+	case GraphicsSixel:
+		// a sixel band is 6 pixels high, make sure the last one does not
+		// spill into the rows below the pane.
+		h := rows * cellH
+		h -= h % 6
+		return []string{sixelImage(fitImage(img, cols*cellW, h))}, nil
+	case GraphicsBlocks:
+		return halfBlocks(fitImage(img, cols, rows*2)), nil
+	}
+
+	return nil, fmt.Errorf("graphics protocol '%s' can not render images", g)
+}
+
+func fitImage(img image.Image, width, height int) *image.NRGBA {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w > width {
+		w, h = width, h*width/w
+	}
+	if h > height {
+		w, h = w*height/h, height
+	}
+	if w < 1 {
+		w = 1
+	}
+	if h < 1 {
+		h = 1
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.ApproxBiLinear.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst
+}
+
+func encodePNG(img image.Image) ([]byte, error) {
+	buf := bytes.NewBuffer(nil)
+	err := png.Encode(buf, img)
+	return buf.Bytes(), err
+}
+
+func kittyImage(data []byte) string {
+	const chunk = 4096
+	enc := base64.StdEncoding.EncodeToString(data)
+	var b strings.Builder
+	for i := 0; i < len(enc); i += chunk {
+		end := i + chunk
+		more := 1
+		if end >= len(enc) {
+			end, more = len(enc), 0
+		}
+		if i == 0 {
+			fmt.Fprintf(&b, "\033_Ga=T,f=100,q=2,m=%d;%s\033\\", more, enc[i:end])
+			continue
+		}
+		fmt.Fprintf(&b, "\033_Gm=%d;%s\033\\", more, enc[i:end])
+	}
+	return b.String()
+}
+
+func sixelImage(img image.Image) string {
+	b := img.Bounds()
+	pal := image.NewPaletted(b, palette.WebSafe)
+	draw.FloydSteinberg.Draw(pal, b, img, b.Min)
+
+	var s strings.Builder
+	s.WriteString("\033P0;1;0q")
+	fmt.Fprintf(&s, "\"1;1;%d;%d", b.Dx(), b.Dy())
+	for i, c := range pal.Palette {
+		r, g, bl, _ := c.RGBA()
+		fmt.Fprintf(&s, "#%d;2;%d;%d;%d", i, r*100/0xffff, g*100/0xffff, bl*100/0xffff)
+	}
+
+	bands := make(map[uint8][]byte)
+	order := make([]uint8, 0, len(pal.Palette))
+	for y := 0; y < b.Dy(); y += 6 {
+		order = order[:0]
+		for dy := 0; dy < 6 && y+dy < b.Dy(); dy++ {
+			for x := 0; x < b.Dx(); x++ {
+				ix := pal.ColorIndexAt(b.Min.X+x, b.Min.Y+y+dy)
+				row, ok := bands[ix]
+				if !ok {
+					row = make([]byte, b.Dx())
+					bands[ix] = row
+					order = append(order, ix)
+				}
+				row[x] |= 1 << dy
+			}
+		}
+
+		for i, ix := range order {
+			row := bands[ix]
+			for x := range row {
+				row[x] += '?'
+			}
+			if i != 0 {
+				s.WriteByte('$')
+			}
+			fmt.Fprintf(&s, "#%d", ix)
+			sixelRLE(&s, row)
+			delete(bands, ix)
+		}
+		s.WriteByte('-')
+	}
+	s.WriteString("\033\\")
+	return s.String()
+}
+
+func sixelRLE(s *strings.Builder, row []byte) {
+	for i := 0; i < len(row); {
+		n := 1
+		for i+n < len(row) && row[i+n] == row[i] {
+			n++
+		}
+		if n > 3 {
+			fmt.Fprintf(s, "!%d%c", n, row[i])
+		} else {
+			s.Write(row[i : i+n])
+		}
+		i += n
+	}
+}
+
+// halfBlocks draws two pixels per cell using the upper half block with a
+// truecolor foreground and background.
+func halfBlocks(img *image.NRGBA) []string {
+	b := img.Bounds()
+	rows := make([]string, 0, (b.Dy()+1)/2)
+	for y := b.Min.Y; y < b.Max.Y; y += 2 {
+		var s strings.Builder
+		for x := b.Min.X; x < b.Max.X; x++ {
+			top := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			bottom := color.NRGBA{}
+			if y+1 < b.Max.Y {
+				bottom = color.NRGBAModel.Convert(img.At(x, y+1)).(color.NRGBA)
+			}
+			fmt.Fprintf(
+				&s,
+				"\033[38;2;%d;%d;%dm\033[48;2;%d;%d;%dm▀",
+				top.R, top.G, top.B,
+				bottom.R, bottom.G, bottom.B,
+			)
+		}
+		s.WriteString("\033[0m")
+		rows = append(rows, s.String())
+	}
+	return rows
+}
@@ -11,14 +11,14 @@ import (
 	"image"
 	"image/color"
 	"image/jpeg"
-	_ "image/jpeg"
-	_ "image/png"
+	"image/png"
 	"io"
 	"math"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -85,96 +85,250 @@ func downloadImage(url string, w ...io.Writer) error {
 	return err
 }
 
-func getImageCached(url string, dir string) (image.Image, error) {
-	return _getImageCached(url, dir, true)
+// imageCachePath returns the path url is cached at in dir.
+func imageCachePath(url string, dir string) string {
+	s := sha256.Sum256([]byte(url))
+	return filepath.Join(dir, hex.EncodeToString(s[:])+".jpg")
 }
 
-func _getImageCached(url string, dir string, decode bool) (image.Image, error) {
-	s := sha256.Sum256([]byte(url))
-	h := hex.EncodeToString(s[:])
-	path := filepath.Join(dir, h+".jpg")
-	f, err := os.Open(path)
-	if err == nil {
-		defer f.Close()
-		if !decode {
-			return nil, nil
-		}
-		img, _, err := image.Decode(f)
-		return img, err
+type ImageFormat string
+
+const (
+	FormatJPEG ImageFormat = "jpeg"
+	FormatPNG  ImageFormat = "png"
+	FormatWebP ImageFormat = "webp"
+)
+
+func (f ImageFormat) Valid() bool {
+	return f == FormatJPEG || f == FormatPNG || f == FormatWebP
+}
+
+// Ext returns the file extension for f including the leading dot.
+func (f ImageFormat) Ext() string {
+	if f == FormatJPEG {
+		return ".jpg"
 	}
-	f.Close()
+	return "." + string(f)
+}
 
-	if os.IsNotExist(err) {
-		tmp := tmpFile(path)
-		f, err = os.Create(tmp)
-		if err != nil {
-			return nil, err
+func encodeImage(w io.Writer, img image.Image, f ImageFormat) error {
+	switch f {
+	case FormatPNG:
+		return png.Encode(w, img)
+	case FormatWebP:
+		return encodeWebP(w, img)
+	}
+	return jpeg.Encode(w, img, &jpeg.Options{Quality: 80})
+}
+
+// Overlay is a field drawn over each card in a collage.
+type Overlay string
+
+const (
+	OverlayUUID  Overlay = "uuid"
+	OverlayName  Overlay = "name"
+	OverlaySet   Overlay = "set"
+	OverlayPrice Overlay = "price"
+	OverlayCount Overlay = "count"
+	OverlayTags  Overlay = "tags"
+	OverlayIndex Overlay = "index"
+)
+
+var Overlays = map[Overlay]struct{}{
+	OverlayUUID:  {},
+	OverlayName:  {},
+	OverlaySet:   {},
+	OverlayPrice: {},
+	OverlayCount: {},
+	OverlayTags:  {},
+	OverlayIndex: {},
+}
+
+// ParseOverlays parses a comma separated list of overlays.
+func ParseOverlays(str string) ([]Overlay, error) {
+	list := make([]Overlay, 0, len(Overlays))
+	for _, v := range strings.Split(str, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
 		}
-		var pr io.Reader
-		writers := []io.Writer{f}
-		if decode {
-			var pw io.WriteCloser
-			pr, pw = io.Pipe()
-			writers = append(writers, pw)
+		if _, ok := Overlays[Overlay(v)]; !ok {
+			return nil, fmt.Errorf("invalid overlay '%s'", v)
 		}
-		var gerr error
-		done := make(chan struct{}, 1)
-		go func() {
-			err := downloadImage(url, writers...)
-			for _, w := range writers {
-				if c, ok := w.(io.Closer); ok {
-					c.Close()
-				}
-			}
-			if err != nil {
-				gerr = err
-				os.Remove(tmp)
-				done <- struct{}{}
-				return
-			}
-			gerr = os.Rename(tmp, path)
-			if gerr != nil {
-				os.Remove(tmp)
-			}
-			done <- struct{}{}
-		}()
+		list = append(list, Overlay(v))
+	}
+	return list, nil
+}
 
-		if pr == nil {
-			<-done
-			return nil, gerr
-		}
+// ImageSizes are the scryfall image versions that can be used in a collage.
+var ImageSizes = map[string]struct{}{
+	"small":  {},
+	"normal": {},
+	"large":  {},
+}
 
-		img, _, err := image.Decode(pr)
-		if err != nil {
-			return nil, err
+// Collage configures the images generated by genImages.
+type Collage struct {
+	Columns  int // <= 0: square grid
+	MaxWidth int // <= 0: unlimited
+	PerPage  int
+	Size     string
+	Format   ImageFormat
+	Overlays []Overlay
+}
+
+func (c Collage) Has(o Overlay) bool {
+	for _, v := range c.Overlays {
+		if v == o {
+			return true
 		}
-		<-done
-		return img, gerr
 	}
+	return false
+}
 
-	return nil, err
+// Pages returns the amount of pages needed for n cards.
+func (c Collage) Pages(n int) int {
+	if c.PerPage <= 0 || n == 0 {
+		return 1
+	}
+	return (n + c.PerPage - 1) / c.PerPage
 }
 
-func addUUIDsToCollage(cols, rows int, canvas *image.NRGBA, cards []Card, imgs []image.Rectangle) error {
-	fontLSrc := image.NewUniform(color.NRGBA{30, 0, 0, 180})
-	fontHSrc := image.NewUniform(color.NRGBA{200, 0, 0, 255})
-	fontBGSrc := image.NewUniform(color.NRGBA{204, 204, 204, 180})
-	//face := basicfont.Face7x13
-	fontScale := 1.0
+// Page returns the bounds of the given (0-based) page of n cards.
+func (c Collage) Page(page, n int) (int, int) {
+	if c.PerPage <= 0 {
+		return 0, n
+	}
+	from, to := page*c.PerPage, (page+1)*c.PerPage
+	if from > n {
+		from = n
+	}
+	if to > n {
+		to = n
+	}
+	return from, to
+}
 
+func (c Collage) grid(n int) (int, int) {
+	if c.Columns > 0 {
+		cols := c.Columns
+		if cols > n {
+			cols = n
+		}
+		return cols, (n + cols - 1) / cols
+	}
+	cols := math.Ceil(math.Sqrt(float64(n)))
+	return int(cols), int(math.Ceil(float64(n) / cols))
+}
+
+func collageFace(size float64) (font.Face, error) {
 	col, err := opentype.ParseCollection(gobold.TTF)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	gofont, err := col.Font(0)
+	if err != nil {
+		return nil, err
+	}
+
+	return opentype.NewFace(gofont, &opentype.FaceOptions{
+		Size:    size,
+		DPI:     72,
+		Hinting: font.HintingNone,
+	})
+}
+
+// faceWidth returns the width of a single card face in rect.
+func faceWidth(rect image.Rectangle) int {
+	// double faced cards are drawn side by side
+	w := rect.Dx()
+	if fw := rect.Dy() * 488 / 680; fw < w {
+		w = fw
+	}
+	return w
+}
+
+// scaleCollage scales canvas and the card rectangles down to the given width.
+func scaleCollage(canvas *image.NRGBA, rects []image.Rectangle, width int) (*image.NRGBA, []image.Rectangle) {
+	b := canvas.Bounds()
+	if width <= 0 || b.Dx() <= width {
+		return canvas, rects
+	}
+
+	f := float64(width) / float64(b.Dx())
+	scale := func(v int) int { return int(math.Round(float64(v) * f)) }
+	dst := scaleImage(canvas, width, scale(b.Dy()))
+
+	scaled := make([]image.Rectangle, len(rects))
+	for i, r := range rects {
+		scaled[i] = image.Rect(scale(r.Min.X), scale(r.Min.Y), scale(r.Max.X), scale(r.Max.Y))
+	}
+	return dst, scaled
+}
+
+// scaleImage scales img to exactly width x height.
+func scaleImage(img image.Image, width, height int) *image.NRGBA {
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.ApproxBiLinear.Scale(dst, dst.Bounds(), img, img.Bounds(), draw.Src, nil)
+	return dst
+}
+
+// addLabelsToCollage draws the lines in labels at the bottom of each card.
+func addLabelsToCollage(canvas *image.NRGBA, rects []image.Rectangle, labels [][]string) error {
+	narrowest := -1
+	for _, r := range rects {
+		if w := faceWidth(r); narrowest < 0 || w < narrowest {
+			narrowest = w
+		}
+	}
+
+	face, err := collageFace(math.Max(10, float64(narrowest)/20))
 	if err != nil {
 		return err
 	}
 
+	lineHeight := face.Metrics().Height.Ceil()
+	dwr := font.Drawer{
+		Dst:  canvas,
+		Src:  image.NewUniform(color.NRGBA{240, 240, 240, 255}),
+		Face: face,
+	}
+	bg := image.NewUniform(color.NRGBA{0, 0, 0, 180})
+	for ix, dst := range rects {
+		if ix >= len(labels) || len(labels[ix]) == 0 {
+			continue
+		}
+		lines := labels[ix]
+		pad := lineHeight / 3
+		box := image.Rect(
+			dst.Min.X,
+			dst.Max.Y-len(lines)*lineHeight-2*pad,
+			dst.Max.X,
+			dst.Max.Y,
+		)
+		draw.Draw(canvas, box, bg, image.Point{}, draw.Over)
+		for i, l := range lines {
+			dwr.Dot = fixed.P(
+				box.Min.X+pad,
+				box.Min.Y+pad+(i+1)*lineHeight-face.Metrics().Descent.Ceil(),
+			)
+			dwr.DrawString(l)
+		}
+	}
+
+	return nil
+}
+
+func addUUIDsToCollage(cols, rows int, canvas *image.NRGBA, cards []Card, imgs []image.Rectangle) error {
+	fontLSrc := image.NewUniform(color.NRGBA{30, 0, 0, 180})
+	fontHSrc := image.NewUniform(color.NRGBA{200, 0, 0, 255})
+	fontBGSrc := image.NewUniform(color.NRGBA{204, 204, 204, 180})
+	//face := basicfont.Face7x13
+	fontScale := 1.0
+
 	narrowest := -1
 	for _, i := range imgs {
-		w := i.Bounds().Dx()
-		if narrowest < 0 || w < narrowest {
+		if w := faceWidth(i); narrowest < 0 || w < narrowest {
 			narrowest = w
 		}
 	}
@@ -182,11 +336,7 @@ func addUUIDsToCollage(cols, rows int, canvas *image.NRGBA, cards []Card, imgs [
 	b := canvas.Bounds()
 	canvasW, canvasH := b.Dx(), b.Dy()
 
-	face, err := opentype.NewFace(gofont, &opentype.FaceOptions{
-		Size:    math.Max(10, float64(narrowest)/36*1.5),
-		DPI:     72,
-		Hinting: font.HintingNone,
-	})
+	face, err := collageFace(math.Max(10, float64(narrowest)/36*1.5))
 	if err != nil {
 		return err
 	}
@@ -314,31 +464,34 @@ func genCollage(cols, rows int, imgs []image.Image) (*image.NRGBA, []image.Recta
 
 type ImageGetter func(url string) (image.Image, error)
 
-func genImages(cards []Card, file string, getImage ImageGetter, progress func(n, total int)) error {
-	if len(cards) == 0 {
-		return errors.New("no cards to fetch images for")
+// getFaces returns the images of all faces of a card next to each other.
+func getFaces(urls []string, getImage ImageGetter) (image.Image, error) {
+	if len(urls) == 1 {
+		return getImage(urls[0])
 	}
-	grid := float64(len(cards))
-	_cols := math.Ceil(math.Sqrt(grid))
-	_rows := math.Ceil(grid / _cols)
-	cols, rows := int(_cols), int(_rows)
 
-	urls := make([]string, 0, len(cards))
-	for _, c := range cards {
-		u, err := c.ImageURL()
+	imgs := make([]image.Image, len(urls))
+	for i, u := range urls {
+		img, err := getImage(u)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		urls = append(urls, u)
+		imgs[i] = img
 	}
+	canvas, _, err := genCollage(len(imgs), 1, imgs)
+	return canvas, err
+}
 
+// fetchImages downloads the faces in urls concurrently, each entry results
+// in a single image with all of its faces next to each other.
+func fetchImages(urls [][]string, getImage ImageGetter, progress func(n, total int)) ([]image.Image, error) {
 	type result struct {
 		ix int
 		image.Image
 	}
 	type job struct {
-		ix  int
-		url string
+		ix   int
+		urls []string
 	}
 
 	const workers = 4
@@ -346,7 +499,7 @@ func genImages(cards []Card, file string, getImage ImageGetter, progress func(n,
 	results := make(chan result, workers)
 	var wg sync.WaitGroup
 	var gerr error
-	var total = len(cards)
+	var total = len(urls)
 	var dled uint32
 	for i := 0; i < workers; i++ {
 		wg.Add(1)
@@ -355,7 +508,7 @@ func genImages(cards []Card, file string, getImage ImageGetter, progress func(n,
 				if gerr != nil {
 					continue
 				}
-				img, err := getImage(j.url)
+				img, err := getFaces(j.urls, getImage)
 				if err != nil {
 					gerr = err
 				}
@@ -368,7 +521,7 @@ func genImages(cards []Card, file string, getImage ImageGetter, progress func(n,
 		}()
 	}
 
-	imgs := make([]image.Image, len(cards))
+	imgs := make([]image.Image, len(urls))
 	done := make(chan struct{})
 	go func() {
 		for r := range results {
@@ -378,36 +531,65 @@ func genImages(cards []Card, file string, getImage ImageGetter, progress func(n,
 	}()
 
 	for i, u := range urls {
-		work <- job{ix: i, url: u}
+		work <- job{ix: i, urls: u}
 	}
 
 	close(work)
 	wg.Wait()
 	close(results)
 	<-done
-	if gerr != nil {
-		return gerr
+	return imgs, gerr
+}
+
+// genImages writes a collage of the images of cards to file. labels are
+// drawn at the bottom of each card.
+func genImages(cards []Card, labels [][]string, file string, opts Collage, getImage ImageGetter, progress func(n, total int)) (image.Image, error) {
+	if len(cards) == 0 {
+		return nil, errors.New("no cards to fetch images for")
+	}
+	cols, rows := opts.grid(len(cards))
+
+	urls := make([][]string, 0, len(cards))
+	for _, c := range cards {
+		u, err := c.ImageURLs(opts.Size)
+		if err != nil {
+			return nil, err
+		}
+		urls = append(urls, u)
+	}
+
+	imgs, err := fetchImages(urls, getImage, progress)
+	if err != nil {
+		return nil, err
 	}
 
 	canvas, rects, err := genCollage(cols, rows, imgs)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	canvas, rects = scaleCollage(canvas, rects, opts.MaxWidth)
 
-	if err := addUUIDsToCollage(cols, rows, canvas, cards, rects); err != nil {
-		return err
+	if opts.Has(OverlayUUID) {
+		if err := addUUIDsToCollage(cols, rows, canvas, cards, rects); err != nil {
+			return nil, err
+		}
+	}
+	if len(labels) != 0 {
+		if err := addLabelsToCollage(canvas, rects, labels); err != nil {
+			return nil, err
+		}
 	}
 
 	f, err := os.Create(file)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	err = jpeg.Encode(f, canvas, &jpeg.Options{Quality: 80})
+	err = encodeImage(f, canvas, opts.Format)
 	_ = f.Close()
 	if err != nil {
-		return err
+		return nil, err
 	}
 	_ = f.Sync()
-	return nil
+	return canvas, nil
 }
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+var errOffline = errors.New("image not cached and offline mode is enabled")
+
+// prefetchInterval is the minimum time between two prefetch downloads.
+const prefetchInterval = time.Millisecond * 100
+
+type cacheFile struct {
+	size int64
+	used time.Time
+}
+
+type CacheStats struct {
+	Files     int
+	Size      int64
+	Max       int64
+	Hits      int
+	Misses    int
+	Evictions int
+
+	Prefetching bool
+	Prefetched  int
+	Prefetch    int
+}
+
+// ImageCache stores downloaded images in a directory and evicts the least
+// recently used ones once the total size exceeds max (if > 0). The
+// modification time of a file is used as its last access time.
+type ImageCache struct {
+	dir     string
+	max     int64
+	offline bool
+
+	mutex sync.Mutex
+	files map[string]cacheFile
+	size  int64
+	stats CacheStats
+	stop  chan struct{}
+}
+
+func NewImageCache(dir string, max int64, offline bool) (*ImageCache, error) {
+	c := &ImageCache{
+		dir:     dir,
+		max:     max,
+		offline: offline,
+		files:   make(map[string]cacheFile),
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return c, err
+	}
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		if strings.HasSuffix(e.Name(), ".tmp") {
+			_ = os.Remove(filepath.Join(dir, e.Name()))
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		c.files[e.Name()] = cacheFile{info.Size(), info.ModTime()}
+		c.size += info.Size()
+	}
+
+	c.mutex.Lock()
+	c.evict("")
+	c.mutex.Unlock()
+	return c, nil
+}
+
+// Path returns the path url is (or would be) cached at.
+func (c *ImageCache) Path(url string) string {
+	return imageCachePath(url, c.dir)
+}
+
+// Has reports whether url is cached.
+func (c *ImageCache) Has(url string) bool {
+	c.mutex.Lock()
+	_, ok := c.files[filepath.Base(c.Path(url))]
+	c.mutex.Unlock()
+	return ok
+}
+
+// Open makes sure url is cached and opens it. The file is opened while
+// holding the mutex so a concurrent eviction (e.g.: by Prefetch) can not
+// remove it in between, an open file stays readable after it is removed.
+func (c *ImageCache) Open(url string) (*os.File, error) {
+	path := c.Path(url)
+	name := filepath.Base(path)
+	now := time.Now()
+
+	c.mutex.Lock()
+	if f, ok := c.files[name]; ok {
+		f.used = now
+		c.files[name] = f
+		c.stats.Hits++
+		file, err := os.Open(path)
+		c.mutex.Unlock()
+		_ = os.Chtimes(path, now, now)
+		return file, err
+	}
+	c.stats.Misses++
+	c.mutex.Unlock()
+
+	if c.offline {
+		return nil, errOffline
+	}
+
+	tmp := tmpFile(path)
+	f, err := os.Create(tmp)
+	if err != nil {
+		return nil, err
+	}
+	err = downloadImage(url, f)
+	f.Close()
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if old, ok := c.files[name]; ok {
+		c.size -= old.size
+	}
+	c.files[name] = cacheFile{info.Size(), now}
+	c.size += info.Size()
+	c.evict(name)
+	return os.Open(path)
+}
+
+// Get returns the decoded image for url, downloading it if needed.
+// In offline mode a placeholder is returned for images that are not cached.
+func (c *ImageCache) Get(url string) (image.Image, error) {
+	f, err := c.Open(url)
+	if err == errOffline {
+		return placeholderImage("offline"), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	return img, err
+}
+
+// evict removes the least recently used files (except keep) until the cache
+// fits within max. The caller holds the mutex.
+func (c *ImageCache) evict(keep string) {
+	if c.max <= 0 || c.size <= c.max {
+		return
+	}
+
+	names := make([]string, 0, len(c.files))
+	for name := range c.files {
+		if name != keep {
+			names = append(names, name)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return c.files[names[i]].used.Before(c.files[names[j]].used)
+	})
+
+	for _, name := range names {
+		if c.size <= c.max {
+			break
+		}
+		if err := os.Remove(filepath.Join(c.dir, name)); err != nil && !os.IsNotExist(err) {
+			continue
+		}
+		c.size -= c.files[name].size
+		delete(c.files, name)
+		c.stats.Evictions++
+	}
+}
+
+// Clear removes all cached images.
+func (c *ImageCache) Clear() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	var gerr error
+	for name, f := range c.files {
+		if err := os.Remove(filepath.Join(c.dir, name)); err != nil && !os.IsNotExist(err) {
+			gerr = err
+			continue
+		}
+		c.size -= f.size
+		delete(c.files, name)
+	}
+	return gerr
+}
+
+func (c *ImageCache) Stats() CacheStats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	s := c.stats
+	s.Files, s.Size, s.Max = len(c.files), c.size, c.max
+	return s
+}
+
+// Prefetch downloads all urls that are not yet cached in the background,
+// waiting at least prefetchInterval between downloads.
+func (c *ImageCache) Prefetch(urls []string) (int, error) {
+	if c.offline {
+		return 0, errors.New("can not prefetch images in offline mode")
+	}
+
+	todo := make([]string, 0, len(urls))
+	for _, u := range urls {
+		if !c.Has(u) {
+			todo = append(todo, u)
+		}
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.stats.Prefetching {
+		return 0, errors.New("already prefetching, run /prefetch stop first")
+	}
+	if len(todo) == 0 {
+		return 0, nil
+	}
+
+	stop := make(chan struct{})
+	c.stop = stop
+	c.stats.Prefetching = true
+	c.stats.Prefetched, c.stats.Prefetch = 0, len(todo)
+
+	go func() {
+		tick := time.NewTicker(prefetchInterval)
+		defer tick.Stop()
+	loop:
+		for _, u := range todo {
+			select {
+			case <-stop:
+				break loop
+			case <-tick.C:
+			}
+			if f, err := c.Open(u); err == nil {
+				f.Close()
+			}
+			c.mutex.Lock()
+			c.stats.Prefetched++
+			c.mutex.Unlock()
+		}
+
+		c.mutex.Lock()
+		c.stats.Prefetching = false
+		c.mutex.Unlock()
+	}()
+
+	return len(todo), nil
+}
+
+// StopPrefetch cancels a running Prefetch.
+func (c *ImageCache) StopPrefetch() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if !c.stats.Prefetching || c.stop == nil {
+		return false
+	}
+	close(c.stop)
+	c.stop = nil
+	return true
+}
+
+// placeholderImage returns a card sized tile displaying msg.
+func placeholderImage(msg string) image.Image {
+	const w, h = 488, 680
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.NRGBA{40, 40, 40, 255}), image.Point{}, draw.Src)
+	draw.Draw(
+		img,
+		image.Rect(12, 12, w-12, h-12),
+		image.NewUniform(color.NRGBA{90, 90, 90, 255}),
+		image.Point{},
+		draw.Src,
+	)
+
+	face, err := collageFace(40)
+	if err != nil {
+		return img
+	}
+
+	dwr := font.Drawer{Dst: img, Src: image.NewUniform(color.NRGBA{200, 200, 200, 255}), Face: face}
+	width := dwr.MeasureString(msg).Round()
+	dwr.Dot = fixed.P((w-width)/2, h/2)
+	dwr.DrawString(msg)
+	return img
+}
@@ -0,0 +1,412 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/frizinak/gomtg/mtgjson"
+	"github.com/nightlyone/lockfile"
+)
+
+// Journal holds the staged (uncommitted) changes of a session. It is written
+// next to the database after each change so they can be restored after a
+// crash or quit.
+type Journal struct {
+	Time       time.Time        `json:"time"`
+	Selection  []journalSelect  `json:"selection,omitempty"`
+	Tagging    []journalTagging `json:"tagging,omitempty"`
+	Delete     []journalCard    `json:"delete,omitempty"`
+	Remap      []journalRemap   `json:"remap,omitempty"`
+	Split      []journalSplit   `json:"split,omitempty"`
+	Ownership  []journalOwner   `json:"ownership,omitempty"`
+	Containers []ContainerEdit  `json:"containers,omitempty"`
+	Placement  []journalPlace   `json:"placement,omitempty"`
+	Lending    []journalLend    `json:"lending,omitempty"`
+	Trading    []journalTrade   `json:"trading,omitempty"`
+}
+
+type journalCard struct {
+	ID    CopyID       `json:"id"`
+	Index int          `json:"index"`
+	UUID  mtgjson.UUID `json:"uuid"`
+}
+
+type journalSelect struct {
+	UUID  mtgjson.UUID `json:"uuid"`
+	Tags  []string     `json:"tags,omitempty"`
+	ID    CopyID       `json:"id,omitempty"`
+	Added *time.Time   `json:"added,omitempty"`
+	Owner string       `json:"owner,omitempty"`
+	// Location is <container>:<slot>, see ParseLocation.
+	Location string `json:"location,omitempty"`
+	Loan     *Loan  `json:"loan,omitempty"`
+	From     string `json:"from,omitempty"`
+}
+
+type journalTagging struct {
+	journalCard
+	Add    []string `json:"add,omitempty"`
+	Remove []string `json:"remove,omitempty"`
+}
+
+type journalRemap struct {
+	journalCard
+	To mtgjson.UUID `json:"to"`
+}
+
+type journalSplit struct {
+	journalCard
+	File string `json:"file"`
+}
+
+type journalOwner struct {
+	journalCard
+	To string `json:"to"`
+}
+
+type journalPlace struct {
+	journalCard
+	// To is <container>:<slot> or empty to take the card out of its
+	// container.
+	To string `json:"to,omitempty"`
+}
+
+type journalLend struct {
+	journalCard
+	// Loan is nil to return the card.
+	Loan *Loan `json:"loan,omitempty"`
+}
+
+type journalTrade struct {
+	journalCard
+	With string `json:"with"`
+}
+
+// journalFile is per session as multiple sessions, also of different users,
+// can share a database.
+func journalFile(dbFile, user, session string) string {
+	return dbFile + "." + user + "." + session + ".journal"
+}
+
+// newSession returns a name for the session of this process that is unique
+// even if the pid is reused.
+func newSession() string {
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	return fmt.Sprintf("%d-%x", os.Getpid(), b)
+}
+
+// lockJournal claims the journal in file for the lifetime of the session,
+// the lock is released if the process dies.
+func lockJournal(file string) (unlock func(), err error) {
+	abs, err := filepath.Abs(file + ".lock")
+	if err != nil {
+		return nil, err
+	}
+	locker, err := lockfile.New(abs)
+	if err != nil {
+		return nil, fmt.Errorf("lockfile error: %w", err)
+	}
+	if err := locker.TryLock(); err != nil {
+		return nil, err
+	}
+	return func() { _ = locker.Unlock() }, nil
+}
+
+// NewJournal returns the staged changes in s, cards in the database are
+// referenced by their copy id.
+func NewJournal(s State, db *DB) Journal {
+	var j Journal
+	for _, c := range s.Selection {
+		sel := journalSelect{
+			UUID:     c.UUID,
+			Tags:     c.Tags.Slice(),
+			ID:       c.ID,
+			Owner:    c.Owner,
+			Location: logLocation(c.Location),
+			Loan:     c.Loan.ref(),
+			From:     c.From,
+		}
+		if !c.Added.IsZero() {
+			added := c.Added
+			sel.Added = &added
+		}
+		j.Selection = append(j.Selection, sel)
+	}
+	for _, t := range s.Tagging {
+		add, rem := t.NewTags()
+		j.Tagging = append(j.Tagging, journalTagging{
+			journalCard{t.ID(), db.IndexOf(t.DBCard), t.UUID()},
+			add,
+			rem,
+		})
+	}
+	for _, c := range s.Delete {
+		j.Delete = append(j.Delete, journalCard{c.ID(), c.Index, c.UUID()})
+	}
+	for _, r := range s.Remap {
+		j.Remap = append(j.Remap, journalRemap{journalCard{r.ID(), db.IndexOf(r.DBCard), r.UUID()}, r.To})
+	}
+	for _, sp := range s.Split {
+		j.Split = append(j.Split, journalSplit{journalCard{sp.ID(), sp.Index, sp.UUID()}, sp.File})
+	}
+	for _, o := range s.Ownership {
+		j.Ownership = append(j.Ownership, journalOwner{journalCard{o.ID(), o.Index, o.UUID()}, o.To})
+	}
+	j.Containers = s.Containers
+	for _, p := range s.Placement {
+		j.Placement = append(j.Placement, journalPlace{journalCard{p.ID(), p.Index, p.UUID()}, logLocation(p.To)})
+	}
+	for _, l := range s.Lending {
+		j.Lending = append(j.Lending, journalLend{journalCard{l.ID(), l.Index, l.UUID()}, l.Loan.ref()})
+	}
+	for _, t := range s.Trading {
+		j.Trading = append(j.Trading, journalTrade{journalCard{t.ID(), t.Index, t.UUID()}, t.With})
+	}
+	return j
+}
+
+func (j Journal) Empty() bool {
+	return len(j.Selection) == 0 &&
+		len(j.Tagging) == 0 &&
+		len(j.Delete) == 0 &&
+		len(j.Remap) == 0 &&
+		len(j.Split) == 0 &&
+		len(j.Ownership) == 0 &&
+		len(j.Containers) == 0 &&
+		len(j.Placement) == 0 &&
+		len(j.Lending) == 0 &&
+		len(j.Trading) == 0
+}
+
+func (j Journal) String() string {
+	return fmt.Sprintf(
+		"%d added, %d retagged, %d deleted, %d remapped, %d split, %d given away, %d placed, %d lent/returned and %d traded cards, %d container changes",
+		len(j.Selection),
+		len(j.Tagging),
+		len(j.Delete),
+		len(j.Remap),
+		len(j.Split),
+		len(j.Ownership),
+		len(j.Placement),
+		len(j.Lending),
+		len(j.Trading),
+		len(j.Containers),
+	)
+}
+
+func (j Journal) Write(file string) error {
+	tmp := file + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	err = json.NewEncoder(f).Encode(j)
+	f.Close()
+	if err == nil {
+		err = os.Rename(tmp, file)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// ReadJournal reads the journal in file, ok is false if there is none.
+func ReadJournal(file string) (j Journal, ok bool, err error) {
+	f, err := os.Open(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return j, false, nil
+		}
+		return j, false, err
+	}
+	defer f.Close()
+	if err := json.NewDecoder(f).Decode(&j); err != nil {
+		return j, false, fmt.Errorf("invalid journal '%s': %w", file, err)
+	}
+	return j, true, nil
+}
+
+// Apply stages the journaled changes in s. Changes to cards that no longer
+// exist (e.g.: the database was modified in the meantime) are skipped.
+func (j Journal) Apply(app *App, s State) (State, int) {
+	skipped := 0
+	local := func(jc journalCard) (*DBCard, int, bool) {
+		if c, ok := app.DB.ByID(jc.ID); ok {
+			return c, app.DB.IndexOf(c), true
+		}
+		c, ok := app.DB.CardAt(jc.Index)
+		if jc.ID != "" || !ok || c.UUID() != jc.UUID {
+			skipped++
+			return nil, 0, false
+		}
+		return c, jc.Index, true
+	}
+
+	for _, sel := range j.Selection {
+		c, ok := app.Cards.ByUUID(sel.UUID)
+		if !ok {
+			skipped++
+			continue
+		}
+		n := NewSelect(c)
+		n.Tags.Add(sel.Tags...)
+		n.ID, n.Owner, n.Loan, n.From = sel.ID, sel.Owner, loanOf(sel.Loan), sel.From
+		if sel.Location != "" {
+			n.Location, _ = ParseLocation(sel.Location)
+		}
+		if sel.Added != nil {
+			n.Added = *sel.Added
+		}
+		s.Selection = append(s.Selection, n)
+	}
+	for _, t := range j.Tagging {
+		c, _, ok := local(t.journalCard)
+		if !ok {
+			continue
+		}
+		n := NewTagging(c)
+		for _, tag := range t.Add {
+			n.Add(true, tag)
+		}
+		for _, tag := range t.Remove {
+			n.Add(false, tag)
+		}
+		s.Tagging = append(s.Tagging, n)
+	}
+	for _, d := range j.Delete {
+		if c, ix, ok := local(d); ok {
+			s.Delete = append(s.Delete, NewLocalCard(c, ix))
+		}
+	}
+	for _, r := range j.Remap {
+		c, _, ok := local(r.journalCard)
+		if _, known := app.Cards.ByUUID(r.To); !ok || !known {
+			if ok {
+				skipped++
+			}
+			continue
+		}
+		s.Remap = append(s.Remap, Remap{c, r.To})
+	}
+	for _, sp := range j.Split {
+		if c, ix, ok := local(sp.journalCard); ok {
+			s.Split = append(s.Split, Split{NewLocalCard(c, ix), sp.File})
+		}
+	}
+	for _, o := range j.Ownership {
+		if c, ix, ok := local(o.journalCard); ok {
+			s.Ownership = append(s.Ownership, Ownership{NewLocalCard(c, ix), o.To})
+		}
+	}
+	s.Containers = append(s.Containers, j.Containers...)
+	for _, p := range j.Placement {
+		c, ix, ok := local(p.journalCard)
+		if !ok {
+			continue
+		}
+		var to Location
+		if p.To != "" {
+			to, _ = ParseLocation(p.To)
+		}
+		s.Placement = append(s.Placement, Placement{NewLocalCard(c, ix), to})
+	}
+	for _, l := range j.Lending {
+		if c, ix, ok := local(l.journalCard); ok {
+			s.Lending = append(s.Lending, Lending{NewLocalCard(c, ix), loanOf(l.Loan)})
+		}
+	}
+	for _, t := range j.Trading {
+		if c, ix, ok := local(t.journalCard); ok {
+			s.Trading = append(s.Trading, Trade{NewLocalCard(c, ix), t.With})
+		}
+	}
+
+	return s, skipped
+}
+
+// OrphanedJournal is the journal of a session of the same user that is no
+// longer running.
+type OrphanedJournal struct {
+	Journal
+	File string
+
+	unlock func()
+}
+
+// Discard removes the journal.
+func (o OrphanedJournal) Discard() error {
+	err := os.Remove(o.File)
+	o.Close()
+	return err
+}
+
+// Close releases the journal so another session can recover it.
+func (o OrphanedJournal) Close() {
+	if o.unlock != nil {
+		o.unlock()
+	}
+}
+
+// OrphanedJournals returns the journals of sessions of user on dbFile that
+// are no longer running, oldest first. They are claimed until closed or
+// discarded. Invalid journals are skipped, err is the first of them.
+func OrphanedJournals(dbFile, user string) (list []OrphanedJournal, err error) {
+	dir, base := filepath.Split(dbFile)
+	if dir == "" {
+		dir = "."
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	// journals from before they were per session.
+	legacy := []string{dbFile + "." + user + ".journal", dbFile + ".journal"}
+	files := legacy
+	prefix := base + "." + user + "."
+	for _, e := range entries {
+		session := strings.TrimPrefix(e.Name(), prefix)
+		if !strings.HasPrefix(e.Name(), prefix) || !strings.HasSuffix(session, ".journal") {
+			continue
+		}
+		// not the journal of a user whose name starts with user and a dot.
+		if session = strings.TrimSuffix(session, ".journal"); !strings.Contains(session, ".") {
+			files = append(files, filepath.Join(dir, e.Name()))
+		}
+	}
+
+	for i, file := range files {
+		var unlock func()
+		if i >= len(legacy) {
+			var lerr error
+			if unlock, lerr = lockJournal(file); lerr != nil {
+				// the session is still running.
+				continue
+			}
+		}
+		j, ok, rerr := ReadJournal(file)
+		if !ok {
+			if unlock != nil {
+				unlock()
+			}
+			if err == nil {
+				err = rerr
+			}
+			continue
+		}
+		list = append(list, OrphanedJournal{j, file, unlock})
+	}
+
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].Time.Before(list[j].Time)
+	})
+	return list, err
+}
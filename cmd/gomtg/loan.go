@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/frizinak/gomtg/mtgjson"
+)
+
+// Loan is a copy lent to someone, the zero value means it is not lent.
+type Loan struct {
+	To    string    `json:"to"`
+	Since time.Time `json:"since"`
+}
+
+func (l Loan) Empty() bool { return l.To == "" }
+
+// Equal reports whether l and o are the same loan, Since is compared with
+// time.Time.Equal as it may have been stored in another time zone.
+func (l Loan) Equal(o Loan) bool { return l.To == o.To && l.Since.Equal(o.Since) }
+
+func (l Loan) String() string {
+	if l.Empty() {
+		return "returned"
+	}
+	return fmt.Sprintf("lent to %s since %s", l.To, l.Since.Local().Format("2006-01-02"))
+}
+
+// ref returns nil if l is empty, for omitempty json fields.
+func (l Loan) ref() *Loan {
+	if l.Empty() {
+		return nil
+	}
+	return &l
+}
+
+func loanOf(l *Loan) Loan {
+	if l == nil {
+		return Loan{}
+	}
+	return *l
+}
+
+// Available returns the number of copies of uuid that are not lent.
+func (db *DB) Available(uuid mtgjson.UUID) int {
+	n := 0
+	for _, ix := range db.byUUID[uuid] {
+		if db.data[ix].loan.Empty() {
+			n++
+		}
+	}
+	return n
+}
+
+// Lending lends a card in the collection to someone, or returns it if Loan
+// is empty.
+type Lending struct {
+	LocalCard
+	Loan Loan
+}
+
+func (l Lending) String() string {
+	if l.Loan.Empty() {
+		return fmt.Sprintf("return %s %s (%s) from %s", l.UUID(), l.Name(), l.SetID(), l.DBCard.Loan().To)
+	}
+	return fmt.Sprintf(
+		"lend %s %s (%s) to %s on %s",
+		l.UUID(),
+		l.Name(),
+		l.SetID(),
+		l.Loan.To,
+		l.Loan.Since.Local().Format("2006-01-02"),
+	)
+}
+
+// Loan returns the loan of c with the staged lendings in s applied.
+func (s State) Loan(c *DBCard) Loan {
+	l := c.Loan()
+	for _, n := range s.Lending {
+		if n.DBCard == c {
+			l = n.Loan
+		}
+	}
+	return l
+}
+
+// Trade gives a card in the collection away in a trade with With, the cards
+// received are the cards added with Select.From set to With.
+type Trade struct {
+	LocalCard
+	With string
+}
+
+func (t Trade) String() string {
+	return fmt.Sprintf("trade %s %s (%s) to %s", t.UUID(), t.Name(), t.SetID(), t.With)
+}
+
+// TradeEntry is a single trade in the trade log, cards are valued at the time
+// of the trade.
+type TradeEntry struct {
+	ID       int         `json:"id"`
+	Time     time.Time   `json:"time"`
+	User     string      `json:"user"`
+	With     string      `json:"with"`
+	Currency string      `json:"currency"`
+	Given    []TradeCard `json:"given,omitempty"`
+	Received []TradeCard `json:"received,omitempty"`
+}
+
+// TradeCard is a card given or received in a trade.
+type TradeCard struct {
+	ID    CopyID        `json:"id"`
+	UUID  mtgjson.UUID  `json:"uuid"`
+	Name  string        `json:"name"`
+	SetID mtgjson.SetID `json:"set_id"`
+	Foil  bool          `json:"foil,omitempty"`
+	Value float64       `json:"value"`
+}
+
+func tradeValue(l []TradeCard) float64 {
+	v := 0.0
+	for _, c := range l {
+		v += c.Value
+	}
+	return v
+}
+
+func (t TradeEntry) String() string {
+	given, received := tradeValue(t.Given), tradeValue(t.Received)
+	return fmt.Sprintf(
+		"#%-4d %s %-10s with %-10s gave %d (%.2f) received %d (%.2f) %+.2f %s",
+		t.ID,
+		t.Time.Local().Format("2006-01-02 15:04:05"),
+		t.User,
+		t.With,
+		len(t.Given),
+		given,
+		len(t.Received),
+		received,
+		received-given,
+		strings.ToUpper(t.Currency),
+	)
+}
+
+// Details returns the summary of t followed by a line for every card.
+func (t TradeEntry) Details() []string {
+	l := []string{t.String()}
+	card := func(c TradeCard) string {
+		s := fmt.Sprintf("%s %s %-5s %-30s %8.2f", c.ID, c.UUID, c.SetID, c.Name, c.Value)
+		if c.Foil {
+			s += " (foil)"
+		}
+		return s
+	}
+	for _, c := range t.Given {
+		l = append(l, " - "+card(c))
+	}
+	for _, c := range t.Received {
+		l = append(l, " + "+card(c))
+	}
+	return l
+}
+
+func tradeFile(dbFile string) string { return dbFile + ".trades" }
+
+// ReadTrades returns all entries in the trade log, oldest first.
+func ReadTrades(file string) ([]TradeEntry, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var list []TradeEntry
+	dec := json.NewDecoder(bufio.NewReader(f))
+	for dec.More() {
+		var t TradeEntry
+		if err := dec.Decode(&t); err != nil {
+			return list, fmt.Errorf("invalid trade log '%s': %w", file, err)
+		}
+		list = append(list, t)
+	}
+	return list, nil
+}
+
+// AppendTrade assigns the next id to t and appends it to the trade log.
+func AppendTrade(file string, t TradeEntry) (TradeEntry, error) {
+	list, err := ReadTrades(file)
+	if err != nil {
+		return t, err
+	}
+	t.ID = 1
+	if len(list) != 0 {
+		t.ID = list[len(list)-1].ID + 1
+	}
+
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return t, err
+	}
+	err = json.NewEncoder(f).Encode(t)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return t, err
+}
+
+// tradeCard values c as it is now.
+func (a *App) tradeCard(c *DBCard) TradeCard {
+	v, _ := a.GetPricing(c.UUID(), c.Foil(), false)
+	return TradeCard{c.ID(), c.UUID(), c.Name(), c.SetID(), c.Foil(), v}
+}
+
+// Trades returns the trades in s by person, given are the cards that are
+// about to be removed, received the cards added for each Select with From
+// set.
+func (a *App) Trades(s State, received map[*DBCard]string) []TradeEntry {
+	byWith := make(map[string]*TradeEntry)
+	var with []string
+	get := func(name string) *TradeEntry {
+		t, ok := byWith[name]
+		if !ok {
+			t = &TradeEntry{Time: time.Now(), User: currentUser(), With: name, Currency: a.pricing.currency}
+			byWith[name] = t
+			with = append(with, name)
+		}
+		return t
+	}
+
+	seen := make(map[*DBCard]struct{}, len(s.Trading))
+	for _, t := range s.Trading {
+		if _, ok := seen[t.DBCard]; ok || a.DB.IndexOf(t.DBCard) < 0 {
+			continue
+		}
+		seen[t.DBCard] = struct{}{}
+		e := get(t.With)
+		e.Given = append(e.Given, a.tradeCard(t.DBCard))
+	}
+	for _, c := range a.DB.Cards() {
+		if name, ok := received[c]; ok {
+			e := get(name)
+			e.Received = append(e.Received, a.tradeCard(c))
+		}
+	}
+
+	sort.Strings(with)
+	l := make([]TradeEntry, len(with))
+	for i, name := range with {
+		l[i] = *byWith[name]
+	}
+	return l
+}
+
+// without returns t without the given cards that were kept because they
+// conflicted with another session.
+func (t TradeEntry) without(r SyncReport) TradeEntry {
+	given := t.Given[:0:0]
+	for _, c := range t.Given {
+		if !r.Conflicted(c.ID, conflictCard) {
+			given = append(given, c)
+		}
+	}
+	t.Given = given
+	return t
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ContainerKind is the kind of physical container cards are stored in, it
+// decides how slots are described.
+type ContainerKind string
+
+const (
+	KindBox     ContainerKind = "box"
+	KindBinder  ContainerKind = "binder"
+	KindDeckbox ContainerKind = "deckbox"
+)
+
+func (k ContainerKind) Valid() bool {
+	return k == KindBox || k == KindBinder || k == KindDeckbox
+}
+
+// pocketsPerPage is the number of slots on a binder page, see /binder.
+const pocketsPerPage = 9
+
+var containerNameRE = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// Container is a named box, binder or deckbox with Size slots numbered from
+// 1, a Size of 0 means it has no limit.
+type Container struct {
+	Name string        `json:"name"`
+	Kind ContainerKind `json:"kind"`
+	Size int           `json:"size,omitempty"`
+}
+
+func (c Container) String() string {
+	if c.Size == 0 {
+		return fmt.Sprintf("%s (%s)", c.Name, c.Kind)
+	}
+	return fmt.Sprintf("%s (%s, %d slots)", c.Name, c.Kind, c.Size)
+}
+
+// Slot describes the given slot, e.g.: the page and pocket of a binder.
+func (c Container) Slot(slot int) string {
+	if c.Kind == KindBinder {
+		return fmt.Sprintf(
+			"%s page %d pocket %d (slot %d)",
+			c.Name,
+			(slot-1)/pocketsPerPage+1,
+			(slot-1)%pocketsPerPage+1,
+			slot,
+		)
+	}
+	return fmt.Sprintf("%s slot %d", c.Name, slot)
+}
+
+// Location is the container and slot a card is stored in, the zero value
+// means it has no location.
+type Location struct {
+	Container string
+	Slot      int
+}
+
+func (l Location) Empty() bool { return l.Container == "" }
+
+func (l Location) String() string {
+	if l.Empty() {
+		return "-"
+	}
+	return fmt.Sprintf("%s:%d", l.Container, l.Slot)
+}
+
+// ParseLocation parses <container>[:<slot>], slot is 0 if it was omitted.
+func ParseLocation(s string) (Location, error) {
+	p := strings.SplitN(s, ":", 2)
+	l := Location{Container: p[0]}
+	if !containerNameRE.MatchString(l.Container) {
+		return l, fmt.Errorf("invalid container name '%s', only letters, digits, _, . and - are allowed", l.Container)
+	}
+	if len(p) == 2 {
+		n, err := strconv.Atoi(p[1])
+		if err != nil || n < 1 {
+			return l, fmt.Errorf("invalid slot '%s'", p[1])
+		}
+		l.Slot = n
+	}
+	return l, nil
+}
+
+// ContainerEdit adds, changes or removes a container.
+type ContainerEdit struct {
+	Container
+	Remove bool `json:"remove,omitempty"`
+}
+
+func (e ContainerEdit) String() string {
+	if e.Remove {
+		return fmt.Sprintf("remove container %s", e.Name)
+	}
+	return fmt.Sprintf("container %s", e.Container)
+}
+
+// Placement moves a card in the collection to another location.
+type Placement struct {
+	LocalCard
+	To Location
+}
+
+func (p Placement) String() string {
+	if p.To.Empty() {
+		return fmt.Sprintf("take %s %s (%s) out of %s", p.UUID(), p.Name(), p.SetID(), p.Location())
+	}
+	return fmt.Sprintf("put %s %s (%s) from %s in %s", p.UUID(), p.Name(), p.SetID(), p.Location(), p.To)
+}
+
+func (db *DB) Containers() []Container {
+	l := make([]Container, len(db.containers))
+	copy(l, db.containers)
+	return l
+}
+
+func (db *DB) Container(name string) (Container, bool) {
+	for _, c := range db.containers {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return Container{}, false
+}
+
+// SetContainer adds c or replaces the container with the same name.
+func (db *DB) SetContainer(c Container) {
+	for i := range db.containers {
+		if db.containers[i].Name == c.Name {
+			if db.containers[i] != c {
+				db.containers[i] = c
+				db.containersDirty = true
+			}
+			return
+		}
+	}
+	db.containers = append(db.containers, c)
+	db.containersDirty = true
+}
+
+func (db *DB) RemoveContainer(name string) {
+	for i := range db.containers {
+		if db.containers[i].Name == name {
+			db.containers = append(db.containers[:i:i], db.containers[i+1:]...)
+			db.containersDirty = true
+			return
+		}
+	}
+}
+
+// AllContainers returns the containers in the database with the staged
+// edits in s applied.
+func (s State) AllContainers(db *DB) []Container {
+	l := db.Containers()
+	for _, e := range s.Containers {
+		n := l[:0]
+		for _, c := range l {
+			if c.Name != e.Name {
+				n = append(n, c)
+			}
+		}
+		l = n
+		if !e.Remove {
+			l = append(l, e.Container)
+		}
+	}
+	return l
+}
+
+// FindContainer returns the container with the given name with the staged
+// edits in s applied.
+func (s State) FindContainer(db *DB, name string) (Container, bool) {
+	for _, c := range s.AllContainers(db) {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return Container{}, false
+}
+
+// Location returns the location of c with the staged placements in s
+// applied.
+func (s State) Location(c *DBCard) Location {
+	l := c.Location()
+	for _, p := range s.Placement {
+		if p.DBCard == c {
+			l = p.To
+		}
+	}
+	return l
+}
+
+// eachLocation calls fn with the location of every card with the staged
+// changes in s applied. Cards staged for deletion, splitting or trading no
+// longer take up a slot.
+func (s State) eachLocation(db *DB, fn func(Location)) {
+	gone := make(map[*DBCard]struct{}, len(s.Delete)+len(s.Split)+len(s.Trading))
+	for _, c := range s.Delete {
+		gone[c.DBCard] = struct{}{}
+	}
+	for _, c := range s.Split {
+		gone[c.DBCard] = struct{}{}
+	}
+	for _, c := range s.Trading {
+		gone[c.DBCard] = struct{}{}
+	}
+	for _, c := range db.Cards() {
+		if _, ok := gone[c]; !ok {
+			fn(s.Location(c))
+		}
+	}
+	for _, c := range s.Selection {
+		fn(c.Location)
+	}
+}
+
+// Slots returns the used slots of the named container with the staged
+// changes in s applied.
+func (s State) Slots(db *DB, name string) map[int]struct{} {
+	used := make(map[int]struct{})
+	s.eachLocation(db, func(l Location) {
+		if l.Container == name {
+			used[l.Slot] = struct{}{}
+		}
+	})
+	return used
+}
+
+// Usage returns the number of cards in each container by name with the
+// staged changes in s applied.
+func (s State) Usage(db *DB) map[string]int {
+	m := make(map[string]int)
+	s.eachLocation(db, func(l Location) {
+		if !l.Empty() {
+			m[l.Container]++
+		}
+	})
+	return m
+}
+
+// FreeSlots returns n free slots in the named container starting at from,
+// in order.
+func (s State) FreeSlots(db *DB, name string, from, n int) ([]int, error) {
+	c, ok := s.FindContainer(db, name)
+	if !ok {
+		return nil, fmt.Errorf("no such container '%s', see /container", name)
+	}
+	if from < 1 {
+		from = 1
+	}
+	used := s.Slots(db, name)
+	l := make([]int, 0, n)
+	for slot := from; len(l) < n; slot++ {
+		if c.Size != 0 && slot > c.Size {
+			return nil, fmt.Errorf("%s does not have %d free slots from slot %d", c, n, from)
+		}
+		if _, ok := used[slot]; !ok {
+			l = append(l, slot)
+		}
+	}
+	return l, nil
+}
+
+// Where describes the location of c, e.g.: binder page and pocket.
+func (s State) Where(db *DB, c *DBCard) string {
+	l := s.Location(c)
+	if l.Empty() {
+		return "-"
+	}
+	if cont, ok := s.FindContainer(db, l.Container); ok {
+		return cont.Slot(l.Slot)
+	}
+	return l.String()
+}
+
+// locationKey returns a sort key that orders cards by container and slot,
+// cards without a location come last.
+func locationKey(l Location) string {
+	if l.Empty() {
+		return "\xff"
+	}
+	return fmt.Sprintf("%s\x00%010d", l.Container, l.Slot)
+}
+
+func sortContainers(l []Container) {
+	sort.Slice(l, func(i, j int) bool { return l[i].Name < l[j].Name })
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// sameFile returns true if a and b are the same file, even if neither
+// exists yet.
+func sameFile(a, b string) bool {
+	sa, erra := os.Stat(a)
+	sb, errb := os.Stat(b)
+	if erra == nil && errb == nil {
+		return os.SameFile(sa, sb)
+	}
+	absa, erra := filepath.Abs(a)
+	absb, errb := filepath.Abs(b)
+	return erra == nil && errb == nil && absa == absb
+}
+
+// splitMergeArg splits a <file>[=<tag>] argument of the merge subcommand.
+// The file can contain '=' itself, the arg is split at the first '=' that is
+// preceded by an existing database.
+func splitMergeArg(arg string, exists func(file string) bool) (file, tag string) {
+	if exists(arg) {
+		return arg, ""
+	}
+	for i := range arg {
+		if arg[i] == '=' && exists(arg[:i]) {
+			return arg[:i], arg[i+1:]
+		}
+	}
+	return arg, ""
+}
+
+// MergeSelection returns a selection that adds all cards in db to the
+// collection with their tags, copy ids, added-at dates, owners, locations and
+// loans and tag, if it is not empty. Cards unknown to mtgjson are kept as they are
+// (see /fsck).
+func (a *App) MergeSelection(db *DB, tag string) Selection {
+	cards := db.Cards()
+	sel := make(Selection, 0, len(cards))
+	for _, c := range cards {
+		rc, ok := a.Cards.ByUUID(c.UUID())
+		if !ok {
+			rc = Card{UUID: c.UUID(), Name: c.Name(), SetCode: c.SetID(), Number: c.Number()}
+		}
+		s := NewSelect(rc)
+		s.ID, s.Added, s.Owner, s.Location, s.Loan = c.ID(), c.Added(), c.Owner(), c.Location(), c.Loan()
+		s.Tags.Add(c.Tags()...)
+		if tag != "" {
+			s.Tags.Add(tag)
+		}
+		sel = append(sel, s)
+	}
+	return sel
+}
+
+// MergeContainers returns the edits that add the containers in db that are
+// not in the collection (with the staged edits in s applied).
+func (a *App) MergeContainers(s State, db *DB) []ContainerEdit {
+	var l []ContainerEdit
+	for _, c := range db.Containers() {
+		if _, ok := s.FindContainer(a.DB, c.Name); !ok {
+			l = append(l, ContainerEdit{Container: c})
+		}
+	}
+	return l
+}
+
+// NewSplit returns the splits that move cards to the database in file.
+func NewSplit(cards []LocalCard, file string) []Split {
+	l := make([]Split, len(cards))
+	for i, c := range cards {
+		l[i] = Split{c, file}
+	}
+	return l
+}
+
+// writeSplits appends copies of the cards split off in staged to their
+// databases, new databases are created in the format of the one in dbFile.
+func (a *App) writeSplits(dbFile string, staged State) error {
+	splits := staged.Split
+	if len(splits) == 0 {
+		return nil
+	}
+	format, err := DetectFormat(dbFile, FormatJSON)
+	if err != nil {
+		return err
+	}
+
+	var files []string
+	byFile := make(map[string][]*DBCard)
+	seen := make(map[*DBCard]struct{}, len(splits))
+	for _, s := range splits {
+		if _, ok := seen[s.DBCard]; ok || a.DB.IndexOf(s.DBCard) < 0 {
+			continue
+		}
+		seen[s.DBCard] = struct{}{}
+		if sameFile(s.File, dbFile) {
+			return fmt.Errorf("can not split cards into the database itself ('%s')", s.File)
+		}
+		if _, ok := byFile[s.File]; !ok {
+			files = append(files, s.File)
+		}
+		byFile[s.File] = append(byFile[s.File], s.DBCard)
+	}
+
+	for _, file := range files {
+		if err := writeSplit(file, format, byFile[file], staged.AllContainers(a.DB)); err != nil {
+			return fmt.Errorf("failed to split cards into '%s': %w", file, err)
+		}
+	}
+	return nil
+}
+
+func writeSplit(file string, format StorageFormat, cards []*DBCard, containers []Container) error {
+	unlock, err := lockDB(file)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	store, err := OpenStorage(file, format)
+	if err != nil {
+		return err
+	}
+	db, err := LoadDB(store)
+	if err != nil {
+		store.Close()
+		return err
+	}
+	defer db.Close()
+
+	snap := newLogSnapshot(db)
+	for _, c := range cards {
+		// already copied by a commit that failed afterwards.
+		if _, ok := db.ByID(c.ID()); ok {
+			continue
+		}
+		db.AddCopy(c)
+		// the cards stay where they are, so does their container.
+		name := c.Location().Container
+		if _, ok := db.Container(name); !ok && name != "" {
+			for _, cont := range containers {
+				if cont.Name == name {
+					db.SetContainer(cont)
+				}
+			}
+		}
+	}
+	if _, err := db.Save(); err != nil {
+		return err
+	}
+	if e := snap.entry(db, State{}); !e.Empty() {
+		_, err = AppendLog(auditFile(file), e)
+	}
+	return err
+}
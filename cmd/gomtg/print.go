@@ -0,0 +1,441 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+// FormatPDF is only supported for printed sheets, not for collages.
+const FormatPDF ImageFormat = "pdf"
+
+// Paper is the page size printed sheets are laid out on.
+type Paper string
+
+const (
+	PaperA4     Paper = "a4"
+	PaperLetter Paper = "letter"
+)
+
+func (p Paper) Valid() bool {
+	return p == PaperA4 || p == PaperLetter
+}
+
+// mm returns the width and height of p in millimeters.
+func (p Paper) mm() (float64, float64) {
+	if p == PaperLetter {
+		return 215.9, 279.4
+	}
+	return 210, 297
+}
+
+const (
+	cardWidthMM  = 63
+	cardHeightMM = 88
+	sheetCols    = 3
+	sheetRows    = 3
+	perSheet     = sheetCols * sheetRows
+	cutMarkMM    = 5
+
+	// printImageSize is the scryfall image version used for printing, at
+	// 63mm wide it is roughly 270 dpi.
+	printImageSize = "large"
+)
+
+// Sheet configures printed proxy sheets and binder pages.
+type Sheet struct {
+	Paper  Paper
+	DPI    int
+	Format ImageFormat // FormatPNG or FormatPDF
+}
+
+func (s Sheet) px(mm float64) int {
+	return int(math.Round(mm * float64(s.DPI) / 25.4))
+}
+
+// renderSheet draws up to 9 cards at their true size in a 3x3 grid centered
+// on a page. nil images are left empty. Proxy sheets get cut marks in the
+// margins, binder pages get pocket outlines and a title at the top instead.
+func renderSheet(imgs []image.Image, s Sheet, binder bool, title string) (*image.NRGBA, error) {
+	pw, ph := s.Paper.mm()
+	page := image.NewNRGBA(image.Rect(0, 0, s.px(pw), s.px(ph)))
+	draw.Draw(page, page.Bounds(), image.White, image.Point{}, draw.Src)
+
+	cw, ch := s.px(cardWidthMM), s.px(cardHeightMM)
+	empty := image.NewNRGBA(image.Rect(0, 0, cw, ch))
+	draw.Draw(empty, empty.Bounds(), image.White, image.Point{}, draw.Src)
+	tiles := make([]image.Image, perSheet)
+	for i := range tiles {
+		tiles[i] = empty
+		if i < len(imgs) && imgs[i] != nil {
+			tiles[i] = scaleImage(imgs[i], cw, ch)
+		}
+	}
+
+	grid, rects, err := genCollage(sheetCols, sheetRows, tiles)
+	if err != nil {
+		return nil, err
+	}
+	offset := image.Pt(
+		(page.Bounds().Dx()-grid.Bounds().Dx())/2,
+		(page.Bounds().Dy()-grid.Bounds().Dy())/2,
+	)
+	gb := grid.Bounds().Add(offset)
+	draw.Draw(page, gb, grid, image.Point{}, draw.Src)
+
+	line := s.DPI / 150
+	if line < 1 {
+		line = 1
+	}
+	black := image.NewUniform(color.Black)
+	if !binder {
+		n := s.px(cutMarkMM)
+		for x := 0; x <= sheetCols; x++ {
+			lx := gb.Min.X + x*cw
+			draw.Draw(page, image.Rect(lx-line/2, gb.Min.Y-n, lx-line/2+line, gb.Min.Y), black, image.Point{}, draw.Src)
+			draw.Draw(page, image.Rect(lx-line/2, gb.Max.Y, lx-line/2+line, gb.Max.Y+n), black, image.Point{}, draw.Src)
+		}
+		for y := 0; y <= sheetRows; y++ {
+			ly := gb.Min.Y + y*ch
+			draw.Draw(page, image.Rect(gb.Min.X-n, ly-line/2, gb.Min.X, ly-line/2+line), black, image.Point{}, draw.Src)
+			draw.Draw(page, image.Rect(gb.Max.X, ly-line/2, gb.Max.X+n, ly-line/2+line), black, image.Point{}, draw.Src)
+		}
+		return page, nil
+	}
+
+	gray := image.NewUniform(color.NRGBA{160, 160, 160, 255})
+	for _, r := range rects {
+		r = r.Add(offset)
+		for _, edge := range []image.Rectangle{
+			image.Rect(r.Min.X, r.Min.Y, r.Max.X, r.Min.Y+line),
+			image.Rect(r.Min.X, r.Max.Y-line, r.Max.X, r.Max.Y),
+			image.Rect(r.Min.X, r.Min.Y, r.Min.X+line, r.Max.Y),
+			image.Rect(r.Max.X-line, r.Min.Y, r.Max.X, r.Max.Y),
+		} {
+			draw.Draw(page, edge, gray, image.Point{}, draw.Over)
+		}
+	}
+
+	face, err := collageFace(float64(s.px(5)))
+	if err != nil {
+		return nil, err
+	}
+	dwr := font.Drawer{Dst: page, Src: black, Face: face}
+	dwr.Dot = fixed.P(gb.Min.X, gb.Min.Y-s.px(3))
+	dwr.DrawString(title)
+	return page, nil
+}
+
+// layoutPages calls page with every sheet of urls (one entry per tile), only
+// the images of a single sheet are held in memory at a time. skip reports
+// whether the n-th sheet is left out.
+func layoutPages(
+	sheets [][][]string,
+	getImage ImageGetter,
+	progress func(n, total int),
+	render func(n int, imgs []image.Image) (*image.NRGBA, error),
+	page func(*image.NRGBA) error,
+) error {
+	total, done := 0, 0
+	for _, urls := range sheets {
+		total += len(urls)
+	}
+	for n, urls := range sheets {
+		imgs, err := fetchImages(urls, getImage, func(i, _ int) { progress(done+i, total) })
+		if err != nil {
+			return err
+		}
+		done += len(urls)
+		p, err := render(n, imgs)
+		if err != nil {
+			return err
+		}
+		if err := page(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// proxySheets renders all faces of cards on as many sheets as needed and
+// passes them to page one by one.
+func proxySheets(
+	cards []Card,
+	s Sheet,
+	getImage ImageGetter,
+	progress func(n, total int),
+	page func(*image.NRGBA) error,
+) error {
+	if len(cards) == 0 {
+		return errors.New("no cards to print")
+	}
+	urls := make([][]string, 0, len(cards))
+	for _, c := range cards {
+		list, err := c.ImageURLs(printImageSize)
+		if err != nil {
+			return err
+		}
+		// every face is a separate proxy
+		for _, u := range list {
+			urls = append(urls, []string{u})
+		}
+	}
+
+	sheets := make([][][]string, 0, (len(urls)+perSheet-1)/perSheet)
+	for i := 0; i < len(urls); i += perSheet {
+		end := i + perSheet
+		if end > len(urls) {
+			end = len(urls)
+		}
+		sheets = append(sheets, urls[i:end])
+	}
+
+	render := func(_ int, imgs []image.Image) (*image.NRGBA, error) {
+		return renderSheet(imgs, s, false, "")
+	}
+	return layoutPages(sheets, getImage, progress, render, page)
+}
+
+// binderPages renders the 9-pocket binder pages cards are stored in
+// according to their index and passes them to page one by one, only pages
+// that contain at least one of cards are rendered.
+func binderPages(
+	cards []LocalCard,
+	all *All,
+	s Sheet,
+	getImage ImageGetter,
+	progress func(n, total int),
+	page func(*image.NRGBA) error,
+) error {
+	if len(cards) == 0 {
+		return errors.New("no cards to lay out")
+	}
+
+	// urls by page number, empty pockets have no urls.
+	slots := make(map[int][][]string)
+	for _, lc := range cards {
+		c, ok := all.ByUUID(lc.UUID())
+		if !ok {
+			return fmt.Errorf("card %s %w", lc.UUID(), errNotFound)
+		}
+		list, err := c.ImageURLs(printImageSize)
+		if err != nil {
+			return err
+		}
+		n := lc.Index / perSheet
+		if slots[n] == nil {
+			slots[n] = make([][]string, perSheet)
+		}
+		// the back face is not visible in a binder
+		slots[n][lc.Index%perSheet] = list[:1]
+	}
+	nums := make([]int, 0, len(slots))
+	for n := range slots {
+		nums = append(nums, n)
+	}
+	sort.Ints(nums)
+
+	// only the filled pockets are fetched.
+	sheets := make([][][]string, len(nums))
+	pockets := make([][]int, len(nums))
+	for i, n := range nums {
+		for pocket, u := range slots[n] {
+			if u != nil {
+				sheets[i] = append(sheets[i], u)
+				pockets[i] = append(pockets[i], pocket)
+			}
+		}
+	}
+
+	render := func(i int, imgs []image.Image) (*image.NRGBA, error) {
+		n := nums[i]
+		tiles := make([]image.Image, perSheet)
+		for j, pocket := range pockets[i] {
+			tiles[pocket] = imgs[j]
+		}
+		title := fmt.Sprintf(
+			"page %d (%d-%d)",
+			n+1,
+			n*perSheet+1,
+			(n+1)*perSheet,
+		)
+		return renderSheet(tiles, s, true, title)
+	}
+	return layoutPages(sheets, getImage, progress, render, page)
+}
+
+// SheetWriter writes pages to dir as they are rendered, as a single pdf or a
+// png per page.
+type SheetWriter struct {
+	s     Sheet
+	base  string
+	files []string
+	pages int
+
+	f   *os.File
+	pdf *pdfWriter
+}
+
+func NewSheetWriter(s Sheet, dir, name string) (*SheetWriter, error) {
+	w := &SheetWriter{
+		s: s,
+		base: filepath.Join(
+			dir,
+			fmt.Sprintf("%s-%s", name, time.Now().Format("2006-01-02_15-04-05")),
+		),
+	}
+	if s.Format != FormatPDF {
+		return w, nil
+	}
+
+	file := w.base + FormatPDF.Ext()
+	f, err := os.Create(file)
+	if err != nil {
+		return nil, err
+	}
+	pw, ph := s.Paper.mm()
+	w.f, w.files = f, []string{file}
+	w.pdf, err = newPDFWriter(f, pw*72/25.4, ph*72/25.4)
+	return w, err
+}
+
+// Page writes p, it can be discarded afterwards.
+func (w *SheetWriter) Page(p *image.NRGBA) error {
+	w.pages++
+	if w.pdf != nil {
+		return w.pdf.page(p)
+	}
+
+	file := fmt.Sprintf("%s-%02d%s", w.base, w.pages, FormatPNG.Ext())
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	w.files = append(w.files, file)
+	err = encodeImage(f, p, FormatPNG)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Pages returns the number of pages written so far.
+func (w *SheetWriter) Pages() int { return w.pages }
+
+// Close finishes the pdf and returns the created files.
+func (w *SheetWriter) Close() ([]string, error) {
+	if w.pdf == nil {
+		return w.files, nil
+	}
+	err := w.pdf.close()
+	if cerr := w.f.Close(); err == nil {
+		err = cerr
+	}
+	return w.files, err
+}
+
+// Abort closes and removes the files written so far.
+func (w *SheetWriter) Abort() {
+	if w.f != nil {
+		w.f.Close()
+	}
+	for _, f := range w.files {
+		os.Remove(f)
+	}
+}
+
+type countWriter struct {
+	w *bufio.Writer
+	n int
+}
+
+func (c *countWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.n += n
+	return n, err
+}
+
+// pdfWriter writes a pdf with one full page jpeg per image as they are
+// added, width and height are the page size in points. Objects: 1 catalog,
+// 2 page tree (written by close once all pages are known), then page,
+// content and image for each page.
+type pdfWriter struct {
+	cw            *countWriter
+	width, height float64
+	offsets       []int
+	pages         int
+}
+
+func newPDFWriter(w io.Writer, width, height float64) (*pdfWriter, error) {
+	p := &pdfWriter{cw: &countWriter{w: bufio.NewWriter(w)}, width: width, height: height, offsets: []int{0, 0, 0}}
+	fmt.Fprint(p.cw, "%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	p.obj(1, "<< /Type /Catalog /Pages 2 0 R >>")
+	return p, p.cw.w.Flush()
+}
+
+func (p *pdfWriter) obj(n int, format string, args ...interface{}) {
+	for len(p.offsets) <= n {
+		p.offsets = append(p.offsets, 0)
+	}
+	p.offsets[n] = p.cw.n
+	fmt.Fprintf(p.cw, "%d 0 obj\n", n)
+	fmt.Fprintf(p.cw, format, args...)
+	fmt.Fprint(p.cw, "\nendobj\n")
+}
+
+func (p *pdfWriter) page(img *image.NRGBA) error {
+	n := 3 + p.pages*3
+	p.pages++
+	p.obj(
+		n,
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.2f %.2f] "+
+			"/Resources << /XObject << /Im0 %d 0 R >> >> /Contents %d 0 R >>",
+		p.width, p.height, n+2, n+1,
+	)
+
+	content := fmt.Sprintf("q %.2f 0 0 %.2f 0 0 cm /Im0 Do Q", p.width, p.height)
+	p.obj(n+1, "<< /Length %d >>\nstream\n%s\nendstream", len(content), content)
+
+	data := bytes.NewBuffer(nil)
+	if err := jpeg.Encode(data, img, &jpeg.Options{Quality: 90}); err != nil {
+		return err
+	}
+	b := img.Bounds()
+	p.obj(
+		n+2,
+		"<< /Type /XObject /Subtype /Image /Width %d /Height %d "+
+			"/ColorSpace /DeviceRGB /BitsPerComponent 8 /Filter /DCTDecode /Length %d >>\nstream\n%s\nendstream",
+		b.Dx(), b.Dy(), data.Len(), data.Bytes(),
+	)
+	return p.cw.w.Flush()
+}
+
+func (p *pdfWriter) close() error {
+	kids := bytes.NewBuffer(nil)
+	for i := 0; i < p.pages; i++ {
+		fmt.Fprintf(kids, "%d 0 R ", 3+i*3)
+	}
+	p.obj(2, "<< /Type /Pages /Kids [ %s] /Count %d >>", kids, p.pages)
+
+	xref := p.cw.n
+	fmt.Fprintf(p.cw, "xref\n0 %d\n0000000000 65535 f \n", len(p.offsets))
+	for _, o := range p.offsets[1:] {
+		fmt.Fprintf(p.cw, "%010d 00000 n \n", o)
+	}
+	fmt.Fprintf(p.cw, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(p.offsets), xref)
+	return p.cw.w.Flush()
+}
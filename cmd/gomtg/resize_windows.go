@@ -0,0 +1,7 @@
+// +build windows
+
+package main
+
+import "os"
+
+func notifyResize(ch chan<- os.Signal) {}
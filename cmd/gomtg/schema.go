@@ -0,0 +1,158 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// schemaVersion is the version of the database layout written by this
+// version of gomtg. It is stored in a header record in json databases and as
+// the user_version in sqlite databases. json databases without a header are
+// version 1.
+const schemaVersion = 6
+
+// jsonRecord is a single line of a json database, migrations operate on
+// these instead of jsonCard so they can handle renamed or removed fields.
+type jsonRecord map[string]interface{}
+
+// schemaMigration upgrades a database to version, json and sqlite are nil if
+// there were no changes for that format (e.g.: sqlite databases start at
+// version 2).
+type schemaMigration struct {
+	version int
+	desc    string
+	json    func([]jsonRecord) error
+	sqlite  func(*sql.Tx) error
+}
+
+var schemaMigrations = []schemaMigration{
+	{
+		version: 2,
+		desc:    "assign copy ids",
+		json: func(records []jsonRecord) error {
+			seen := make(map[string]struct{}, len(records))
+			for _, r := range records {
+				id, _ := r["id"].(string)
+				if _, ok := seen[id]; ok || id == "" {
+					id = string(newCopyID())
+					for _, ok := seen[id]; ok; _, ok = seen[id] {
+						id = string(newCopyID())
+					}
+					r["id"] = id
+				}
+				seen[id] = struct{}{}
+			}
+			return nil
+		},
+	},
+	{
+		version: 3,
+		desc:    "add collector numbers",
+		sqlite: func(tx *sql.Tx) error {
+			_, err := tx.Exec(`ALTER TABLE cards ADD COLUMN number TEXT NOT NULL DEFAULT ''`)
+			return err
+		},
+	},
+	{
+		version: 4,
+		desc:    "add owners",
+		sqlite: func(tx *sql.Tx) error {
+			_, err := tx.Exec(`ALTER TABLE cards ADD COLUMN owner TEXT NOT NULL DEFAULT ''`)
+			return err
+		},
+	},
+	{
+		version: 5,
+		desc:    "add storage locations",
+		sqlite: func(tx *sql.Tx) error {
+			_, err := tx.Exec(`ALTER TABLE cards ADD COLUMN container TEXT NOT NULL DEFAULT ''`)
+			if err == nil {
+				_, err = tx.Exec(`ALTER TABLE cards ADD COLUMN slot INTEGER NOT NULL DEFAULT 0`)
+			}
+			return err
+		},
+	},
+	{
+		version: 6,
+		desc:    "add loans",
+		sqlite: func(tx *sql.Tx) error {
+			_, err := tx.Exec(`ALTER TABLE cards ADD COLUMN lent_to TEXT NOT NULL DEFAULT ''`)
+			if err == nil {
+				_, err = tx.Exec(`ALTER TABLE cards ADD COLUMN lent_at TEXT NOT NULL DEFAULT ''`)
+			}
+			return err
+		},
+	},
+}
+
+// SchemaUpgrade describes an upgrade that was applied while opening a
+// database.
+type SchemaUpgrade struct {
+	From   int
+	To     int
+	Backup string
+}
+
+func (u SchemaUpgrade) String() string {
+	return fmt.Sprintf(
+		"upgraded database from version %d to %d, a backup of the old version is in '%s'",
+		u.From,
+		u.To,
+		u.Backup,
+	)
+}
+
+// checkSchema returns an error if version can not be read by this version of
+// gomtg.
+func checkSchema(file string, version int) error {
+	if version > schemaVersion {
+		return fmt.Errorf(
+			"database '%s' has schema version %d, this version of gomtg only supports up to %d, please upgrade gomtg",
+			file,
+			version,
+			schemaVersion,
+		)
+	}
+	if version < 1 {
+		return fmt.Errorf("database '%s' has an invalid schema version %d", file, version)
+	}
+	return nil
+}
+
+// pendingMigrations returns the migrations needed to upgrade from version.
+func pendingMigrations(version int) []schemaMigration {
+	l := make([]schemaMigration, 0, len(schemaMigrations))
+	for _, m := range schemaMigrations {
+		if m.version > version {
+			l = append(l, m)
+		}
+	}
+	return l
+}
+
+// backupDB copies file before it is upgraded from version.
+func backupDB(file string, version int) (string, error) {
+	dst := fmt.Sprintf("%s.v%d-%s.bak", file, version, time.Now().Format("2006-01-02_15-04-05"))
+	src, err := os.Open(file)
+	if err != nil {
+		return dst, err
+	}
+	defer src.Close()
+
+	f, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return dst, err
+	}
+	_, err = io.Copy(f, src)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(dst)
+		return dst, fmt.Errorf("failed to back up database before upgrading: %w", err)
+	}
+	return dst, nil
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// checkUpgrade verifies the upgrade of the database in file from version and
+// that its backup holds the original contents.
+func checkUpgrade(t *testing.T, store Storage, file string, version int, orig []byte) {
+	t.Helper()
+	u, ok := store.Upgraded()
+	if !ok {
+		t.Fatal("database was not upgraded")
+	}
+	if u.From != version || u.To != schemaVersion {
+		t.Fatalf("upgraded from %d to %d, want %d to %d", u.From, u.To, version, schemaVersion)
+	}
+	if !strings.HasPrefix(u.Backup, file+".v") {
+		t.Fatalf("backup '%s' is not next to '%s'", u.Backup, file)
+	}
+	backup, err := os.ReadFile(u.Backup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(backup) != string(orig) {
+		t.Fatal("backup differs from the original database")
+	}
+}
+
+func TestMigrateJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		version int
+		lines   []string
+	}{
+		{
+			"v1",
+			1,
+			[]string{
+				`{"name":"Lightning Bolt","uuid":"aaaa","set_id":"LEA","tags":["foil"],"price":{"t":"2020-01-02T03:04:05Z","eur":1.5}}`,
+				`{"name":"Lightning Bolt","uuid":"aaaa","set_id":"LEA","tags":[],"price":{"t":"2020-01-02T03:04:05Z","eur":1.5}}`,
+				`{"name":"Force of Will","uuid":"dddd","set_id":"ALL","tags":null,"price":{"t":"0001-01-01T00:00:00Z","eur":0}}`,
+			},
+		},
+		{
+			"v2",
+			2,
+			[]string{
+				`{"schema":2}`,
+				`{"id":"0123456789abcdef","added":"2021-01-01T00:00:00Z","name":"Lightning Bolt","uuid":"aaaa","set_id":"LEA","tags":["foil"],"price":{"t":"2020-01-02T03:04:05Z","eur":1.5}}`,
+				`{"id":"0123456789abcdef","added":"2021-01-02T00:00:00Z","name":"Lightning Bolt","uuid":"aaaa","set_id":"LEA","tags":[],"price":{"t":"2020-01-02T03:04:05Z","eur":1.5}}`,
+				`{"id":"fedcba9876543210","added":"2021-01-03T00:00:00Z","name":"Force of Will","uuid":"dddd","set_id":"ALL","tags":[],"price":{"t":"0001-01-01T00:00:00Z","eur":0}}`,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "collection.db")
+			orig := []byte(strings.Join(test.lines, "\n") + "\n")
+			if err := os.WriteFile(file, orig, 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			store, err := OpenStorage(file, FormatJSON)
+			if err != nil {
+				t.Fatal(err)
+			}
+			db, err := LoadDB(store)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkUpgrade(t, store, file, test.version, orig)
+
+			cards := db.Cards()
+			if len(cards) != 3 {
+				t.Fatalf("%d cards, want 3", len(cards))
+			}
+			if cards[0].Name() != "Lightning Bolt" || !cards[0].Foil() || cards[0].Pricing().EUR != 1.5 {
+				t.Fatalf("first card not migrated: %+v", cards[0].json())
+			}
+			if cards[2].Name() != "Force of Will" {
+				t.Fatalf("cards not in order: %s", cards[2].Name())
+			}
+			ids := make(map[CopyID]struct{}, len(cards))
+			for _, c := range cards {
+				if c.ID() == "" {
+					t.Fatal("card without copy id")
+				}
+				ids[c.ID()] = struct{}{}
+			}
+			if len(ids) != len(cards) {
+				t.Fatal("duplicate copy ids")
+			}
+			want := dumpDB(t, db)
+			db.Close()
+
+			data, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var h jsonHeader
+			if err := json.Unmarshal(data[:strings.IndexByte(string(data), '\n')], &h); err != nil {
+				t.Fatal(err)
+			}
+			if h.Schema != schemaVersion {
+				t.Fatalf("schema %d written, want %d", h.Schema, schemaVersion)
+			}
+
+			// the assigned copy ids are stable.
+			db = openTestDB(t, file, FormatJSON)
+			defer db.Close()
+			if _, ok := db.store.Upgraded(); ok {
+				t.Fatal("upgraded twice")
+			}
+			if got := dumpDB(t, db); got != want {
+				t.Fatalf("after reload:\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestMigrateSQLite(t *testing.T) {
+	// the layout before the schema was versioned.
+	const v2 = `
+CREATE TABLE cards (
+	id       TEXT PRIMARY KEY,
+	seq      INTEGER NOT NULL UNIQUE,
+	added    TEXT NOT NULL,
+	name     TEXT NOT NULL,
+	uuid     TEXT NOT NULL,
+	set_id   TEXT NOT NULL,
+	price_t  TEXT NOT NULL,
+	eur      REAL NOT NULL,
+	eur_foil REAL NOT NULL,
+	usd      REAL NOT NULL,
+	usd_foil REAL NOT NULL
+);
+CREATE TABLE card_tags (
+	id  TEXT NOT NULL,
+	tag TEXT NOT NULL,
+	PRIMARY KEY (id, tag)
+);
+INSERT INTO cards VALUES ('0123456789abcdef', 1, '2021-01-01T00:00:00Z', 'Lightning Bolt', 'aaaa', 'LEA', '2020-01-02T03:04:05Z', 1.5, 3, 2, 4);
+INSERT INTO cards VALUES ('fedcba9876543210', 2, '2021-01-02T00:00:00Z', 'Force of Will', 'dddd', 'ALL', '2020-01-02T03:04:05Z', 0, 0, 0, 0);
+INSERT INTO card_tags VALUES ('0123456789abcdef', 'foil');
+`
+	file := filepath.Join(t.TempDir(), "collection.db")
+	old, err := sql.Open("sqlite", file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := old.Exec(v2); err != nil {
+		t.Fatal(err)
+	}
+	old.Close()
+	orig, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := openTestDB(t, file, FormatSQLite)
+	checkUpgrade(t, db.store, file, 2, orig)
+	cards := db.Cards()
+	if len(cards) != 2 {
+		t.Fatalf("%d cards, want 2", len(cards))
+	}
+	bolt := cards[0]
+	if bolt.ID() != "0123456789abcdef" || !bolt.Foil() || bolt.Pricing().USDFoil != 4 {
+		t.Fatalf("first card not migrated: %+v", bolt.json())
+	}
+	if bolt.Owner() != "" || !bolt.Location().Empty() || !bolt.Loan().Empty() {
+		t.Fatalf("new columns are not empty: %+v", bolt.json())
+	}
+
+	// the added columns can be written.
+	bolt.SetOwner("alice")
+	bolt.SetLocation(Location{"box", 3})
+	bolt.SetLoan(Loan{To: "bob"})
+	if _, err := db.Save(); err != nil {
+		t.Fatal(err)
+	}
+	want := dumpDB(t, db)
+	db.Close()
+
+	db = openTestDB(t, file, FormatSQLite)
+	defer db.Close()
+	if _, ok := db.store.Upgraded(); ok {
+		t.Fatal("upgraded twice")
+	}
+	if got := dumpDB(t, db); got != want {
+		t.Fatalf("after reload:\n%s\nwant:\n%s", got, want)
+	}
+}
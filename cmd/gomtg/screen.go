@@ -0,0 +1,157 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mattn/go-runewidth"
+)
+
+// Screen is a full-screen layout consisting of a status line, a scrollable
+// pane, an optional pinned pane footer, a message area, a hint and an
+// input line (in that order).
+//
+// Image, if set, is an escape sequence drawn over the (empty) pane after the
+// text has been written. ImageClear is written before anything else to remove
+// images drawn by a previous Render.
+type Screen struct {
+	Status     string
+	Rows       []string
+	Footer     string
+	Cursor     int // < 0 disables highlighting
+	Offset     int
+	Messages   []string
+	Hint       string
+	Prompt     string
+	Image      string
+	ImageClear string
+}
+
+// MaxMessages returns the amount of messages that fit below the pane.
+func MaxMessages(height int) int {
+	n := height / 3
+	if n < 3 {
+		n = 3
+	}
+	return n
+}
+
+// PaneHeight returns the amount of rows available for the scrollable pane.
+func (s Screen) PaneHeight(height int) int {
+	fixed := 3 + len(s.Messages)
+	if s.Footer != "" {
+		fixed++
+	}
+	h := height - fixed
+	if h < 1 {
+		h = 1
+	}
+	return h
+}
+
+// Scroll moves the cursor by n rows and makes sure it remains visible.
+func (s *Screen) Scroll(n, height int) {
+	s.Cursor += n
+	s.Clamp(height)
+}
+
+// ScrollView moves the visible part of the pane by n rows without
+// touching the cursor.
+func (s *Screen) ScrollView(n, height int) {
+	h := s.PaneHeight(height)
+	s.Offset += n
+	if s.Offset > len(s.Rows)-h {
+		s.Offset = len(s.Rows) - h
+	}
+	if s.Offset < 0 {
+		s.Offset = 0
+	}
+}
+
+// Clamp makes sure the cursor is within bounds and visible.
+func (s *Screen) Clamp(height int) {
+	h := s.PaneHeight(height)
+	if s.Cursor >= len(s.Rows) {
+		s.Cursor = len(s.Rows) - 1
+	}
+	if s.Cursor < 0 {
+		s.Cursor = 0
+	}
+	if s.Cursor < s.Offset {
+		s.Offset = s.Cursor
+	}
+	if s.Cursor >= s.Offset+h {
+		s.Offset = s.Cursor - h + 1
+	}
+	if s.Offset > len(s.Rows)-h {
+		s.Offset = len(s.Rows) - h
+	}
+	if s.Offset < 0 {
+		s.Offset = 0
+	}
+}
+
+func (s Screen) Render(w io.Writer, width, height int) error {
+	h := s.PaneHeight(height)
+	lines := make([]string, 0, height)
+	lines = append(lines, truncate(s.Status, width))
+
+	for i := s.Offset; i < s.Offset+h; i++ {
+		if i < 0 || i >= len(s.Rows) {
+			lines = append(lines, "")
+			continue
+		}
+		row := truncate(s.Rows[i], width)
+		if s.Cursor >= 0 && i == s.Cursor {
+			row = "\033[7m" + strings.ReplaceAll(row, "\033[0m", "\033[0m\033[7m") + "\033[0m"
+		}
+		lines = append(lines, row)
+	}
+	if s.Footer != "" {
+		lines = append(lines, truncate(s.Footer, width))
+	}
+
+	for _, m := range s.Messages {
+		lines = append(lines, truncate(m, width))
+	}
+	lines = append(lines, truncate(s.Hint, width))
+	lines = append(lines, truncate(s.Prompt, width))
+
+	out := s.ImageClear + "\033[H" + strings.Join(lines, "\033[K\n") + "\033[K\033[J"
+	if s.Image != "" {
+		out += "\0337\033[2;1H" + s.Image + "\0338"
+	}
+	_, err := io.WriteString(w, out)
+	return err
+}
+
+// truncate cuts s to the given terminal width, leaving ansi escape
+// sequences intact.
+func truncate(s string, width int) string {
+	if width <= 0 || runewidth.StringWidth(csiRE.ReplaceAllString(s, "")) <= width {
+		return s
+	}
+
+	var b strings.Builder
+	w := 0
+	csis := csiRE.FindAllStringIndex(s, -1)
+	for i := 0; i < len(s); {
+		if len(csis) != 0 && csis[0][0] == i {
+			b.WriteString(s[csis[0][0]:csis[0][1]])
+			i = csis[0][1]
+			csis = csis[1:]
+			continue
+		}
+		r, n := utf8.DecodeRuneInString(s[i:])
+		rw := runewidth.RuneWidth(r)
+		if w+rw > width {
+			break
+		}
+		w += rw
+		b.WriteString(s[i : i+n])
+		i += n
+	}
+
+	return b.String() + "\033[0m"
+}
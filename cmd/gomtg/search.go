@@ -0,0 +1,280 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/frizinak/gomtg/fuzzy"
+	"github.com/frizinak/gomtg/mtgjson"
+)
+
+var numericRE = regexp.MustCompile(`^\d+[,\-]?\d*$`)
+
+func (a *App) BuildIndex() {
+	list := make([]string, 0, len(a.Cards.Cards))
+	for _, card := range a.Cards.Cards {
+		list = append(list, card.Name)
+	}
+	a.fuzz = fuzzy.NewIndex(2, list)
+}
+
+func (a *App) BuildLocalIndex() {
+	list := make([]string, 0)
+	for _, card := range a.DB.Cards() {
+		list = append(list, card.Name())
+	}
+	a.localFuzz = fuzzy.NewIndex(2, list)
+}
+
+// SearchAll fuzzy searches all known cards by name.
+func (a *App) SearchAll(qry string, set mtgjson.SetID) []Card {
+	res := a.fuzz.Search(qry, func(score, min, max int) bool {
+		return score > 0 && score == max
+	})
+
+	list := make([]Card, 0, len(res))
+	for _, ix := range res {
+		c := a.Cards.Cards[ix]
+		if set != "" && c.SetCode != set {
+			continue
+		}
+		// other faces are listed as part of their front face.
+		if !c.Front() && a.Cards.FrontFace(c).UUID != c.UUID {
+			continue
+		}
+		list = append(list, c)
+	}
+
+	return list
+}
+
+// SearchLocal searches the collection, see /help for the query syntax.
+// Only the last numeric range in query is used.
+func (a *App) SearchLocal(query []string, set mtgjson.SetID) []LocalCard {
+	var lastNumeric string
+	qry := make([]string, 0, len(query))
+	for _, p := range query {
+		if numericRE.MatchString(p) {
+			lastNumeric = p
+			continue
+		}
+		qry = append(qry, p)
+	}
+	filters := []func(c LocalCard) bool{
+		func(c LocalCard) bool {
+			return set == "" || c.SetID() == set
+		},
+	}
+
+	qryTags := make([]string, 0, len(qry))
+	qryNotTags := make([]string, 0, len(qry))
+	qryMana := make([]string, 0, len(qry))
+	qryKeywords := make([]string, 0, len(qry))
+	qryIDs := make([]string, 0, len(qry))
+	qryOwners := make([]string, 0, len(qry))
+	qryContainers := make([]string, 0, len(qry))
+	qryLent := make([]string, 0, len(qry))
+	_qryStr := make([]string, 0, len(qry))
+	for _, p := range qry {
+		switch {
+		case len(p) == 0:
+			continue
+		case p[0] == '+':
+			qryTags = append(qryTags, p[1:])
+		case p[0] == '-':
+			qryNotTags = append(qryNotTags, p[1:])
+		case p[0] == '{' && p[len(p)-1] == '}':
+			// case len(p) == 3 && p[0] == '{' && p[len(p)-1] == '}':
+			qryMana = append(qryMana, strings.ToUpper(p[1:len(p)-1]))
+		case p[0] == '#':
+			qryKeywords = append(qryKeywords, strings.ToLower(p[1:]))
+		case p[0] == '@':
+			qryIDs = append(qryIDs, strings.ToLower(p[1:]))
+		case strings.HasPrefix(p, "owner:"):
+			qryOwners = append(qryOwners, p[6:])
+		case strings.HasPrefix(p, "in:"):
+			qryContainers = append(qryContainers, p[3:])
+		case strings.HasPrefix(p, "lent:"):
+			qryLent = append(qryLent, p[5:])
+		default:
+			_qryStr = append(_qryStr, p)
+		}
+	}
+	qryStr := strings.Join(_qryStr, " ")
+
+	if len(qryTags) != 0 || len(qryNotTags) != 0 {
+		filters = append(filters, func(c LocalCard) bool {
+			for _, t := range qryTags {
+				if !c.HasTag(t) {
+					return false
+				}
+			}
+			for _, t := range qryNotTags {
+				if c.HasTag(t) {
+					return false
+				}
+			}
+			return true
+		})
+	}
+
+	if len(qryIDs) != 0 {
+		filters = append(filters, func(c LocalCard) bool {
+			for _, id := range qryIDs {
+				if strings.HasPrefix(string(c.ID()), id) {
+					return true
+				}
+			}
+			return false
+		})
+	}
+
+	if len(qryOwners) != 0 {
+		filters = append(filters, func(c LocalCard) bool {
+			for _, o := range qryOwners {
+				if strings.EqualFold(c.Owner(), o) {
+					return true
+				}
+			}
+			return false
+		})
+	}
+
+	if len(qryContainers) != 0 {
+		filters = append(filters, func(c LocalCard) bool {
+			for _, name := range qryContainers {
+				if c.Location().Container == name {
+					return true
+				}
+			}
+			return false
+		})
+	}
+
+	if len(qryLent) != 0 {
+		filters = append(filters, func(c LocalCard) bool {
+			to := c.Loan().To
+			for _, name := range qryLent {
+				if (name == "*" && to != "") || strings.EqualFold(to, name) {
+					return true
+				}
+			}
+			return false
+		})
+	}
+
+	if len(qryMana) != 0 {
+		has := make([]byte, 0)
+		nhas := make([]byte, 0)
+		for _, qry := range qryMana {
+			p := strings.Split(qry, ",")
+			for _, color := range p {
+				if color == "" {
+					continue
+				}
+				f := color[0]
+				if f == '+' || f == '-' {
+					color = color[1:]
+				}
+				if f == '-' {
+					nhas = append(nhas, color...)
+					continue
+				}
+				has = append(has, color...)
+			}
+		}
+		filters = append(filters, func(c LocalCard) bool {
+			rc, _ := a.Cards.ByUUID(c.UUID())
+			d := []byte{'{', 0, '}'}
+			for _, m := range has {
+				d[1] = m
+				if !strings.Contains(rc.ManaCost, string(d)) {
+					return false
+				}
+			}
+			for _, m := range nhas {
+				d[1] = m
+				if strings.Contains(rc.ManaCost, string(d)) {
+					return false
+				}
+			}
+			return true
+		})
+	}
+
+	if len(qryKeywords) != 0 {
+		filters = append(filters, func(c LocalCard) bool {
+			rc, _ := a.Cards.ByUUID(c.UUID())
+			for _, m := range qryKeywords {
+				match := false
+				for _, kw := range rc.Keywords {
+					if strings.Contains(strings.ToLower(kw), m) {
+						match = true
+					}
+				}
+				for _, kw := range rc.Types {
+					if strings.Contains(strings.ToLower(kw), m) {
+						match = true
+					}
+				}
+				if !match {
+					return false
+				}
+			}
+			return true
+		})
+	}
+
+	search := func() []int {
+		return a.localFuzz.Search(qryStr, func(score, min, max int) bool {
+			return score > 0 && score == max
+		})
+	}
+
+	if qryStr == "" {
+		search = func() []int {
+			all := a.DB.Cards()
+			list := make([]int, 0, len(all))
+			for i := range all {
+				list = append(list, i)
+			}
+			return list
+		}
+	}
+
+	if lastNumeric != "" {
+		ints, ok := intRange(lastNumeric)
+		m := make(map[int]struct{}, len(ints))
+		for _, i := range ints {
+			m[i-1] = struct{}{}
+		}
+		if ok {
+			filters = append(filters, func(c LocalCard) bool {
+				_, ok := m[c.Index]
+				return ok
+			})
+		}
+	}
+
+	res := search()
+	list := make([]LocalCard, 0, len(res))
+	for _, ix := range res {
+		c, ok := a.DB.CardAt(ix)
+		if !ok {
+			continue
+		}
+		ok = true
+		lc := NewLocalCard(c, ix)
+		for _, f := range filters {
+			if !f(lc) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			list = append(list, lc)
+		}
+	}
+
+	return list
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/frizinak/gomtg/mtgjson"
+)
+
+//go:embed openapi.yaml
+var openAPI []byte
+
+var errNotFound = errors.New("not found")
+
+// Server exposes the collection and a single shared staging queue over
+// http. All requests are serialized.
+type Server struct {
+	app   *App
+	file  string
+	mutex sync.Mutex
+	queue []State
+}
+
+func NewServer(app *App, file string) *Server {
+	return &Server{
+		app:   app,
+		file:  file,
+		queue: []State{{Mode: ModeCollection, Sort: SortIndex}},
+	}
+}
+
+type jsonError struct {
+	Error string `json:"error"`
+}
+
+type serverQueue struct {
+	*ResultQueue
+	Undo int `json:"undo"`
+}
+
+type serverPrice struct {
+	UUID     mtgjson.UUID `json:"uuid"`
+	Currency string       `json:"currency"`
+	Price    float64      `json:"price"`
+	PriceOK  bool         `json:"price_ok"`
+	Pricing  Pricing      `json:"pricing"`
+}
+
+type serverSelection struct {
+	UUIDs []mtgjson.UUID `json:"uuids"`
+	Tags  []string       `json:"tags"`
+}
+
+type serverTagging struct {
+	Indexes []int    `json:"indexes"`
+	IDs     []CopyID `json:"ids"`
+	Add     []string `json:"add"`
+	Remove  []string `json:"remove"`
+}
+
+type serverDelete struct {
+	Indexes []int    `json:"indexes"`
+	IDs     []CopyID `json:"ids"`
+}
+
+type serverCommit struct {
+	Saved     bool     `json:"saved"`
+	Conflicts []string `json:"conflicts,omitempty"`
+}
+
+// Do runs f while holding the lock that serializes all requests.
+func (s *Server) Do(f func()) {
+	s.mutex.Lock()
+	f()
+	s.mutex.Unlock()
+}
+
+func (s *Server) state() State { return s.queue[len(s.queue)-1] }
+
+func (s *Server) modify(cb func(st State) State) {
+	s.queue = append(s.queue, cb(s.state()))
+}
+
+func (s *Server) queueResult() serverQueue {
+	return serverQueue{s.app.ResultQueue(s.state()), len(s.queue) - 1}
+}
+
+func (s *Server) local(indexes []int, ids []CopyID) ([]LocalCard, error) {
+	if len(indexes) == 0 && len(ids) == 0 {
+		return nil, errors.New("no indexes or ids given")
+	}
+	l := make([]LocalCard, 0, len(indexes)+len(ids))
+	for _, ix := range indexes {
+		c, ok := s.app.DB.CardAt(ix - 1)
+		if !ok {
+			return nil, fmt.Errorf("no card at index %d", ix)
+		}
+		l = append(l, NewLocalCard(c, ix-1))
+	}
+	for _, id := range ids {
+		c, ok := s.app.DB.ByID(id)
+		if !ok {
+			return nil, fmt.Errorf("card '%s' %w", id, errNotFound)
+		}
+		l = append(l, NewLocalCard(c, s.app.DB.IndexOf(c)))
+	}
+	return l, nil
+}
+
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	handle := func(path, method string, h func(r *http.Request) (interface{}, error)) {
+		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != method {
+				w.Header().Set("Allow", method)
+				writeJSON(w, http.StatusMethodNotAllowed, jsonError{"method not allowed"})
+				return
+			}
+			if method == http.MethodPost {
+				if code, err := checkPost(r); err != nil {
+					writeJSON(w, code, jsonError{err.Error()})
+					return
+				}
+			}
+
+			s.mutex.Lock()
+			res, err := h(r)
+			s.mutex.Unlock()
+			switch {
+			case errors.Is(err, errNotFound):
+				writeJSON(w, http.StatusNotFound, jsonError{err.Error()})
+			case err != nil:
+				writeJSON(w, http.StatusBadRequest, jsonError{err.Error()})
+			default:
+				writeJSON(w, http.StatusOK, res)
+			}
+		})
+	}
+
+	mux.HandleFunc("/api/openapi.yaml", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/yaml")
+		_, _ = w.Write(openAPI)
+	})
+
+	handle("/api/sets", http.MethodGet, func(r *http.Request) (interface{}, error) {
+		return s.app.Cards.Sets, nil
+	})
+
+	handle("/api/cards", http.MethodGet, func(r *http.Request) (interface{}, error) {
+		qry := r.URL.Query().Get("q")
+		if len(qry) < 2 {
+			return nil, errors.New("q should be at least 2 characters")
+		}
+		set := mtgjson.SetID(strings.ToUpper(r.URL.Query().Get("set")))
+		list := s.app.SearchAll(qry, set)
+		if len(list) > 10000 {
+			return nil, errors.New("too many results, try a more specific query")
+		}
+		return s.app.ResultCards(list), nil
+	})
+
+	handle("/api/cards/", http.MethodGet, func(r *http.Request) (interface{}, error) {
+		uuid := mtgjson.UUID(strings.TrimPrefix(r.URL.Path, "/api/cards/"))
+		c, ok := s.app.Cards.ByUUID(uuid)
+		if !ok {
+			return nil, fmt.Errorf("card %w", errNotFound)
+		}
+		return c.Full()
+	})
+
+	handle("/api/collection", http.MethodGet, func(r *http.Request) (interface{}, error) {
+		qry := strings.Fields(r.URL.Query().Get("q"))
+		set := mtgjson.SetID(strings.ToUpper(r.URL.Query().Get("set")))
+		return s.app.ResultLocalCards(s.app.SearchLocal(qry, set)), nil
+	})
+
+	handle("/api/prices/", http.MethodGet, func(r *http.Request) (interface{}, error) {
+		uuid := mtgjson.UUID(strings.TrimPrefix(r.URL.Path, "/api/prices/"))
+		if _, ok := s.app.Cards.ByUUID(uuid); !ok {
+			return nil, fmt.Errorf("card %w", errNotFound)
+		}
+		refresh := r.URL.Query().Get("refresh") == "1"
+		foil := r.URL.Query().Get("foil") == "1"
+		p := s.app.GetFullPricing(uuid, refresh, refresh, refresh)
+		v, ok := s.app.GetPricing(uuid, foil, false)
+		return serverPrice{uuid, s.app.pricing.currency, v, ok, p}, nil
+	})
+
+	handle("/api/queue", http.MethodGet, func(r *http.Request) (interface{}, error) {
+		return s.queueResult(), nil
+	})
+
+	handle("/api/selection", http.MethodPost, func(r *http.Request) (interface{}, error) {
+		var req serverSelection
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return nil, err
+		}
+		if len(req.UUIDs) == 0 {
+			return nil, errors.New("no uuids given")
+		}
+		sel := make([]Select, 0, len(req.UUIDs))
+		for _, uuid := range req.UUIDs {
+			c, ok := s.app.Cards.ByUUID(uuid)
+			if !ok {
+				return nil, fmt.Errorf("card '%s' %w", uuid, errNotFound)
+			}
+			n := NewSelect(c)
+			n.Tags.Add(req.Tags...)
+			sel = append(sel, n)
+		}
+		s.modify(func(st State) State {
+			st.Selection = append(st.Selection[:len(st.Selection):len(st.Selection)], sel...)
+			return st
+		})
+		return s.queueResult(), nil
+	})
+
+	handle("/api/tagging", http.MethodPost, func(r *http.Request) (interface{}, error) {
+		var req serverTagging
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return nil, err
+		}
+		if len(req.Add) == 0 && len(req.Remove) == 0 {
+			return nil, errors.New("no tags given")
+		}
+		cards, err := s.local(req.Indexes, req.IDs)
+		if err != nil {
+			return nil, err
+		}
+		tags := make([]Tagging, 0, len(cards))
+		for _, c := range cards {
+			t := NewTagging(c.DBCard)
+			for _, tag := range req.Add {
+				t.Add(true, tag)
+			}
+			for _, tag := range req.Remove {
+				t.Add(false, tag)
+			}
+			tags = append(tags, t)
+		}
+		s.modify(func(st State) State {
+			st.Tagging = append(st.Tagging[:len(st.Tagging):len(st.Tagging)], tags...)
+			return st
+		})
+		return s.queueResult(), nil
+	})
+
+	handle("/api/delete", http.MethodPost, func(r *http.Request) (interface{}, error) {
+		var req serverDelete
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return nil, err
+		}
+		cards, err := s.local(req.Indexes, req.IDs)
+		if err != nil {
+			return nil, err
+		}
+		s.modify(func(st State) State {
+			st.Delete = append(st.Delete[:len(st.Delete):len(st.Delete)], cards...)
+			return st
+		})
+		return s.queueResult(), nil
+	})
+
+	handle("/api/commit", http.MethodPost, func(r *http.Request) (interface{}, error) {
+		saved, err := s.app.Commit(s.state(), s.file)
+		var cerr *CommitError
+		if errors.As(err, &cerr) {
+			if cerr.Journal != nil {
+				// the database was reloaded, stage the changes again.
+				base := s.state().Unstaged()
+				st, _ := cerr.Journal.Apply(s.app, base)
+				s.queue = []State{base, st}
+			}
+			return nil, err
+		}
+		for i := range s.queue {
+			s.queue[i] = s.queue[i].Unstaged()
+		}
+		res := serverCommit{Saved: saved}
+		if saved {
+			for _, c := range s.app.DB.Synced().Conflicts {
+				res.Conflicts = append(res.Conflicts, c.String())
+			}
+		}
+		return res, err
+	})
+
+	handle("/api/undo", http.MethodPost, func(r *http.Request) (interface{}, error) {
+		if len(s.queue) > 1 {
+			s.queue = s.queue[:len(s.queue)-1]
+		}
+		return s.queueResult(), nil
+	})
+
+	return mux
+}
+
+// checkPost rejects requests that change the collection unless they are
+// json and, if sent by a browser, come from a page served by us. A web page
+// on another origin could otherwise post a form to us.
+func checkPost(r *http.Request) (int, error) {
+	typ, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || typ != "application/json" {
+		return http.StatusUnsupportedMediaType, errors.New("Content-Type must be application/json")
+	}
+	if o := r.Header.Get("Origin"); o != "" {
+		u, err := url.Parse(o)
+		if err != nil || u.Host != r.Host {
+			return http.StatusForbidden, fmt.Errorf("cross-origin request from '%s' refused", o)
+		}
+	}
+	return 0, nil
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
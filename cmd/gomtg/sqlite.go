@@ -0,0 +1,340 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/frizinak/gomtg/mtgjson"
+	_ "modernc.org/sqlite"
+)
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS cards (
+	id        TEXT PRIMARY KEY,
+	seq       INTEGER NOT NULL UNIQUE,
+	added     TEXT NOT NULL,
+	name      TEXT NOT NULL,
+	uuid      TEXT NOT NULL,
+	set_id    TEXT NOT NULL,
+	number    TEXT NOT NULL DEFAULT '',
+	owner     TEXT NOT NULL DEFAULT '',
+	container TEXT NOT NULL DEFAULT '',
+	slot      INTEGER NOT NULL DEFAULT 0,
+	lent_to   TEXT NOT NULL DEFAULT '',
+	lent_at   TEXT NOT NULL DEFAULT '',
+	price_t   TEXT NOT NULL,
+	eur       REAL NOT NULL,
+	eur_foil  REAL NOT NULL,
+	usd       REAL NOT NULL,
+	usd_foil  REAL NOT NULL
+);
+CREATE INDEX IF NOT EXISTS cards_uuid ON cards (uuid);
+CREATE INDEX IF NOT EXISTS cards_set_id ON cards (set_id);
+CREATE INDEX IF NOT EXISTS cards_owner ON cards (owner);
+CREATE INDEX IF NOT EXISTS cards_container ON cards (container, slot);
+
+CREATE TABLE IF NOT EXISTS card_tags (
+	id  TEXT NOT NULL,
+	tag TEXT NOT NULL,
+	PRIMARY KEY (id, tag)
+);
+CREATE INDEX IF NOT EXISTS card_tags_tag ON card_tags (tag);
+
+CREATE TABLE IF NOT EXISTS containers (
+	name TEXT PRIMARY KEY,
+	seq  INTEGER NOT NULL UNIQUE,
+	kind TEXT NOT NULL,
+	size INTEGER NOT NULL DEFAULT 0
+);
+`
+
+// sqliteStorage stores cards in an sqlite database, cards are ordered by
+// the sequence in which they were added and only changed cards are written,
+// each save in a single transaction.
+type sqliteStorage struct {
+	db      *sql.DB
+	upgrade *SchemaUpgrade
+}
+
+// OpenSQLite opens or creates the sqlite database in file and upgrades its
+// schema if needed.
+func OpenSQLite(file string) (*sqliteStorage, error) {
+	db, err := sql.Open("sqlite", file)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	s := &sqliteStorage{db: db}
+	if err := s.init(file); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *sqliteStorage) init(file string) error {
+	var version int
+	if err := s.db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return err
+	}
+	// either a new database or one created before the schema was versioned.
+	if version == 0 {
+		var tables int
+		err := s.db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'cards'`).Scan(&tables)
+		if err != nil {
+			return err
+		}
+		version = schemaVersion
+		if tables != 0 {
+			version = 2
+		}
+	}
+	if err := checkSchema(file, version); err != nil {
+		return err
+	}
+
+	if migrations := pendingMigrations(version); len(migrations) != 0 {
+		backup, err := backupDB(file, version)
+		if err != nil {
+			return err
+		}
+		tx, err := s.db.Begin()
+		if err != nil {
+			return err
+		}
+		for _, m := range migrations {
+			if m.sqlite == nil {
+				continue
+			}
+			if err := m.sqlite(tx); err != nil {
+				tx.Rollback()
+				return fmt.Errorf("schema migration to version %d (%s) failed: %w", m.version, m.desc, err)
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		s.upgrade = &SchemaUpgrade{version, schemaVersion, backup}
+	}
+
+	if _, err := s.db.Exec(sqliteSchema); err != nil {
+		return err
+	}
+	_, err := s.db.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, schemaVersion))
+	return err
+}
+
+func (s *sqliteStorage) Load() ([]jsonCard, error) {
+	tags := make(map[CopyID][]string)
+	rows, err := s.db.Query(`SELECT id, tag FROM card_tags ORDER BY id, tag`)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var id CopyID
+		var tag string
+		if err := rows.Scan(&id, &tag); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		tags[id] = append(tags[id], tag)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = s.db.Query(`
+		SELECT id, added, name, uuid, set_id, number, owner, container, slot, lent_to, lent_at, price_t, eur, eur_foil, usd, usd_foil
+		FROM cards ORDER BY seq`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := make([]jsonCard, 0, 1024)
+	for rows.Next() {
+		var jc jsonCard
+		var uuid, setID, added, priceT, lentTo, lentAt string
+		err := rows.Scan(
+			&jc.ID,
+			&added,
+			&jc.Name,
+			&uuid,
+			&setID,
+			&jc.Number,
+			&jc.Owner,
+			&jc.Container,
+			&jc.Slot,
+			&lentTo,
+			&lentAt,
+			&priceT,
+			&jc.Pricing.EUR,
+			&jc.Pricing.EURFoil,
+			&jc.Pricing.USD,
+			&jc.Pricing.USDFoil,
+		)
+		if err != nil {
+			return nil, err
+		}
+		jc.UUID, jc.SetID = mtgjson.UUID(uuid), mtgjson.SetID(setID)
+		if jc.Added, err = time.Parse(time.RFC3339Nano, added); err != nil {
+			return nil, err
+		}
+		if jc.Pricing.T, err = time.Parse(time.RFC3339Nano, priceT); err != nil {
+			return nil, err
+		}
+		if lentTo != "" {
+			jc.Loan = &Loan{To: lentTo}
+			if jc.Loan.Since, err = time.Parse(time.RFC3339Nano, lentAt); err != nil {
+				return nil, err
+			}
+		}
+		jc.Tags = tags[jc.ID]
+		list = append(list, jc)
+	}
+
+	return list, rows.Err()
+}
+
+func (s *sqliteStorage) Containers() ([]Container, error) {
+	rows, err := s.db.Query(`SELECT name, kind, size FROM containers ORDER BY seq`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []Container
+	for rows.Next() {
+		var c Container
+		if err := rows.Scan(&c.Name, &c.Kind, &c.Size); err != nil {
+			return nil, err
+		}
+		list = append(list, c)
+	}
+	return list, rows.Err()
+}
+
+func (s *sqliteStorage) Save(c Changes) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := s.save(tx, c); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqliteStorage) save(tx *sql.Tx, c Changes) error {
+	// there are few containers, they are rewritten on every save.
+	if _, err := tx.Exec(`DELETE FROM containers`); err != nil {
+		return err
+	}
+	for i, cont := range c.Containers {
+		_, err := tx.Exec(
+			`INSERT INTO containers (name, seq, kind, size) VALUES (?, ?, ?, ?)`,
+			cont.Name,
+			i+1,
+			string(cont.Kind),
+			cont.Size,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	if c.Replace {
+		if _, err := tx.Exec(`DELETE FROM cards`); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`DELETE FROM card_tags`); err != nil {
+			return err
+		}
+	}
+
+	for _, id := range c.Deleted {
+		if _, err := tx.Exec(`DELETE FROM cards WHERE id = ?`, id); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`DELETE FROM card_tags WHERE id = ?`, id); err != nil {
+			return err
+		}
+	}
+
+	upsert, err := tx.Prepare(`
+		INSERT INTO cards (id, seq, added, name, uuid, set_id, number, owner, container, slot, lent_to, lent_at, price_t, eur, eur_foil, usd, usd_foil)
+		VALUES (?, (SELECT COALESCE(MAX(seq), 0) + 1 FROM cards), ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET
+			added = excluded.added,
+			name = excluded.name,
+			uuid = excluded.uuid,
+			set_id = excluded.set_id,
+			number = excluded.number,
+			owner = excluded.owner,
+			container = excluded.container,
+			slot = excluded.slot,
+			lent_to = excluded.lent_to,
+			lent_at = excluded.lent_at,
+			price_t = excluded.price_t,
+			eur = excluded.eur,
+			eur_foil = excluded.eur_foil,
+			usd = excluded.usd,
+			usd_foil = excluded.usd_foil`,
+	)
+	if err != nil {
+		return err
+	}
+	defer upsert.Close()
+
+	for _, card := range c.Changed {
+		jc := card.json()
+		var lentAt string
+		if jc.Loan != nil {
+			lentAt = jc.Loan.Since.Format(time.RFC3339Nano)
+		}
+		_, err := upsert.Exec(
+			jc.ID,
+			jc.Added.Format(time.RFC3339Nano),
+			jc.Name,
+			string(jc.UUID),
+			string(jc.SetID),
+			jc.Number,
+			jc.Owner,
+			jc.Container,
+			jc.Slot,
+			loanOf(jc.Loan).To,
+			lentAt,
+			jc.Pricing.T.Format(time.RFC3339Nano),
+			jc.Pricing.EUR,
+			jc.Pricing.EURFoil,
+			jc.Pricing.USD,
+			jc.Pricing.USDFoil,
+		)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`DELETE FROM card_tags WHERE id = ?`, jc.ID); err != nil {
+			return err
+		}
+		for _, tag := range jc.Tags {
+			if _, err := tx.Exec(`INSERT INTO card_tags (id, tag) VALUES (?, ?)`, jc.ID, tag); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *sqliteStorage) Upgraded() (SchemaUpgrade, bool) {
+	if s.upgrade == nil {
+		return SchemaUpgrade{}, false
+	}
+	return *s.upgrade, true
+}
+
+func (s *sqliteStorage) Close() error { return s.db.Close() }
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/frizinak/gomtg/mtgjson"
 )
@@ -18,19 +19,51 @@ type State struct {
 	Local      []LocalCard
 	Sort       Sort
 	Tags       []string
+	Place      string
+	TradeWith  string
 	PageOffset int
+	Cursor     int
 
 	Filtered bool
 
-	Selection Selection
-	Tagging   []Tagging
-	Delete    []LocalCard
+	Selection  Selection
+	Tagging    []Tagging
+	Delete     []LocalCard
+	Remap      []Remap
+	Split      []Split
+	Ownership  []Ownership
+	Containers []ContainerEdit
+	Placement  []Placement
+	Lending    []Lending
+	Trading    []Trade
 }
 
 func (s State) Changes() bool {
 	return len(s.Selection) != 0 ||
 		len(s.Tagging) != 0 ||
-		len(s.Delete) != 0
+		len(s.Delete) != 0 ||
+		len(s.Remap) != 0 ||
+		len(s.Split) != 0 ||
+		len(s.Ownership) != 0 ||
+		len(s.Containers) != 0 ||
+		len(s.Placement) != 0 ||
+		len(s.Lending) != 0 ||
+		len(s.Trading) != 0
+}
+
+// Unstaged returns s without its staged changes.
+func (s State) Unstaged() State {
+	s.Selection = nil
+	s.Delete = nil
+	s.Tagging = nil
+	s.Remap = nil
+	s.Split = nil
+	s.Ownership = nil
+	s.Containers = nil
+	s.Placement = nil
+	s.Lending = nil
+	s.Trading = nil
+	return s
 }
 
 func (s State) SortLocal(app *App) {
@@ -45,13 +78,17 @@ func (s State) SortLocal(app *App) {
 		}
 	case SortCount:
 		for _, c := range s.Local {
-			ints = append(ints, app.DB.Count(c.UUID()))
+			ints = append(ints, app.DB.Available(c.UUID()))
 		}
 	case SortPrice:
 		for _, c := range s.Local {
 			p, _ := app.GetPricing(c.UUID(), c.Foil(), false)
 			ints = append(ints, int(p*100))
 		}
+	case SortLocation:
+		for _, c := range s.Local {
+			strs = append(strs, locationKey(s.Location(c.DBCard)))
+		}
 	default:
 		for _, c := range s.Local {
 			ints = append(ints, c.Index)
@@ -75,7 +112,7 @@ func (s State) SortOptions(app *App) {
 		}
 	case SortCount:
 		for _, c := range s.Options {
-			ints = append(ints, app.DB.Count(c.UUID))
+			ints = append(ints, app.DB.Available(c.UUID))
 		}
 	default:
 		for _, c := range s.Options {
@@ -94,13 +131,17 @@ const (
 	SortName  Sort = "name"
 	SortPrice Sort = "price"
 	SortCount Sort = "count"
+	// SortLocation sorts by container and slot, it only applies to the
+	// collection.
+	SortLocation Sort = "location"
 )
 
 var Sorts = map[Sort]struct{}{
-	SortIndex: {},
-	SortName:  {},
-	SortPrice: {},
-	SortCount: {},
+	SortIndex:    {},
+	SortName:     {},
+	SortPrice:    {},
+	SortCount:    {},
+	SortLocation: {},
 }
 
 func (s Sort) Valid() bool {
@@ -121,10 +162,22 @@ func (s Selection) Cards() []Card {
 type Select struct {
 	Card
 	Tags newTags
+
+	// ID and Added are kept for cards merged from another database or
+	// restored by reverting a log entry.
+	ID    CopyID
+	Added time.Time
+	// Owner defaults to App.Owner.
+	Owner    string
+	Location Location
+	// Loan is kept for cards merged from another database.
+	Loan Loan
+	// From is who the card was received from in a trade, see Trade.
+	From string
 }
 
 func NewSelect(c Card) Select {
-	return Select{c, make(newTags)}
+	return Select{Card: c, Tags: make(newTags)}
 }
 
 func NewSelection(c []Card) []Select {
@@ -143,6 +196,15 @@ func (s State) Equal(o State) bool {
 		len(s.Tags) != len(o.Tags) ||
 		len(s.Delete) != len(o.Delete) ||
 		len(s.Tagging) != len(o.Tagging) ||
+		len(s.Remap) != len(o.Remap) ||
+		len(s.Split) != len(o.Split) ||
+		len(s.Ownership) != len(o.Ownership) ||
+		len(s.Containers) != len(o.Containers) ||
+		len(s.Placement) != len(o.Placement) ||
+		len(s.Lending) != len(o.Lending) ||
+		len(s.Trading) != len(o.Trading) ||
+		s.Place != o.Place ||
+		s.TradeWith != o.TradeWith ||
 		len(s.Query) != len(o.Query) ||
 		len(s.Options) != len(o.Options) {
 		return false
@@ -183,6 +245,48 @@ func (s State) Equal(o State) bool {
 		}
 	}
 
+	for i := range s.Remap {
+		if s.Remap[i] != o.Remap[i] {
+			return false
+		}
+	}
+
+	for i := range s.Split {
+		if s.Split[i].DBCard != o.Split[i].DBCard || s.Split[i].File != o.Split[i].File {
+			return false
+		}
+	}
+
+	for i := range s.Ownership {
+		if s.Ownership[i].DBCard != o.Ownership[i].DBCard || s.Ownership[i].To != o.Ownership[i].To {
+			return false
+		}
+	}
+
+	for i := range s.Containers {
+		if s.Containers[i] != o.Containers[i] {
+			return false
+		}
+	}
+
+	for i := range s.Placement {
+		if s.Placement[i].DBCard != o.Placement[i].DBCard || s.Placement[i].To != o.Placement[i].To {
+			return false
+		}
+	}
+
+	for i := range s.Lending {
+		if s.Lending[i].DBCard != o.Lending[i].DBCard || !s.Lending[i].Loan.Equal(o.Lending[i].Loan) {
+			return false
+		}
+	}
+
+	for i := range s.Trading {
+		if s.Trading[i].DBCard != o.Trading[i].DBCard || s.Trading[i].With != o.Trading[i].With {
+			return false
+		}
+	}
+
 	return true
 }
 
@@ -229,6 +333,34 @@ func (s State) String(app *App) []string {
 	}
 	data = append(data, delStrs...)
 
+	for _, r := range s.Remap {
+		data = append(data, fmt.Sprintf(" \u2514 %s FIX \033[0m %s", good, r))
+	}
+
+	for _, sp := range s.Split {
+		data = append(data, fmt.Sprintf(" \u2514 %s MOV \033[0m %s", bad, sp))
+	}
+
+	for _, o := range s.Ownership {
+		data = append(data, fmt.Sprintf(" \u2514 %s OWN \033[0m %s", good, o))
+	}
+
+	for _, c := range s.Containers {
+		data = append(data, fmt.Sprintf(" \u2514 %s BOX \033[0m %s", good, c))
+	}
+
+	for _, p := range s.Placement {
+		data = append(data, fmt.Sprintf(" \u2514 %s LOC \033[0m %s", good, p))
+	}
+
+	for _, l := range s.Lending {
+		data = append(data, fmt.Sprintf(" \u2514 %s LEN \033[0m %s", good, l))
+	}
+
+	for _, t := range s.Trading {
+		data = append(data, fmt.Sprintf(" \u2514 %s TRD \033[0m %s", bad, t))
+	}
+
 	return data
 }
 
@@ -255,6 +387,12 @@ func (s State) StringShort(app *App) string {
 	if len(s.Tags) != 0 {
 		d = append(d, fmt.Sprintf("tags:%s", strings.Join(s.Tags, ",")))
 	}
+	if s.Place != "" {
+		d = append(d, fmt.Sprintf("in:%s", s.Place))
+	}
+	if s.TradeWith != "" {
+		d = append(d, fmt.Sprintf("trade:%s", s.TradeWith))
+	}
 
 	mode := fmt.Sprintf("%s %s \033[0m", modeClr, strings.ToUpper(string(s.Mode)))
 	return fmt.Sprintf("%s %s %s \033[0m", mode, clr, strings.Join(d, " "))
@@ -373,6 +511,40 @@ func (t Tagging) NewTagsString() string {
 
 }
 
+// Remap points a card in the collection to another (or the same, updated)
+// mtgjson card, e.g.: after mtgjson re-issued its uuid.
+type Remap struct {
+	*DBCard
+	To mtgjson.UUID
+}
+
+func (r Remap) String() string {
+	if r.To == r.UUID() {
+		return fmt.Sprintf("update %s %s (%s)", r.UUID(), r.Name(), r.SetID())
+	}
+	return fmt.Sprintf("remap %s %s (%s) to %s", r.UUID(), r.Name(), r.SetID(), r.To)
+}
+
+// Split moves a card in the collection to another database.
+type Split struct {
+	LocalCard
+	File string
+}
+
+func (s Split) String() string {
+	return fmt.Sprintf("move %s %s (%s) to '%s'", s.UUID(), s.Name(), s.SetID(), s.File)
+}
+
+// Ownership gives a card in the collection to another owner.
+type Ownership struct {
+	LocalCard
+	To string
+}
+
+func (o Ownership) String() string {
+	return fmt.Sprintf("give %s %s (%s) from '%s' to '%s'", o.UUID(), o.Name(), o.SetID(), o.Owner(), o.To)
+}
+
 type LocalCard struct {
 	*DBCard
 	Index int
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// StorageFormat is the on disk format of a database.
+type StorageFormat string
+
+const (
+	FormatJSON   StorageFormat = "json"
+	FormatSQLite StorageFormat = "sqlite"
+)
+
+func (f StorageFormat) Valid() bool {
+	return f == FormatJSON || f == FormatSQLite
+}
+
+// Storage persists the cards of a DB.
+type Storage interface {
+	// Load returns all cards in order.
+	Load() ([]jsonCard, error)
+	// Containers returns all containers in order, it is called after Load.
+	Containers() ([]Container, error)
+	// Save persists the changes since the last Load or Save.
+	Save(Changes) error
+	// Upgraded returns the schema upgrade applied when opening or loading
+	// the database, if any.
+	Upgraded() (SchemaUpgrade, bool)
+	Close() error
+}
+
+// Changes are the modifications to a DB that have not been saved yet.
+type Changes struct {
+	// Cards are all cards in the database in order.
+	Cards []*DBCard
+	// Changed are the added or modified cards, in order.
+	Changed []*DBCard
+	// Deleted are the copy ids of removed cards.
+	Deleted []CopyID
+	// Containers are all containers in order.
+	Containers []Container
+	// Replace empties the storage first, Changed holds all cards.
+	Replace bool
+}
+
+func (c Changes) Empty() bool { return len(c.Changed) == 0 && len(c.Deleted) == 0 }
+
+var sqliteMagic = []byte("SQLite format 3\x00")
+
+// DetectFormat returns the format of the database in file, or def if it does
+// not exist or is empty.
+func DetectFormat(file string, def StorageFormat) (StorageFormat, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return def, nil
+		}
+		return def, err
+	}
+	defer f.Close()
+
+	head := make([]byte, len(sqliteMagic))
+	n, err := io.ReadFull(f, head)
+	switch {
+	case n == 0:
+		return def, nil
+	case err == nil && bytes.Equal(head, sqliteMagic):
+		return FormatSQLite, nil
+	}
+	return FormatJSON, nil
+}
+
+// OpenStorage opens the database in file, new databases are created in the
+// given format.
+func OpenStorage(file string, format StorageFormat) (Storage, error) {
+	format, err := DetectFormat(file, format)
+	if err != nil {
+		return nil, err
+	}
+	switch format {
+	case FormatJSON:
+		return &jsonStorage{file: file}, nil
+	case FormatSQLite:
+		s, err := OpenSQLite(file)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	}
+	return nil, fmt.Errorf("invalid database format '%s'", format)
+}
+
+// jsonStorage stores a header (with the containers) and a card per line as
+// json, every save rewrites the entire file.
+type jsonStorage struct {
+	file       string
+	upgrade    *SchemaUpgrade
+	containers []Container
+}
+
+type jsonHeader struct {
+	Schema     int         `json:"schema"`
+	Containers []Container `json:"containers,omitempty"`
+}
+
+func (s *jsonStorage) Load() ([]jsonCard, error) {
+	f, err := os.Open(s.file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	raw := make([]json.RawMessage, 0, 1024)
+	dec := json.NewDecoder(f)
+	for dec.More() {
+		var r json.RawMessage
+		if err := dec.Decode(&r); err != nil {
+			return nil, err
+		}
+		raw = append(raw, r)
+	}
+	f.Close()
+
+	version := schemaVersion
+	s.containers = nil
+	if len(raw) != 0 {
+		var h struct {
+			Schema     *int        `json:"schema"`
+			Containers []Container `json:"containers"`
+		}
+		if err := json.Unmarshal(raw[0], &h); err != nil {
+			return nil, err
+		}
+		version = 1
+		if h.Schema != nil {
+			version, raw = *h.Schema, raw[1:]
+			s.containers = h.Containers
+		}
+	}
+	if err := checkSchema(s.file, version); err != nil {
+		return nil, err
+	}
+
+	migrations := pendingMigrations(version)
+	if len(migrations) != 0 {
+		if raw, err = s.migrate(raw, version, migrations); err != nil {
+			return nil, err
+		}
+	}
+
+	list := make([]jsonCard, len(raw))
+	for i, r := range raw {
+		if err := json.Unmarshal(r, &list[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(migrations) != 0 {
+		err := s.write(s.containers, len(list), func(i int) jsonCard { return list[i] })
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return list, nil
+}
+
+// migrate backs up the database and upgrades the raw records from version.
+func (s *jsonStorage) migrate(raw []json.RawMessage, version int, migrations []schemaMigration) ([]json.RawMessage, error) {
+	backup, err := backupDB(s.file, version)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]jsonRecord, len(raw))
+	for i, r := range raw {
+		dec := json.NewDecoder(bytes.NewReader(r))
+		dec.UseNumber()
+		if err := dec.Decode(&records[i]); err != nil {
+			return nil, err
+		}
+	}
+	for _, m := range migrations {
+		if m.json == nil {
+			continue
+		}
+		if err := m.json(records); err != nil {
+			return nil, fmt.Errorf("schema migration to version %d (%s) failed: %w", m.version, m.desc, err)
+		}
+	}
+	for i, r := range records {
+		if raw[i], err = json.Marshal(r); err != nil {
+			return nil, err
+		}
+	}
+
+	s.upgrade = &SchemaUpgrade{version, schemaVersion, backup}
+	return raw, nil
+}
+
+func (s *jsonStorage) write(containers []Container, n int, card func(i int) jsonCard) error {
+	tmp := s.file + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(f)
+	err = enc.Encode(jsonHeader{schemaVersion, containers})
+	for i := 0; err == nil && i < n; i++ {
+		err = enc.Encode(card(i))
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, s.file)
+}
+
+func (s *jsonStorage) Containers() ([]Container, error) { return s.containers, nil }
+
+func (s *jsonStorage) Save(c Changes) error {
+	err := s.write(c.Containers, len(c.Cards), func(i int) jsonCard { return c.Cards[i].json() })
+	if err == nil {
+		s.containers = c.Containers
+	}
+	return err
+}
+
+func (s *jsonStorage) Upgraded() (SchemaUpgrade, bool) {
+	if s.upgrade == nil {
+		return SchemaUpgrade{}, false
+	}
+	return *s.upgrade, true
+}
+
+func (s *jsonStorage) Close() error { return nil }
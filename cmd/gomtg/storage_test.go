@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/frizinak/gomtg/mtgjson"
+)
+
+// openTestDB loads the database in file, new ones are created in format.
+func openTestDB(t *testing.T, file string, format StorageFormat) *DB {
+	t.Helper()
+	store, err := OpenStorage(file, format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := LoadDB(store)
+	if err != nil {
+		store.Close()
+		t.Fatal(err)
+	}
+	return db
+}
+
+// dumpDB returns the cards and containers in db as json.
+func dumpDB(t *testing.T, db *DB) string {
+	t.Helper()
+	var d struct {
+		Cards      []jsonCard
+		Containers []Container
+	}
+	for _, c := range db.Cards() {
+		d.Cards = append(d.Cards, c.json())
+	}
+	d.Containers = db.Containers()
+	b, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func addTestCard(db *DB, name, uuid, set string, added time.Time) *DBCard {
+	c := FromCard(db, Card{UUID: mtgjson.UUID(uuid), Name: name, SetCode: mtgjson.SetID(set), Number: "1"})
+	c.added = added
+	db.Add(c)
+	return c
+}
+
+func TestStorageRoundTrip(t *testing.T) {
+	added := time.Date(2021, 3, 4, 5, 6, 7, 8, time.UTC)
+	for _, format := range []StorageFormat{FormatJSON, FormatSQLite} {
+		t.Run(string(format), func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "collection.db")
+
+			db := openTestDB(t, file, format)
+			bolt := addTestCard(db, "Lightning Bolt", "aaaa", "LEA", added)
+			bolt.Tag([]string{"foil", "deck-burn"})
+			bolt.SetOwner("alice")
+			bolt.SetLocation(Location{"binder1", 12})
+			bolt.SetPricing(Pricing{T: added, EUR: 1.5, EURFoil: 3.25, USD: 2, USDFoil: 4})
+			fow := addTestCard(db, "Force of Will", "dddd", "ALL", added.Add(time.Hour))
+			fow.SetLoan(Loan{"bob", added.Add(24 * time.Hour)})
+			elves := addTestCard(db, "Llanowar Elves", "eeee", "M19", added.Add(2*time.Hour))
+			db.SetContainer(Container{"binder1", KindBinder, 360})
+			db.SetContainer(Container{"box", KindBox, 0})
+			if _, err := db.Save(); err != nil {
+				t.Fatal(err)
+			}
+			want := dumpDB(t, db)
+			db.Close()
+
+			if f, err := DetectFormat(file, ""); err != nil || f != format {
+				t.Fatalf("detected format %q (%v), want %q", f, err, format)
+			}
+			db = openTestDB(t, file, format)
+			if got := dumpDB(t, db); got != want {
+				t.Fatalf("after reload:\n%s\nwant:\n%s", got, want)
+			}
+
+			// changes are saved on top of the existing database.
+			bolt, _ = db.ByID(bolt.ID())
+			fow, _ = db.ByID(fow.ID())
+			elves, _ = db.ByID(elves.ID())
+			db.Delete(bolt)
+			fow.SetLoan(Loan{})
+			elves.Tag([]string{"played"})
+			addTestCard(db, "Lightning Helix", "cccc", "RAV", added.Add(3*time.Hour))
+			db.RemoveContainer("box")
+			if _, err := db.Save(); err != nil {
+				t.Fatal(err)
+			}
+			want = dumpDB(t, db)
+			db.Close()
+
+			db = openTestDB(t, file, format)
+			defer db.Close()
+			if got := dumpDB(t, db); got != want {
+				t.Fatalf("after saving changes:\n%s\nwant:\n%s", got, want)
+			}
+			if n := len(db.Cards()); n != 3 {
+				t.Fatalf("%d cards, want 3", n)
+			}
+		})
+	}
+}
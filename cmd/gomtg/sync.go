@@ -0,0 +1,368 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/nightlyone/lockfile"
+)
+
+// lockTimeout is how long a commit waits for the commit of another session.
+const lockTimeout = time.Second * 10
+
+// lockDB claims the lock on the database in file, it is only held while
+// writing so multiple sessions can share a database.
+func lockDB(file string) (unlock func(), err error) {
+	abs, err := filepath.Abs(file + ".lock")
+	if err != nil {
+		return nil, err
+	}
+	locker, err := lockfile.New(abs)
+	if err != nil {
+		return nil, fmt.Errorf("lockfile error: %w", err)
+	}
+
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		err := locker.TryLock()
+		if err == nil {
+			return func() { _ = locker.Unlock() }, nil
+		}
+		var tmp interface{ Temporary() bool }
+		if !errors.As(err, &tmp) || !tmp.Temporary() {
+			return nil, fmt.Errorf("failed to lock database: %w", err)
+		}
+		if time.Now().After(deadline) {
+			return nil, errors.New("could not lock the database, another session is still committing")
+		}
+		time.Sleep(time.Millisecond * 50)
+	}
+}
+
+// Conflict is a copy that was changed by this and another session, the
+// change of the other session was kept. Field is the conflicting change:
+// remap, owner, location, loan or card if the card was deleted by one of the
+// sessions.
+type Conflict struct {
+	ID      CopyID
+	Name    string
+	Field   string
+	Problem string
+}
+
+const (
+	conflictCard  = "card"
+	conflictRemap = "remap"
+	conflictOwner = "owner"
+	conflictLoc   = "location"
+	conflictLoan  = "loan"
+)
+
+func (c Conflict) String() string {
+	return fmt.Sprintf("%s %s: %s", c.ID, c.Name, c.Problem)
+}
+
+// SyncReport describes the changes of other sessions that were merged while
+// saving.
+type SyncReport struct {
+	Added     int
+	Removed   int
+	Changed   int
+	Conflicts []Conflict
+}
+
+func (r SyncReport) Empty() bool {
+	return r.Added == 0 && r.Removed == 0 && r.Changed == 0 && len(r.Conflicts) == 0
+}
+
+func (r SyncReport) String() string {
+	s := fmt.Sprintf(
+		"merged changes from other sessions: %d added, %d removed and %d changed cards",
+		r.Added,
+		r.Removed,
+		r.Changed,
+	)
+	if len(r.Conflicts) != 0 {
+		s += fmt.Sprintf(", %d conflicting changes were discarded", len(r.Conflicts))
+	}
+	return s
+}
+
+// Conflicted returns true if the given change to the copy with the given id
+// was discarded.
+func (r SyncReport) Conflicted(id CopyID, field string) bool {
+	for _, c := range r.Conflicts {
+		if c.ID == id && (c.Field == field || c.Field == conflictCard) {
+			return true
+		}
+	}
+	return false
+}
+
+// sameCard compares everything but pricing, which changes on every commit.
+func sameCard(a, b jsonCard) bool {
+	if a.ID != b.ID ||
+		!a.Added.Equal(b.Added) ||
+		a.Name != b.Name ||
+		a.UUID != b.UUID ||
+		a.SetID != b.SetID ||
+		a.Number != b.Number ||
+		a.Owner != b.Owner ||
+		a.Container != b.Container ||
+		a.Slot != b.Slot ||
+		!loanOf(a.Loan).Equal(loanOf(b.Loan)) ||
+		len(a.Tags) != len(b.Tags) {
+		return false
+	}
+	for i := range a.Tags {
+		if a.Tags[i] != b.Tags[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// merge updates db with disk, the cards as they are saved now. Changes made
+// by other sessions since the database was loaded or last saved (base) are
+// applied to db, its order is that of disk followed by the cards added in
+// this session. Tags are merged, the newest pricing wins and conflicting
+// changes (the same card remapped, given another owner, moved or lent by both
+// sessions, or changed by one and deleted by the other) are resolved in
+// favor of the other session as its changes are already saved.
+func (db *DB) merge(disk []jsonCard) SyncReport {
+	var r SyncReport
+	deleted := make(map[CopyID]struct{}, len(db.deleted))
+	for _, id := range db.deleted {
+		deleted[id] = struct{}{}
+	}
+	conflict := func(id CopyID, name, field, problem string) {
+		r.Conflicts = append(r.Conflicts, Conflict{id, name, field, problem})
+	}
+
+	data := make([]*DBCard, 0, len(disk)+len(db.dirty))
+	onDisk := make(map[CopyID]struct{}, len(disk))
+	for _, jc := range disk {
+		if _, ok := onDisk[jc.ID]; ok {
+			continue
+		}
+		onDisk[jc.ID] = struct{}{}
+		base, inBase := db.base[jc.ID]
+		c, ours := db.byID[jc.ID]
+		_, del := deleted[jc.ID]
+		_, dirty := db.dirty[c]
+		changed := inBase && !sameCard(base, jc)
+		if changed {
+			r.Changed++
+		}
+
+		switch {
+		case !inBase:
+			r.Added++
+			if ours {
+				// a card added in this session got the same copy id.
+				c.id = ""
+			}
+			data = append(data, fromJSON(db, jc))
+		case del:
+			if !changed {
+				continue
+			}
+			conflict(jc.ID, jc.Name, conflictCard, "deleted here but changed in another session")
+			delete(deleted, jc.ID)
+			data = append(data, fromJSON(db, jc))
+		case !ours:
+			data = append(data, fromJSON(db, jc))
+		case !dirty:
+			c.setJSON(jc)
+			data = append(data, c)
+		default:
+			if changed {
+				r.Conflicts = append(r.Conflicts, mergeCard(c, base, jc)...)
+			}
+			data = append(data, c)
+		}
+	}
+
+	for id, base := range db.base {
+		if _, ok := onDisk[id]; ok {
+			continue
+		}
+		if _, ok := deleted[id]; ok {
+			delete(deleted, id)
+			continue
+		}
+		r.Removed++
+		c := db.byID[id]
+		if _, dirty := db.dirty[c]; dirty && c != nil && !sameCard(base, c.json()) {
+			conflict(id, base.Name, conflictCard, "changed here but deleted in another session")
+		}
+		delete(db.dirty, c)
+	}
+
+	var added []*DBCard
+	for _, c := range db.data {
+		if _, ok := db.base[c.id]; !ok || c.id == "" {
+			added = append(added, c)
+		}
+	}
+
+	db.data = data
+	db.byID = make(map[CopyID]*DBCard, len(data)+len(added))
+	for _, c := range data {
+		db.byID[c.id] = c
+	}
+	db.deleted = db.deleted[:0]
+	for id := range deleted {
+		db.deleted = append(db.deleted, id)
+	}
+	for _, c := range added {
+		db.add(c)
+	}
+	db.rebuildUUIDs()
+
+	return r
+}
+
+// mergeCard applies the changes from base to theirs to c, which was changed
+// in this session, it returns the conflicts if both changed the same field.
+func mergeCard(c *DBCard, base, theirs jsonCard) []Conflict {
+	ours := c.json()
+	var conflicts []Conflict
+	if ours.UUID != theirs.UUID || ours.Name != theirs.Name || ours.SetID != theirs.SetID || ours.Number != theirs.Number {
+		switch {
+		case ours.UUID == base.UUID && ours.Name == base.Name && ours.SetID == base.SetID && ours.Number == base.Number:
+		case theirs.UUID == base.UUID && theirs.Name == base.Name && theirs.SetID == base.SetID && theirs.Number == base.Number:
+			theirs.UUID, theirs.Name, theirs.SetID, theirs.Number = ours.UUID, ours.Name, ours.SetID, ours.Number
+		default:
+			conflicts = append(conflicts, Conflict{
+				ours.ID,
+				ours.Name,
+				conflictRemap,
+				fmt.Sprintf("remapped to %s here but to %s in another session", ours.UUID, theirs.UUID),
+			})
+		}
+	}
+	if ours.Owner != theirs.Owner {
+		switch {
+		case ours.Owner == base.Owner:
+		case theirs.Owner == base.Owner:
+			theirs.Owner = ours.Owner
+		default:
+			conflicts = append(conflicts, Conflict{
+				ours.ID,
+				ours.Name,
+				conflictOwner,
+				fmt.Sprintf("given to '%s' here but to '%s' in another session", ours.Owner, theirs.Owner),
+			})
+		}
+	}
+	if ours.Container != theirs.Container || ours.Slot != theirs.Slot {
+		switch {
+		case ours.Container == base.Container && ours.Slot == base.Slot:
+		case theirs.Container == base.Container && theirs.Slot == base.Slot:
+			theirs.Container, theirs.Slot = ours.Container, ours.Slot
+		default:
+			conflicts = append(conflicts, Conflict{
+				ours.ID,
+				ours.Name,
+				conflictLoc,
+				fmt.Sprintf(
+					"moved to %s here but to %s in another session",
+					Location{ours.Container, ours.Slot},
+					Location{theirs.Container, theirs.Slot},
+				),
+			})
+		}
+	}
+	if ol, tl, bl := loanOf(ours.Loan), loanOf(theirs.Loan), loanOf(base.Loan); !ol.Equal(tl) {
+		switch {
+		case ol.Equal(bl):
+		case tl.Equal(bl):
+			theirs.Loan = ours.Loan
+		default:
+			conflicts = append(conflicts, Conflict{
+				ours.ID,
+				ours.Name,
+				conflictLoan,
+				fmt.Sprintf("%s here but %s in another session", ol, tl),
+			})
+		}
+	}
+
+	baseTags, theirTags := make(Tags), make(Tags)
+	baseTags.Add(base.Tags)
+	theirTags.Add(theirs.Tags)
+	tags := make(Tags)
+	for _, t := range ours.Tags {
+		if !baseTags.Contains(t) || theirTags.Contains(t) {
+			tags.Add([]string{t})
+		}
+	}
+	for _, t := range theirs.Tags {
+		if !baseTags.Contains(t) {
+			tags.Add([]string{t})
+		}
+	}
+	theirs.Tags = tags.Slice()
+
+	if ours.Pricing.T.After(theirs.Pricing.T) {
+		theirs.Pricing = ours.Pricing
+	}
+
+	theirs.ID, theirs.Added = ours.ID, ours.Added
+	c.setJSON(theirs)
+	return conflicts
+}
+
+// mergeContainers applies the containers added, changed or removed in this
+// session to disk, the containers as they are saved now. Containers changed
+// by both sessions keep the change of the other session.
+func (db *DB) mergeContainers(disk []Container) {
+	find := func(l []Container, name string) (Container, bool) {
+		for _, c := range l {
+			if c.Name == name {
+				return c, true
+			}
+		}
+		return Container{}, false
+	}
+
+	l := make([]Container, len(disk))
+	copy(l, disk)
+	for _, c := range db.containers {
+		base, inBase := find(db.baseContainers, c.Name)
+		if inBase && base == c {
+			continue
+		}
+		theirs, onDisk := find(disk, c.Name)
+		switch {
+		case !onDisk && inBase:
+			// removed in another session.
+		case !onDisk:
+			l = append(l, c)
+		case !inBase || theirs == base:
+			for i := range l {
+				if l[i].Name == c.Name {
+					l[i] = c
+				}
+			}
+		}
+	}
+	for _, base := range db.baseContainers {
+		if _, ok := find(db.containers, base.Name); ok {
+			continue
+		}
+		if theirs, ok := find(disk, base.Name); ok && theirs == base {
+			n := l[:0]
+			for _, c := range l {
+				if c.Name != base.Name {
+					n = append(n, c)
+				}
+			}
+			l = n
+		}
+	}
+	db.containers = l
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMergeConflicts(t *testing.T) {
+	since := time.Date(2022, 5, 6, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		// theirs is saved first by another session, ours is merged into it.
+		theirs, ours func(db *DB, c *DBCard)
+		conflict     string
+		// check gets the card as saved, nil if it was deleted.
+		check func(t *testing.T, c *DBCard)
+	}{
+		{
+			name: "tags",
+			theirs: func(db *DB, c *DBCard) {
+				c.Untag([]string{"deck"})
+				c.Tag([]string{"played"})
+			},
+			ours: func(db *DB, c *DBCard) { c.Tag([]string{"foil"}) },
+			check: func(t *testing.T, c *DBCard) {
+				if got, want := c.Tags(), []string{"foil", "played"}; !reflect.DeepEqual(got, want) {
+					t.Fatalf("tags %v, want %v", got, want)
+				}
+			},
+		},
+		{
+			name:     "owner",
+			theirs:   func(db *DB, c *DBCard) { c.SetOwner("carol") },
+			ours:     func(db *DB, c *DBCard) { c.SetOwner("bob") },
+			conflict: conflictOwner,
+			check: func(t *testing.T, c *DBCard) {
+				if c.Owner() != "carol" {
+					t.Fatalf("owner '%s', want 'carol'", c.Owner())
+				}
+			},
+		},
+		{
+			name:   "owner and location",
+			theirs: func(db *DB, c *DBCard) { c.SetOwner("carol") },
+			ours:   func(db *DB, c *DBCard) { c.SetLocation(Location{"box", 2}) },
+			check: func(t *testing.T, c *DBCard) {
+				if c.Owner() != "carol" || c.Location() != (Location{"box", 2}) {
+					t.Fatalf("owner '%s' and location %s, want 'carol' and box:2", c.Owner(), c.Location())
+				}
+			},
+		},
+		{
+			name:     "location",
+			theirs:   func(db *DB, c *DBCard) { c.SetLocation(Location{"box", 3}) },
+			ours:     func(db *DB, c *DBCard) { c.SetLocation(Location{"box", 2}) },
+			conflict: conflictLoc,
+			check: func(t *testing.T, c *DBCard) {
+				if c.Location() != (Location{"box", 3}) {
+					t.Fatalf("location %s, want box:3", c.Location())
+				}
+			},
+		},
+		{
+			name:     "loan",
+			theirs:   func(db *DB, c *DBCard) { c.SetLoan(Loan{"carol", since}) },
+			ours:     func(db *DB, c *DBCard) { c.SetLoan(Loan{"bob", since}) },
+			conflict: conflictLoan,
+			check: func(t *testing.T, c *DBCard) {
+				if c.Loan().To != "carol" {
+					t.Fatalf("lent to '%s', want 'carol'", c.Loan().To)
+				}
+			},
+		},
+		{
+			name:     "deleted here",
+			theirs:   func(db *DB, c *DBCard) { c.SetOwner("carol") },
+			ours:     func(db *DB, c *DBCard) { db.Delete(c) },
+			conflict: conflictCard,
+			check: func(t *testing.T, c *DBCard) {
+				if c == nil || c.Owner() != "carol" {
+					t.Fatal("card changed in another session was deleted")
+				}
+			},
+		},
+		{
+			name:     "deleted in another session",
+			theirs:   func(db *DB, c *DBCard) { db.Delete(c) },
+			ours:     func(db *DB, c *DBCard) { c.SetOwner("bob") },
+			conflict: conflictCard,
+			check: func(t *testing.T, c *DBCard) {
+				if c != nil {
+					t.Fatal("card deleted in another session was kept")
+				}
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "collection.db")
+			db := openTestDB(t, file, FormatJSON)
+			bolt := addTestCard(db, "Lightning Bolt", "aaaa", "LEA", since)
+			bolt.Tag([]string{"deck"})
+			bolt.SetOwner("alice")
+			bolt.SetLocation(Location{"box", 1})
+			addTestCard(db, "Force of Will", "dddd", "ALL", since)
+			if _, err := db.Save(); err != nil {
+				t.Fatal(err)
+			}
+			id := bolt.ID()
+			db.Close()
+
+			theirs := openTestDB(t, file, FormatJSON)
+			ours := openTestDB(t, file, FormatJSON)
+			c, _ := theirs.ByID(id)
+			test.theirs(theirs, c)
+			if _, err := theirs.Save(); err != nil {
+				t.Fatal(err)
+			}
+			theirs.Close()
+
+			c, _ = ours.ByID(id)
+			test.ours(ours, c)
+			if _, err := ours.Save(); err != nil {
+				t.Fatal(err)
+			}
+			conflicts := ours.Synced().Conflicts
+			ours.Close()
+
+			switch {
+			case test.conflict == "" && len(conflicts) != 0:
+				t.Fatalf("unexpected conflicts %v", conflicts)
+			case test.conflict != "" && (len(conflicts) != 1 || conflicts[0].Field != test.conflict || conflicts[0].ID != id):
+				t.Fatalf("conflicts %v, want one %s conflict", conflicts, test.conflict)
+			}
+
+			db = openTestDB(t, file, FormatJSON)
+			defer db.Close()
+			if db.Count("dddd") != 1 {
+				t.Fatal("unchanged card was lost")
+			}
+			c, _ = db.ByID(id)
+			test.check(t, c)
+		})
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/frizinak/gomtg/mtgjson"
+)
+
+//go:embed web.html
+var webHTML []byte
+
+// Web is a browser UI for the collection. All access to the app is
+// serialized through do, images are downloaded outside of it.
+type Web struct {
+	app    *App
+	images *ImageCache
+	do     func(func())
+
+	mutex   sync.Mutex
+	subs    map[chan []byte]struct{}
+	lastKey string
+	last    []byte
+}
+
+func NewWeb(app *App, images *ImageCache, do func(func())) *Web {
+	return &Web{
+		app:    app,
+		images: images,
+		do:     do,
+		subs:   make(map[chan []byte]struct{}),
+		last:   []byte("[]"),
+	}
+}
+
+type webCollection struct {
+	Total int          `json:"total"`
+	Page  int          `json:"page"`
+	Pages int          `json:"pages"`
+	Cards []ResultCard `json:"cards"`
+}
+
+type webCard struct {
+	ResultCard
+	Info []string `json:"info"`
+}
+
+// Publish sends cards to all connected browsers as the current options.
+// Only call from the goroutine that owns the app.
+func (w *Web) Publish(cards []Card) {
+	var key strings.Builder
+	for _, c := range cards {
+		key.WriteString(string(c.UUID))
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if key.String() == w.lastKey {
+		return
+	}
+	w.lastKey = key.String()
+
+	data, err := json.Marshal(w.app.ResultCards(cards))
+	if err != nil {
+		return
+	}
+	w.last = data
+	for ch := range w.subs {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- data
+	}
+}
+
+func (w *Web) subscribe() (chan []byte, func()) {
+	ch := make(chan []byte, 1)
+	w.mutex.Lock()
+	w.subs[ch] = struct{}{}
+	ch <- w.last
+	w.mutex.Unlock()
+	return ch, func() {
+		w.mutex.Lock()
+		delete(w.subs, ch)
+		w.mutex.Unlock()
+	}
+}
+
+func (w *Web) card(uuid mtgjson.UUID) (c Card, err error) {
+	w.do(func() {
+		var ok bool
+		if c, ok = w.app.Cards.ByUUID(uuid); !ok {
+			err = fmt.Errorf("card %w", errNotFound)
+		}
+	})
+	return
+}
+
+func (w *Web) Handler() http.Handler {
+	mux := http.NewServeMux()
+	handle := func(path string, h func(r *http.Request) (interface{}, error)) {
+		mux.HandleFunc(path, func(rw http.ResponseWriter, r *http.Request) {
+			res, err := h(r)
+			switch {
+			case errors.Is(err, errNotFound):
+				writeJSON(rw, http.StatusNotFound, jsonError{err.Error()})
+			case err != nil:
+				writeJSON(rw, http.StatusBadRequest, jsonError{err.Error()})
+			default:
+				writeJSON(rw, http.StatusOK, res)
+			}
+		})
+	}
+
+	mux.HandleFunc("/", func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(rw, r)
+			return
+		}
+		rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = rw.Write(webHTML)
+	})
+
+	handle("/web/collection", func(r *http.Request) (interface{}, error) {
+		q := r.URL.Query()
+		sort := Sort(q.Get("sort"))
+		if sort == "" {
+			sort = SortIndex
+		}
+		if !sort.Valid() {
+			return nil, fmt.Errorf("invalid sort '%s'", sort)
+		}
+		page, _ := strconv.Atoi(q.Get("page"))
+		per, _ := strconv.Atoi(q.Get("per"))
+		if page < 1 {
+			page = 1
+		}
+		if per < 1 || per > 500 {
+			per = 60
+		}
+
+		var res webCollection
+		w.do(func() {
+			set := mtgjson.SetID(strings.ToUpper(q.Get("set")))
+			st := State{Local: w.app.SearchLocal(strings.Fields(q.Get("q")), set), Sort: sort}
+			st.SortLocal(w.app)
+			if q.Get("desc") == "1" {
+				for i, j := 0, len(st.Local)-1; i < j; i, j = i+1, j-1 {
+					st.Local[i], st.Local[j] = st.Local[j], st.Local[i]
+				}
+			}
+
+			res.Total, res.Page = len(st.Local), page
+			res.Pages = (res.Total + per - 1) / per
+			from, to := (page-1)*per, page*per
+			if from > len(st.Local) {
+				from = len(st.Local)
+			}
+			if to > len(st.Local) {
+				to = len(st.Local)
+			}
+			res.Cards = w.app.ResultLocalCards(st.Local[from:to])
+		})
+		return res, nil
+	})
+
+	handle("/web/card/", func(r *http.Request) (interface{}, error) {
+		c, err := w.card(mtgjson.UUID(strings.TrimPrefix(r.URL.Path, "/web/card/")))
+		if err != nil {
+			return nil, err
+		}
+		var res webCard
+		w.do(func() {
+			res.ResultCard = w.app.ResultCards([]Card{c})[0]
+			res.Info, err = w.app.CardInfo(c)
+		})
+		return res, err
+	})
+
+	mux.HandleFunc("/web/image/", func(rw http.ResponseWriter, r *http.Request) {
+		c, err := w.card(mtgjson.UUID(strings.TrimPrefix(r.URL.Path, "/web/image/")))
+		if err != nil {
+			http.NotFound(rw, r)
+			return
+		}
+		url, err := c.ImageURL()
+		if err != nil {
+			http.NotFound(rw, r)
+			return
+		}
+		f, err := w.images.Open(url)
+		if errors.Is(err, errOffline) {
+			data, err := encodePNG(placeholderImage("offline"))
+			if err != nil {
+				http.Error(rw, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			rw.Header().Set("Content-Type", "image/png")
+			_, _ = rw.Write(data)
+			return
+		}
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		rw.Header().Set("Cache-Control", "max-age=86400")
+		http.ServeContent(rw, r, info.Name(), info.ModTime(), f)
+	})
+
+	mux.HandleFunc("/web/events", func(rw http.ResponseWriter, r *http.Request) {
+		flusher, ok := rw.(http.Flusher)
+		if !ok {
+			http.Error(rw, "streaming not supported", http.StatusInternalServerError)
+			return
+		}
+		rw.Header().Set("Content-Type", "text/event-stream")
+		rw.Header().Set("Cache-Control", "no-cache")
+
+		ch, unsubscribe := w.subscribe()
+		defer unsubscribe()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case data := <-ch:
+				fmt.Fprintf(rw, "event: options\ndata: %s\n\n", data)
+				flusher.Flush()
+			}
+		}
+	})
+
+	return mux
+}
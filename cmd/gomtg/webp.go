@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bytes"
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"sort"
+)
+
+// encodeWebP writes img as a lossless (VP8L) webp. Only the subtract green
+// transform and per channel prefix codes are used, no backward references.
+func encodeWebP(w io.Writer, img image.Image) error {
+	b := img.Bounds()
+	width, height := b.Dx(), b.Dy()
+	if width < 1 || height < 1 || width > 1<<14 || height > 1<<14 {
+		return errors.New("webp: invalid image dimensions")
+	}
+
+	argb := make([][4]uint8, 0, width*height)
+	opaque := true
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			if c.A != 255 {
+				opaque = false
+			}
+			// subtract green transform
+			argb = append(argb, [4]uint8{c.G, c.R - c.G, c.B - c.G, c.A})
+		}
+	}
+
+	var hist [4][]int
+	hist[0] = make([]int, 256+24)
+	for i := 1; i < 4; i++ {
+		hist[i] = make([]int, 256)
+	}
+	for _, p := range argb {
+		for i := range p {
+			hist[i][p[i]]++
+		}
+	}
+
+	bw := &bitWriter{}
+	bw.write(0x2f, 8)
+	bw.write(uint32(width-1), 14)
+	bw.write(uint32(height-1), 14)
+	alpha := uint32(1)
+	if opaque {
+		alpha = 0
+	}
+	bw.write(alpha, 1)
+	bw.write(0, 3)
+
+	bw.write(1, 1) // transform present
+	bw.write(2, 2) // subtract green
+	bw.write(0, 1) // no more transforms
+	bw.write(0, 1) // no color cache
+	bw.write(0, 1) // no meta prefix codes
+
+	codes := make([]prefixCode, 4)
+	for i := range hist {
+		codes[i] = writePrefixCode(bw, hist[i])
+	}
+	// distance code, unused
+	writePrefixCode(bw, []int{1})
+
+	for _, p := range argb {
+		for i := range p {
+			codes[i].write(bw, int(p[i]))
+		}
+	}
+
+	data := bw.bytes()
+	size := len(data)
+	pad := size & 1
+
+	hdr := bytes.NewBuffer(make([]byte, 0, 20))
+	hdr.WriteString("RIFF")
+	_ = binary.Write(hdr, binary.LittleEndian, uint32(4+8+size+pad))
+	hdr.WriteString("WEBPVP8L")
+	_ = binary.Write(hdr, binary.LittleEndian, uint32(size))
+	if _, err := w.Write(hdr.Bytes()); err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if pad != 0 {
+		_, err := w.Write([]byte{0})
+		return err
+	}
+	return nil
+}
+
+type bitWriter struct {
+	buf  []byte
+	acc  uint64
+	nacc uint
+}
+
+// write writes the n lowest bits of v, least significant bit first.
+func (b *bitWriter) write(v uint32, n uint) {
+	b.acc |= uint64(v) << b.nacc
+	b.nacc += n
+	for b.nacc >= 8 {
+		b.buf = append(b.buf, byte(b.acc))
+		b.acc >>= 8
+		b.nacc -= 8
+	}
+}
+
+func (b *bitWriter) bytes() []byte {
+	if b.nacc > 0 {
+		b.buf = append(b.buf, byte(b.acc))
+		b.acc, b.nacc = 0, 0
+	}
+	return b.buf
+}
+
+// prefixCode maps symbols to their (bit reversed) canonical code.
+type prefixCode struct {
+	codes   []uint32
+	lengths []uint8
+}
+
+func (p prefixCode) write(b *bitWriter, sym int) {
+	if n := p.lengths[sym]; n != 0 {
+		b.write(p.codes[sym], uint(n))
+	}
+}
+
+var codeLengthOrder = [19]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// writePrefixCode writes a prefix code for the given histogram and returns it.
+func writePrefixCode(b *bitWriter, hist []int) prefixCode {
+	used := make([]int, 0, 2)
+	for sym, n := range hist {
+		if n != 0 {
+			used = append(used, sym)
+		}
+	}
+	if len(used) == 0 {
+		used = append(used, 0)
+	}
+
+	// simple code: one or two symbols < 256, a single symbol takes 0 bits.
+	if len(used) <= 2 && used[len(used)-1] < 256 {
+		p := prefixCode{make([]uint32, len(hist)), make([]uint8, len(hist))}
+		b.write(1, 1)
+		b.write(uint32(len(used)-1), 1)
+		b.write(1, 1)
+		b.write(uint32(used[0]), 8)
+		if len(used) == 2 {
+			b.write(uint32(used[1]), 8)
+			p.codes[used[1]], p.lengths[used[0]], p.lengths[used[1]] = 1, 1, 1
+		}
+		return p
+	}
+
+	lengths := huffmanLengths(hist, 15)
+	p := prefixCode{canonicalCodes(lengths), lengths}
+
+	clHist := make([]int, 19)
+	for _, l := range lengths {
+		clHist[l]++
+	}
+	clLengths := huffmanLengths(clHist, 7)
+	clCodes := canonicalCodes(clLengths)
+
+	n := 19
+	for n > 4 && clLengths[codeLengthOrder[n-1]] == 0 {
+		n--
+	}
+	b.write(0, 1)
+	b.write(uint32(n-4), 4)
+	for i := 0; i < n; i++ {
+		b.write(uint32(clLengths[codeLengthOrder[i]]), 3)
+	}
+	b.write(0, 1) // max_symbol is the alphabet size
+	for _, l := range lengths {
+		b.write(clCodes[l], uint(clLengths[l]))
+	}
+
+	return p
+}
+
+type huffmanNode struct {
+	count int
+	syms  []int
+}
+
+type huffmanHeap []huffmanNode
+
+func (h huffmanHeap) Len() int            { return len(h) }
+func (h huffmanHeap) Less(i, j int) bool  { return h[i].count < h[j].count }
+func (h huffmanHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *huffmanHeap) Push(x interface{}) { *h = append(*h, x.(huffmanNode)) }
+func (h *huffmanHeap) Pop() interface{} {
+	old := *h
+	n := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return n
+}
+
+// huffmanLengths returns code lengths of at most max bits for hist. At least
+// two symbols get a length so the code is always complete.
+func huffmanLengths(hist []int, max uint8) []uint8 {
+	counts := make([]int, len(hist))
+	copy(counts, hist)
+	nonzero := 0
+	for _, n := range counts {
+		if n != 0 {
+			nonzero++
+		}
+	}
+	for i := 0; nonzero < 2 && i < len(counts); i++ {
+		if counts[i] == 0 {
+			counts[i] = 1
+			nonzero++
+		}
+	}
+
+	for {
+		lengths := make([]uint8, len(counts))
+		h := make(huffmanHeap, 0, nonzero)
+		for sym, n := range counts {
+			if n != 0 {
+				h = append(h, huffmanNode{n, []int{sym}})
+			}
+		}
+		heap.Init(&h)
+		for h.Len() > 1 {
+			a := heap.Pop(&h).(huffmanNode)
+			b := heap.Pop(&h).(huffmanNode)
+			for _, s := range a.syms {
+				lengths[s]++
+			}
+			for _, s := range b.syms {
+				lengths[s]++
+			}
+			heap.Push(&h, huffmanNode{a.count + b.count, append(a.syms, b.syms...)})
+		}
+
+		ok := true
+		for _, l := range lengths {
+			if l > max {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return lengths
+		}
+
+		// flatten the distribution until the tree is shallow enough.
+		for i, n := range counts {
+			if n != 0 {
+				counts[i] = n/2 + 1
+			}
+		}
+	}
+}
+
+// canonicalCodes assigns canonical codes to lengths, bit reversed so they
+// can be written least significant bit first.
+func canonicalCodes(lengths []uint8) []uint32 {
+	syms := make([]int, 0, len(lengths))
+	for s, l := range lengths {
+		if l != 0 {
+			syms = append(syms, s)
+		}
+	}
+	sort.SliceStable(syms, func(i, j int) bool { return lengths[syms[i]] < lengths[syms[j]] })
+
+	codes := make([]uint32, len(lengths))
+	code, prev := uint32(0), uint8(0)
+	for i, s := range syms {
+		l := lengths[s]
+		if i != 0 {
+			code++
+		}
+		code <<= l - prev
+		prev = l
+
+		var rev uint32
+		for j := uint8(0); j < l; j++ {
+			rev |= ((code >> j) & 1) << (l - 1 - j)
+		}
+		codes[s] = rev
+	}
+	return codes
+}
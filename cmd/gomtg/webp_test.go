@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+
+	"golang.org/x/image/webp"
+)
+
+func TestEncodeWebPRoundTrip(t *testing.T) {
+	solid := image.NewNRGBA(image.Rect(0, 0, 31, 17))
+	alpha := image.NewNRGBA(image.Rect(0, 0, 64, 40))
+	random := image.NewNRGBA(image.Rect(0, 0, 97, 53))
+	single := image.NewNRGBA(image.Rect(0, 0, 1, 1))
+	rnd := rand.New(rand.NewSource(1))
+	for y := 0; y < 53; y++ {
+		for x := 0; x < 97; x++ {
+			if x < 31 && y < 17 {
+				solid.SetNRGBA(x, y, color.NRGBA{200, 30, 90, 255})
+			}
+			if x < 64 && y < 40 {
+				alpha.SetNRGBA(x, y, color.NRGBA{uint8(x * 4), uint8(y * 6), 128, uint8(x * y)})
+			}
+			random.SetNRGBA(x, y, color.NRGBA{
+				uint8(rnd.Intn(256)),
+				uint8(rnd.Intn(256)),
+				uint8(rnd.Intn(256)),
+				uint8(rnd.Intn(256)),
+			})
+		}
+	}
+	single.SetNRGBA(0, 0, color.NRGBA{1, 2, 3, 4})
+
+	for name, img := range map[string]*image.NRGBA{
+		"solid":  solid,
+		"alpha":  alpha,
+		"random": random,
+		"single": single,
+	} {
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := encodeWebP(&buf, img); err != nil {
+				t.Fatal(err)
+			}
+			dec, err := webp.Decode(&buf)
+			if err != nil {
+				t.Fatalf("decode: %s", err)
+			}
+			if dec.Bounds() != img.Bounds() {
+				t.Fatalf("bounds %s, want %s", dec.Bounds(), img.Bounds())
+			}
+			b := img.Bounds()
+			for y := b.Min.Y; y < b.Max.Y; y++ {
+				for x := b.Min.X; x < b.Max.X; x++ {
+					got := color.NRGBAModel.Convert(dec.At(x, y)).(color.NRGBA)
+					want := img.NRGBAAt(x, y)
+					if got != want {
+						t.Fatalf("pixel %d,%d: %v, want %v", x, y, got, want)
+					}
+				}
+			}
+		})
+	}
+}
@@ -8,4 +8,5 @@ require (
 	github.com/mattn/go-runewidth v0.0.13
 	github.com/nightlyone/lockfile v1.0.0
 	golang.org/x/image v0.0.0-20210628002857-a66eb6448b8d
+	modernc.org/sqlite v1.17.3
 )